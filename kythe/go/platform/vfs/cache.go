@@ -0,0 +1,156 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vfs
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// A CachedReader is a Reader that keeps the contents of small files in memory,
+// so that opening them again does not require reading, and perhaps
+// decompressing, them from the underlying Reader.  It is safe for concurrent
+// use.
+type CachedReader struct {
+	r                       Reader
+	maxEntryBytes, maxBytes int64
+
+	mu           sync.Mutex
+	lru          *list.List               // of *cacheEntry, most recently used first
+	entries      map[string]*list.Element // by path
+	size         int64                    // the total size of the cached contents
+	hits, misses int64
+}
+
+type cacheEntry struct {
+	path string
+	data []byte
+}
+
+// CacheStats reports on the use of a CachedReader.
+type CacheStats struct {
+	Hits, Misses int64 // the number of calls to Open served from memory or not
+	Entries      int   // the number of files currently held in memory
+	Bytes        int64 // the total size of those files
+}
+
+// Cache returns a Reader that serves Open from memory for files of r whose
+// contents are at most maxEntryBytes long, keeping the most recently used such
+// files up to a total of maxTotalBytes.  Stat and Glob are passed through to r.
+// Files are cached by the path passed to Open, so the contents of r must not
+// change while the result is in use.
+func Cache(r Reader, maxEntryBytes, maxTotalBytes int64) *CachedReader {
+	if maxEntryBytes > maxTotalBytes {
+		maxEntryBytes = maxTotalBytes
+	}
+	return &CachedReader{
+		r:             r,
+		maxEntryBytes: maxEntryBytes,
+		maxBytes:      maxTotalBytes,
+		lru:           list.New(),
+		entries:       make(map[string]*list.Element),
+	}
+}
+
+// Stat implements part of the Reader interface.
+func (c *CachedReader) Stat(ctx context.Context, path string) (os.FileInfo, error) {
+	return c.r.Stat(ctx, path)
+}
+
+// Glob implements part of the Reader interface.
+func (c *CachedReader) Glob(ctx context.Context, glob string) ([]string, error) {
+	return c.r.Glob(ctx, glob)
+}
+
+// Open implements part of the Reader interface.  A file not already in memory
+// is read from the underlying Reader; if it turns out to be small enough, it is
+// added to the cache.
+func (c *CachedReader) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if data, ok := c.lookup(path); ok {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	rc, err := c.r.Open(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	// Read one byte more than the limit, to learn whether the file fits.
+	data, err := ioutil.ReadAll(io.LimitReader(rc, c.maxEntryBytes+1))
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	if int64(len(data)) > c.maxEntryBytes {
+		return readCloser{io.MultiReader(bytes.NewReader(data), rc), rc}, nil
+	}
+	rc.Close()
+	c.add(path, data)
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// lookup returns the cached contents of path, if any, and records a hit or a
+// miss.
+func (c *CachedReader) lookup(path string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elt, ok := c.entries[path]; ok {
+		c.hits++
+		c.lru.MoveToFront(elt)
+		return elt.Value.(*cacheEntry).data, true
+	}
+	c.misses++
+	return nil, false
+}
+
+// add caches data as the contents of path, evicting the least recently used
+// entries as needed to respect the limit on the total size.
+func (c *CachedReader) add(path string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[path]; ok {
+		return // added concurrently
+	}
+	c.entries[path] = c.lru.PushFront(&cacheEntry{path, data})
+	c.size += int64(len(data))
+	for c.size > c.maxBytes {
+		e := c.lru.Remove(c.lru.Back()).(*cacheEntry)
+		delete(c.entries, e.path)
+		c.size -= int64(len(e.data))
+	}
+}
+
+// readCloser combines a Reader with the Closer of its underlying source.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// Stats returns the current statistics for c.
+func (c *CachedReader) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{Hits: c.hits, Misses: c.misses, Entries: len(c.entries), Bytes: c.size}
+}
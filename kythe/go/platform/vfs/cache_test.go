@@ -0,0 +1,119 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vfs
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// fakeReader is a Reader over a map from paths to contents, which counts the
+// calls to Open and Stat.
+type fakeReader struct {
+	files map[string]string
+
+	mu           sync.Mutex
+	opens, stats int
+}
+
+func (f *fakeReader) Stat(_ context.Context, path string) (os.FileInfo, error) {
+	f.mu.Lock()
+	f.stats++
+	f.mu.Unlock()
+	if _, ok := f.files[path]; !ok {
+		return nil, &os.PathError{Op: "stat", Path: path, Err: os.ErrNotExist}
+	}
+	return nil, nil
+}
+
+func (f *fakeReader) Open(_ context.Context, path string) (io.ReadCloser, error) {
+	f.mu.Lock()
+	f.opens++
+	f.mu.Unlock()
+	data, ok := f.files[path]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return ioutil.NopCloser(strings.NewReader(data)), nil
+}
+
+func (f *fakeReader) Glob(_ context.Context, glob string) ([]string, error) {
+	var names []string
+	for name := range f.files {
+		if ok, _ := path.Match(glob, name); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func readFile(t *testing.T, r Reader, path string) string {
+	rc, err := r.Open(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Open %q: unexpected error: %v", path, err)
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("Read %q: unexpected error: %v", path, err)
+	}
+	return string(data)
+}
+
+func TestCache(t *testing.T) {
+	f := &fakeReader{files: map[string]string{
+		"a":   "aaaa",
+		"b":   "bbbb",
+		"c":   "cccc",
+		"big": "this file is too large to cache",
+	}}
+	c := Cache(f, 10, 8)
+
+	for _, path := range []string{"a", "a", "b", "a", "c", "big", "big"} {
+		if got, want := readFile(t, c, path), f.files[path]; got != want {
+			t.Errorf("Read %q: got %q, want %q", path, got, want)
+		}
+	}
+	// "a" was served from memory twice.  Adding "c" evicted "b", the least
+	// recently used entry, and "big" was never cached.
+	if got, want := c.Stats(), (CacheStats{Hits: 2, Misses: 5, Entries: 2, Bytes: 8}); got != want {
+		t.Errorf("Stats: got %+v, want %+v", got, want)
+	}
+	if f.opens != 5 {
+		t.Errorf("Got %d opens of the underlying reader, want 5", f.opens)
+	}
+	readFile(t, c, "b")
+	if got := c.Stats().Misses; got != 6 {
+		t.Errorf("Reading an evicted file: got %d misses, want 6", got)
+	}
+
+	if _, err := c.Open(context.Background(), "nonesuch"); err != os.ErrNotExist {
+		t.Errorf("Open nonesuch: got error %v, want %v", err, os.ErrNotExist)
+	}
+	if got, err := c.Glob(context.Background(), "?"); err != nil || len(got) != 3 {
+		t.Errorf("Glob: got %q, %v; want three names", got, err)
+	}
+}
@@ -0,0 +1,156 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gcs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"kythe.io/kythe/go/platform/vfs/httpzip"
+	"kythe.io/kythe/go/platform/vfs/zip"
+
+	"golang.org/x/net/context"
+	"google.golang.org/cloud/storage"
+)
+
+// apiBase is the root of the Cloud Storage JSON API.
+var apiBase = "https://www.googleapis.com/storage/v1"
+
+// A ReaderAt implements io.ReaderAt for an object in Google Cloud Storage by
+// means of ranged reads, so that an archive stored in GCS can be opened with
+// zip.OpenAt without downloading it.  Every read is of the generation of the
+// object current when the ReaderAt was created; if that generation is
+// replaced or deleted, reads fail rather than mixing data from two versions.
+type ReaderAt struct {
+	*httpzip.ReaderAt
+	generation int64
+}
+
+// NewReaderAt returns a ReaderAt for the object name in bucket.  Requests are
+// made with client, which must be authorized to read the object, as is the
+// client passed to cloud.NewContext; a single client may be shared by many
+// readers.  If client is nil, http.DefaultClient is used.  Requests that fail
+// with a timeout or a server error are retried.  The requests are bound to
+// ctx, which must remain valid while the ReaderAt is in use.
+func NewReaderAt(ctx context.Context, client *http.Client, bucket, name string) (*ReaderAt, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	client = &http.Client{
+		Transport: &retryTransport{base: client.Transport, attempts: maxAttempts},
+		Timeout:   client.Timeout,
+	}
+	obj := fmt.Sprintf("%s/b/%s/o/%s", apiBase, url.PathEscape(bucket), url.PathEscape(name))
+	req, err := http.NewRequest("GET", obj, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, storage.ErrObjectNotExist
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error reading metadata of gs://%s/%s: %s", bucket, name, resp.Status)
+	}
+	var meta struct {
+		Size       int64 `json:"size,string"`
+		Generation int64 `json:"generation,string"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		return nil, fmt.Errorf("error decoding metadata of gs://%s/%s: %v", bucket, name, err)
+	}
+
+	media := fmt.Sprintf("%s?alt=media&generation=%d", obj, meta.Generation)
+	r, err := httpzip.NewReaderAt(ctx, client, media, httpzip.KnownSize(meta.Size))
+	if err != nil {
+		return nil, err
+	}
+	return &ReaderAt{ReaderAt: r, generation: meta.Generation}, nil
+}
+
+// Generation returns the generation of the object that r reads.  Comparing it
+// with the current generation of the object reveals whether the object has
+// changed.
+func (r *ReaderAt) Generation() int64 { return r.generation }
+
+// OpenArchive returns a zip.FS for the zip archive stored as the object name
+// in bucket, read as described for NewReaderAt.  The options are passed to
+// zip.OpenAt.
+func OpenArchive(ctx context.Context, client *http.Client, bucket, name string, opts ...zip.Option) (zip.FS, error) {
+	r, err := NewReaderAt(ctx, client, bucket, name)
+	if err != nil {
+		return zip.FS{}, err
+	}
+	return zip.OpenAt(r, r.Size(), opts...)
+}
+
+// maxAttempts is the number of times a request is tried before its failure is
+// reported.
+const maxAttempts = 4
+
+// initialBackoff is the delay before the first retry, which doubles with each
+// further attempt.
+var initialBackoff = 100 * time.Millisecond
+
+// retryTransport retries requests that fail transiently, that is, with a
+// timeout, a 5xx status, or 429 Too Many Requests.  Only requests without a
+// body are retried, as all of those made by a ReaderAt are.
+type retryTransport struct {
+	base     http.RoundTripper // if nil, http.DefaultTransport
+	attempts int
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	backoff := initialBackoff
+	for i := 1; ; i++ {
+		resp, err := base.RoundTrip(req)
+		if i == t.attempts || req.Body != nil || !transient(resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		select {
+		case <-time.After(backoff):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		backoff *= 2
+	}
+}
+
+// transient reports whether a request that failed with the given response or
+// error is worth retrying.
+func transient(resp *http.Response, err error) bool {
+	if err != nil {
+		ne, ok := err.(net.Error)
+		return ok && ne.Timeout()
+	}
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+}
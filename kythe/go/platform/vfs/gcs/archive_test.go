@@ -0,0 +1,142 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gcs
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/cloud/storage"
+)
+
+// fakeGCS serves a single object in the manner of the Cloud Storage JSON API,
+// failing the first few requests with a server error.
+type fakeGCS struct {
+	bucket, name string
+	generation   int64
+	data         []byte
+
+	mu               sync.Mutex
+	failures, served int
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	fail := f.failures > 0
+	if fail {
+		f.failures--
+	} else {
+		f.served++
+	}
+	f.mu.Unlock()
+	if fail {
+		http.Error(w, "try again", http.StatusServiceUnavailable)
+		return
+	}
+	if req.URL.Path != fmt.Sprintf("/b/%s/o/%s", f.bucket, f.name) {
+		http.NotFound(w, req)
+		return
+	}
+	q := req.URL.Query()
+	if q.Get("alt") != "media" {
+		fmt.Fprintf(w, `{"size": "%d", "generation": "%d"}`, len(f.data), f.generation)
+	} else if q.Get("generation") != fmt.Sprint(f.generation) {
+		http.NotFound(w, req)
+	} else {
+		http.ServeContent(w, req, f.name, time.Time{}, bytes.NewReader(f.data))
+	}
+}
+
+func newFakeGCS(t *testing.T, failures int) (*fakeGCS, func()) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	f, err := w.Create("dir/file.txt")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	f.Write([]byte("contents"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	fake := &fakeGCS{bucket: "bucket", name: "path/to/archive.zip", generation: 17, data: buf.Bytes(), failures: failures}
+	s := httptest.NewServer(fake)
+	oldBase, oldBackoff := apiBase, initialBackoff
+	apiBase, initialBackoff = s.URL, time.Millisecond
+	return fake, func() {
+		s.Close()
+		apiBase, initialBackoff = oldBase, oldBackoff
+	}
+}
+
+func TestOpenArchive(t *testing.T) {
+	ctx := context.Background()
+	fake, done := newFakeGCS(t, 2)
+	defer done()
+
+	r, err := NewReaderAt(ctx, nil, fake.bucket, fake.name)
+	if err != nil {
+		t.Fatalf("NewReaderAt: %v", err)
+	}
+	if r.Generation() != fake.generation {
+		t.Errorf("Generation: got %d, want %d", r.Generation(), fake.generation)
+	}
+	if r.Size() != int64(len(fake.data)) {
+		t.Errorf("Size: got %d, want %d", r.Size(), len(fake.data))
+	}
+
+	z, err := OpenArchive(ctx, nil, fake.bucket, fake.name)
+	if err != nil {
+		t.Fatalf("OpenArchive: %v", err)
+	}
+	rc, err := z.Open(ctx, "dir/file.txt")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	got, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(got) != "contents" {
+		t.Errorf("Read: got %q, %v; want %q", got, err, "contents")
+	}
+
+	// Once the object is replaced, reads of the old generation fail.
+	fake.mu.Lock()
+	fake.generation++
+	fake.mu.Unlock()
+	if _, err := r.ReadAt(make([]byte, 4), 0); err == nil {
+		t.Error("ReadAt of a replaced generation: got nil error, want an error")
+	}
+}
+
+func TestRetries(t *testing.T) {
+	ctx := context.Background()
+	fake, done := newFakeGCS(t, maxAttempts)
+	defer done()
+	if _, err := NewReaderAt(ctx, nil, fake.bucket, fake.name); err == nil {
+		t.Errorf("NewReaderAt after %d failures: got nil error, want an error", maxAttempts)
+	}
+	if _, err := NewReaderAt(ctx, nil, fake.bucket, "missing"); err != storage.ErrObjectNotExist {
+		t.Errorf("NewReaderAt of a missing object: got error %v, want %v", err, storage.ErrObjectNotExist)
+	}
+}
@@ -0,0 +1,239 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vfs
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// doubleStar is the pattern segment matching zero or more path components.
+const doubleStar = "**"
+
+// Match reports whether name matches the slash-separated glob pattern.  The
+// syntax is that of path.Match, extended so that a segment consisting of "**"
+// matches zero or more complete path components.  Implementations of Reader
+// for archives use Match in their Glob methods, so that they agree on the
+// meaning of a pattern.
+func Match(pattern, name string) (bool, error) {
+	if !hasDoubleStar(pattern) {
+		return path.Match(pattern, name)
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+// CheckPattern returns path.ErrBadPattern if pattern is malformed.  Since Match
+// may not examine every segment of a pattern, an implementation of Glob should
+// call CheckPattern before matching any names.
+func CheckPattern(pattern string) error {
+	_, err := path.Match(pattern, "")
+	return err
+}
+
+// hasDoubleStar reports whether some segment of pattern is "**".
+func hasDoubleStar(pattern string) bool {
+	for _, seg := range strings.Split(pattern, "/") {
+		if seg == doubleStar {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSegments(pats, names []string) (bool, error) {
+	for len(pats) > 0 {
+		if pats[0] == doubleStar {
+			for len(pats) > 1 && pats[1] == doubleStar {
+				pats = pats[1:] // "**/**" is equivalent to "**"
+			}
+			if len(pats) == 1 {
+				return true, nil
+			}
+			for i := 0; i <= len(names); i++ {
+				if ok, err := matchSegments(pats[1:], names[i:]); ok || err != nil {
+					return ok, err
+				}
+			}
+			return false, nil
+		}
+		if len(names) == 0 {
+			return false, nil
+		}
+		if ok, err := path.Match(pats[0], names[0]); !ok || err != nil {
+			return false, err
+		}
+		pats, names = pats[1:], names[1:]
+	}
+	return len(names) == 0, nil
+}
+
+// maxBraceExpansions bounds the number of patterns to which ExpandBraces
+// expands a single pattern.
+const maxBraceExpansions = 1024
+
+// ExpandBraces returns the patterns denoted by pattern under shell brace
+// expansion, in which "{a,b,c}" stands for each of the alternatives a, b, and
+// c, so that "{cmd,internal}/**/*.go" expands to "cmd/**/*.go" and
+// "internal/**/*.go".  Alternatives may themselves contain braces.  A brace or
+// comma escaped by a backslash, or inside a character class, is literal, as is
+// a pair of braces enclosing no comma; escapes are kept in the results, which
+// path.Match then interprets.  The results are distinct and in sorted order.
+// ExpandBraces returns path.ErrBadPattern if the braces are unbalanced or the
+// expansion is too large.
+func ExpandBraces(pattern string) ([]string, error) {
+	seen := make(map[string]bool)
+	var n int
+	if err := expandBraces(pattern, seen, &n); err != nil {
+		return nil, err
+	}
+	patterns := make([]string, 0, len(seen))
+	for p := range seen {
+		patterns = append(patterns, p)
+	}
+	sort.Strings(patterns)
+	return patterns, nil
+}
+
+// expandBraces adds the expansions of pattern to seen, counting in *n the
+// expansions produced so far, duplicates included, so as to stop as soon as
+// there are too many rather than after enumerating them all.
+func expandBraces(pattern string, seen map[string]bool, n *int) error {
+	open, close, err := findBraces(pattern)
+	if err != nil {
+		return err
+	} else if open < 0 {
+		if *n++; *n > maxBraceExpansions {
+			return fmt.Errorf("pattern expands to more than %d patterns: %w", maxBraceExpansions, path.ErrBadPattern)
+		}
+		seen[pattern] = true
+		return nil
+	}
+	prefix, suffix := pattern[:open], pattern[close+1:]
+	done := make(map[string]bool)
+	for _, alt := range splitAlternatives(pattern[open+1 : close]) {
+		if done[alt] {
+			continue // a repeated alternative adds no expansions
+		}
+		done[alt] = true
+		if err := expandBraces(prefix+alt+suffix, seen, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findBraces returns the offsets of the first closing brace of pattern that
+// ends a group of alternatives, and of the brace opening it, or -1 and -1 if
+// there is no such group.
+func findBraces(pattern string) (open, close int, err error) {
+	type group struct {
+		open  int
+		comma bool
+	}
+	var stack []group
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '[':
+			// Skip the character class.
+			j := i + 1
+			for ; j < len(pattern) && pattern[j] != ']'; j++ {
+				if pattern[j] == '\\' {
+					j++
+				}
+			}
+			i = j
+		case '{':
+			stack = append(stack, group{open: i})
+		case ',':
+			if len(stack) > 0 {
+				stack[len(stack)-1].comma = true
+			}
+		case '}':
+			if len(stack) == 0 {
+				return -1, -1, path.ErrBadPattern
+			}
+			g := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if g.comma {
+				return g.open, i, nil
+			}
+		}
+	}
+	if len(stack) > 0 {
+		return -1, -1, path.ErrBadPattern
+	}
+	return -1, -1, nil
+}
+
+// splitAlternatives splits the contents of a group of alternatives at its
+// top-level commas.
+func splitAlternatives(s string) []string {
+	var alts []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				alts = append(alts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(alts, s[start:])
+}
+
+// GlobBraces returns the union of the paths of r matching each of the patterns
+// to which ExpandBraces expands pattern, in sorted order.
+func GlobBraces(ctx context.Context, r Reader, pattern string) ([]string, error) {
+	patterns, err := ExpandBraces(pattern)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range patterns {
+		if err := CheckPattern(p); err != nil {
+			return nil, err
+		}
+	}
+	seen := make(map[string]bool)
+	var names []string
+	for _, p := range patterns {
+		matches, err := r.Glob(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range matches {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
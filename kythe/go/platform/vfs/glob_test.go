@@ -0,0 +1,75 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vfs
+
+import (
+	"errors"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestExpandBraces(t *testing.T) {
+	for _, test := range []struct {
+		pattern string
+		want    []string
+	}{
+		{"*.go", []string{"*.go"}},
+		{"{cmd,internal}/**/*.go", []string{"cmd/**/*.go", "internal/**/*.go"}},
+		{"a{b,c}d{e,f}", []string{"abde", "abdf", "acde", "acdf"}},
+		{"{a,{b,c}x}y", []string{"ay", "bxy", "cxy"}},
+		{"{a,a}", []string{"a"}},
+		{"x{,y}", []string{"x", "xy"}},
+		{"{single}", []string{"{single}"}},
+		{"{a{b,c}}", []string{"{ab}", "{ac}"}},
+		{`\{a,b\}`, []string{`\{a,b\}`}},
+		{`{a\,b,c}`, []string{`a\,b`, "c"}},
+		{"[{,}]{x,y}", []string{"[{,}]x", "[{,}]y"}},
+		{strings.Repeat("{a,a}", 30), []string{strings.Repeat("a", 30)}},
+	} {
+		got, err := ExpandBraces(test.pattern)
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ExpandBraces %q: got %q, %v; want %q", test.pattern, got, err, test.want)
+		}
+	}
+	for _, bad := range []string{"{a,b", "a,b}", "{{a,b}", "{a,b}{c,d}{e,f}{g,h}{i,j}{k,l}{m,n}{o,p}{q,r}{s,t}{u,v}"} {
+		if _, err := ExpandBraces(bad); !errors.Is(err, path.ErrBadPattern) {
+			t.Errorf("ExpandBraces %q: got error %v, want %v", bad, err, path.ErrBadPattern)
+		}
+	}
+
+	// Expansion stops once the limit is passed, even if most of the
+	// expansions are duplicates, rather than enumerating all 2^40 of them.
+	huge := strings.Repeat("{x,xx}", 40)
+	if _, err := ExpandBraces(huge); !errors.Is(err, path.ErrBadPattern) {
+		t.Errorf("ExpandBraces %q: got error %v, want %v", huge, err, path.ErrBadPattern)
+	}
+}
+
+func TestGlobBraces(t *testing.T) {
+	f := &fakeReader{files: map[string]string{"a.go": "", "b.go": "", "c.txt": "", "d.md": ""}}
+	got, err := GlobBraces(context.Background(), f, "{a,b,*}.{go,txt}")
+	if want := []string{"a.go", "b.go", "c.txt"}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("GlobBraces: got %q, %v; want %q", got, err, want)
+	}
+	if _, err := GlobBraces(context.Background(), f, "{[,b}"); err != path.ErrBadPattern {
+		t.Errorf("GlobBraces with bad pattern: got error %v, want %v", err, path.ErrBadPattern)
+	}
+}
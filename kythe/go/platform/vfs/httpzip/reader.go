@@ -0,0 +1,294 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package httpzip reads zip archives served over HTTP without downloading
+// them in full.  A ReaderAt fetches the parts of the archive that are read by
+// means of HTTP range requests, so opening the archive with zip.OpenAt reads
+// only its central directory, and each entry is fetched when it is opened.
+package httpzip
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"kythe.io/kythe/go/platform/vfs/zip"
+
+	"golang.org/x/net/context"
+)
+
+// Default settings for a ReaderAt.
+const (
+	defaultBlockSize   = 64 << 10
+	defaultCacheBlocks = 64
+)
+
+// A ReaderAt implements io.ReaderAt for a resource served over HTTP by a
+// server that supports range requests.  The resource is read in blocks, and
+// the most recently used blocks are kept in memory, so that the many small
+// reads made when parsing an archive cost few requests.  Reads of several
+// blocks missing from the cache are coalesced into a single request.  A
+// ReaderAt is safe for concurrent use.
+type ReaderAt struct {
+	ctx       context.Context
+	client    *http.Client
+	url       string
+	size      int64
+	blockSize int64
+	maxBlocks int
+
+	mu     sync.Mutex
+	lru    *list.List              // of *block, most recently used first
+	blocks map[int64]*list.Element // by block number
+}
+
+type block struct {
+	n    int64 // the block number; the block begins at n*blockSize
+	data []byte
+}
+
+// An Option is a configurable setting for a ReaderAt.
+type Option func(*ReaderAt) error
+
+// BlockSize returns an Option that sets the number of bytes fetched by each
+// request to at least n, unless the read reaches the end of the resource.  The
+// default is 64 KiB.
+func BlockSize(n int) Option {
+	return func(r *ReaderAt) error {
+		if n <= 0 {
+			return fmt.Errorf("invalid block size %d", n)
+		}
+		r.blockSize = int64(n)
+		return nil
+	}
+}
+
+// CacheBlocks returns an Option that sets the number of blocks kept in memory
+// to n.  The default is 64.  If n is 0, nothing is cached, and every read
+// makes a request.
+func CacheBlocks(n int) Option {
+	return func(r *ReaderAt) error {
+		if n < 0 {
+			return fmt.Errorf("invalid cache size %d", n)
+		}
+		r.maxBlocks = n
+		return nil
+	}
+}
+
+// KnownSize returns an Option that sets the size of the resource to n, for
+// callers that already know it, so that no HEAD request is needed.
+func KnownSize(n int64) Option {
+	return func(r *ReaderAt) error {
+		if n < 0 {
+			return fmt.Errorf("invalid size %d", n)
+		}
+		r.size = n
+		return nil
+	}
+}
+
+// NewReaderAt returns a ReaderAt for the resource at url, whose size it
+// determines by a HEAD request unless the KnownSize option is given.  Requests
+// are made with client, or with http.DefaultClient if client is nil, and are
+// bound to ctx, which must remain valid while the ReaderAt is in use.  The
+// resource must not change meanwhile.
+func NewReaderAt(ctx context.Context, client *http.Client, url string, opts ...Option) (*ReaderAt, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	r := &ReaderAt{
+		ctx:       ctx,
+		client:    client,
+		url:       url,
+		size:      -1, // unknown
+		blockSize: defaultBlockSize,
+		maxBlocks: defaultCacheBlocks,
+		lru:       list.New(),
+		blocks:    make(map[int64]*list.Element),
+	}
+	for _, opt := range opts {
+		if err := opt(r); err != nil {
+			return nil, err
+		}
+	}
+	if r.size >= 0 {
+		return r, nil
+	}
+
+	req, err := http.NewRequest("HEAD", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("stat %s: %s", url, resp.Status)
+	} else if resp.ContentLength < 0 {
+		return nil, fmt.Errorf("stat %s: unknown size", url)
+	} else if resp.Header.Get("Accept-Ranges") == "none" {
+		return nil, fmt.Errorf("stat %s: %v", url, ErrNoRanges)
+	}
+	r.size = resp.ContentLength
+	return r, nil
+}
+
+// Open returns a zip.FS for the archive at url, read by a ReaderAt with the
+// default settings.  The options are passed to zip.OpenAt.
+func Open(ctx context.Context, client *http.Client, url string, opts ...zip.Option) (zip.FS, error) {
+	r, err := NewReaderAt(ctx, client, url)
+	if err != nil {
+		return zip.FS{}, err
+	}
+	return zip.OpenAt(r, r.Size(), opts...)
+}
+
+// ErrNoRanges is reported when the server does not honor range requests.
+var ErrNoRanges = errors.New("server does not support range requests")
+
+// Size returns the size of the resource in bytes.
+func (r *ReaderAt) Size() int64 { return r.size }
+
+// ReadAt implements the io.ReaderAt interface.
+func (r *ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	} else if off >= r.size {
+		return 0, io.EOF
+	}
+	end, eof := off+int64(len(p)), error(nil)
+	if end > r.size {
+		end, eof = r.size, io.EOF
+	}
+	if end == off {
+		return 0, nil
+	}
+
+	var n int
+	first, last := off/r.blockSize, (end-1)/r.blockSize
+	for i := first; i <= last; {
+		run := [][]byte{r.cached(i)}
+		if run[0] == nil {
+			// Fetch this block together with the missing blocks that follow.
+			j := i + 1
+			for j <= last && !r.has(j) {
+				j++
+			}
+			var err error
+			if run, err = r.fetch(i, j); err != nil {
+				return n, err
+			}
+		}
+		for _, data := range run {
+			if start := i * r.blockSize; off > start {
+				data = data[off-start:]
+			}
+			n += copy(p[n:], data)
+			i++
+		}
+	}
+	return n, eof
+}
+
+// cached returns the data of block n if it is in the cache, or else nil.
+func (r *ReaderAt) cached(n int64) []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if elt, ok := r.blocks[n]; ok {
+		r.lru.MoveToFront(elt)
+		return elt.Value.(*block).data
+	}
+	return nil
+}
+
+// has reports whether block n is in the cache.
+func (r *ReaderAt) has(n int64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.blocks[n]
+	return ok
+}
+
+// fetch reads blocks i through j-1 with a single request, adds them to the
+// cache, and returns their data.
+func (r *ReaderAt) fetch(i, j int64) ([][]byte, error) {
+	start, end := i*r.blockSize, j*r.blockSize
+	if end > r.size {
+		end = r.size
+	}
+	req, err := http.NewRequest("GET", r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+	resp, err := r.client.Do(req.WithContext(r.ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+	case resp.StatusCode == http.StatusOK && start == 0 && end == r.size:
+		// The whole resource was requested, and the server sent it as such.
+	case resp.StatusCode == http.StatusOK:
+		return nil, fmt.Errorf("read %s: %v", r.url, ErrNoRanges)
+	default:
+		return nil, fmt.Errorf("read %s: %s", r.url, resp.Status)
+	}
+	buf := make([]byte, end-start)
+	if _, err := io.ReadFull(resp.Body, buf); err != nil {
+		return nil, fmt.Errorf("read %s: %v", r.url, err)
+	}
+
+	var run [][]byte
+	for len(buf) > 0 {
+		k := r.blockSize
+		if k > int64(len(buf)) {
+			k = int64(len(buf))
+		}
+		run = append(run, buf[:k:k])
+		buf = buf[k:]
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for k, data := range run {
+		r.add(i+int64(k), data)
+	}
+	return run, nil
+}
+
+// add records the data of block n in the cache, evicting the least recently
+// used blocks to make room.  The caller must hold r.mu.
+func (r *ReaderAt) add(n int64, data []byte) {
+	if r.maxBlocks == 0 {
+		return
+	} else if elt, ok := r.blocks[n]; ok {
+		r.lru.MoveToFront(elt)
+		return
+	}
+	for r.lru.Len() >= r.maxBlocks {
+		elt := r.lru.Back()
+		r.lru.Remove(elt)
+		delete(r.blocks, elt.Value.(*block).n)
+	}
+	r.blocks[n] = r.lru.PushFront(&block{n: n, data: data})
+}
@@ -0,0 +1,224 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpzip
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// server serves data over HTTP with support for range requests, counting the
+// requests it receives and the bytes it sends.
+type server struct {
+	*httptest.Server
+	mu              sync.Mutex
+	requests, bytes int64
+}
+
+func newServer(data []byte, ranges bool) *server {
+	s := new(server)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s.mu.Lock()
+		s.requests++
+		s.mu.Unlock()
+		if !ranges {
+			req.Header.Del("Range")
+		}
+		cw := &countingWriter{ResponseWriter: w}
+		http.ServeContent(cw, req, "archive.zip", time.Time{}, bytes.NewReader(data))
+		s.mu.Lock()
+		s.bytes += cw.n
+		s.mu.Unlock()
+	}))
+	return s
+}
+
+func (s *server) counts() (requests, bytes int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests, s.bytes
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func randomBytes(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(1)).Read(data)
+	return data
+}
+
+func TestReadAt(t *testing.T) {
+	ctx := context.Background()
+	data := randomBytes(10000)
+	s := newServer(data, true)
+	defer s.Close()
+
+	r, err := NewReaderAt(ctx, nil, s.URL, BlockSize(100), CacheBlocks(8))
+	if err != nil {
+		t.Fatalf("NewReaderAt: %v", err)
+	}
+	if r.Size() != int64(len(data)) {
+		t.Errorf("Size: got %d, want %d", r.Size(), len(data))
+	}
+	rng := rand.New(rand.NewSource(2))
+	for i := 0; i < 200; i++ {
+		off, n := rng.Int63n(int64(len(data))), rng.Intn(1000)
+		got := make([]byte, n)
+		m, err := r.ReadAt(got, off)
+		want := data[off:]
+		if len(want) > n {
+			want = want[:n]
+		}
+		if !bytes.Equal(got[:m], want) {
+			t.Fatalf("ReadAt(%d bytes, %d): got %d bytes differing from the source", n, off, m)
+		}
+		if m < n && err != io.EOF {
+			t.Errorf("ReadAt(%d bytes, %d): got %d bytes, %v; want io.EOF", n, off, m, err)
+		} else if m == n && err != nil && err != io.EOF {
+			t.Errorf("ReadAt(%d bytes, %d): unexpected error: %v", n, off, err)
+		}
+	}
+	if _, err := r.ReadAt(make([]byte, 1), int64(len(data))); err != io.EOF {
+		t.Errorf("ReadAt at the end: got error %v, want io.EOF", err)
+	}
+}
+
+func TestCoalescing(t *testing.T) {
+	ctx := context.Background()
+	data := randomBytes(10000)
+	s := newServer(data, true)
+	defer s.Close()
+
+	r, err := NewReaderAt(ctx, nil, s.URL, BlockSize(100))
+	if err != nil {
+		t.Fatalf("NewReaderAt: %v", err)
+	}
+	before, _ := s.counts()
+	buf := make([]byte, 1000)
+	if _, err := r.ReadAt(buf[:10], 510); err != nil {
+		t.Fatalf("ReadAt: %v", err)
+	}
+	if _, err := r.ReadAt(buf, 0); err != nil { // blocks 0-9, of which 5 is cached
+		t.Fatalf("ReadAt: %v", err)
+	}
+	if _, err := r.ReadAt(buf[:50], 20); err != nil {
+		t.Fatalf("ReadAt: %v", err)
+	}
+	if !bytes.Equal(buf[:50], data[20:70]) {
+		t.Error("ReadAt: data differ from the source")
+	}
+	if after, _ := s.counts(); after-before != 3 {
+		t.Errorf("Got %d requests, want 3", after-before)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for i := 0; i < 100; i++ {
+		f, err := w.CreateHeader(&zip.FileHeader{Name: fmt.Sprintf("%02d", i), Method: zip.Store})
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		f.Write(randomBytes(100 << 10))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	s := newServer(buf.Bytes(), true)
+	defer s.Close()
+
+	z, err := Open(ctx, nil, s.URL)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	rc, err := z.Open(ctx, "42")
+	if err != nil {
+		t.Fatalf("Open %q: %v", "42", err)
+	}
+	got, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil || !bytes.Equal(got, randomBytes(100<<10)) {
+		t.Errorf("Read %q: got %d bytes, %v; want the original contents", "42", len(got), err)
+	}
+	if _, sent := s.counts(); sent > int64(buf.Len())/10 {
+		t.Errorf("Server sent %d bytes of a %d-byte archive, want at most a tenth", sent, buf.Len())
+	}
+}
+
+func TestErrors(t *testing.T) {
+	ctx := context.Background()
+	s := newServer(randomBytes(1000), false)
+	defer s.Close()
+
+	r, err := NewReaderAt(ctx, nil, s.URL, BlockSize(100))
+	if err != nil {
+		t.Fatalf("NewReaderAt: %v", err)
+	}
+	if _, err := r.ReadAt(make([]byte, 10), 100); err == nil {
+		t.Error("ReadAt without range support: got nil error, want an error")
+	}
+
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	defer notFound.Close()
+	if _, err := NewReaderAt(ctx, nil, notFound.URL); err == nil {
+		t.Error("NewReaderAt of a missing resource: got nil error, want an error")
+	}
+	if _, err := NewReaderAt(ctx, nil, s.URL, BlockSize(0)); err == nil {
+		t.Error("NewReaderAt with BlockSize(0): got nil error, want an error")
+	}
+}
+
+func TestKnownSize(t *testing.T) {
+	ctx := context.Background()
+	data := randomBytes(1000)
+	s := newServer(data, true)
+	defer s.Close()
+
+	r, err := NewReaderAt(ctx, nil, s.URL, KnownSize(int64(len(data))))
+	if err != nil {
+		t.Fatalf("NewReaderAt: %v", err)
+	}
+	if n, _ := s.counts(); n != 0 {
+		t.Errorf("NewReaderAt with KnownSize: got %d requests, want 0", n)
+	}
+	buf := make([]byte, 10)
+	if _, err := r.ReadAt(buf, 990); err != nil || !bytes.Equal(buf, data[990:]) {
+		t.Errorf("ReadAt: got %v, %v; want %v", buf, err, data[990:])
+	}
+}
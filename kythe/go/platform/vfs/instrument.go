@@ -0,0 +1,117 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vfs
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Hooks are callbacks through which an instrumented Reader reports on its use.
+// Any of them may be nil.  They may be called concurrently, and should return
+// quickly.
+type Hooks struct {
+	// OnOpen is called after each call to Open, with its duration and error.
+	OnOpen func(path string, d time.Duration, err error)
+
+	// OnReadBytes is called after each read from a file, with the number of
+	// bytes read.
+	OnReadBytes func(n int)
+
+	// OnClose is called when a file is closed, with the total number of bytes
+	// read from it.
+	OnClose func(path string, total int64)
+
+	// OnStat is called after each call to Stat, with its duration and error.
+	OnStat func(path string, d time.Duration, err error)
+
+	// OnGlob is called after each call to Glob, with its duration, the number
+	// of matches, and its error.
+	OnGlob func(glob string, d time.Duration, matches int, err error)
+}
+
+// Instrument returns a Reader that passes each call through to r, reporting on
+// the calls and on the data read from the files they open via h.
+func Instrument(r Reader, h Hooks) Reader { return instrumented{r, h} }
+
+type instrumented struct {
+	r Reader
+	h Hooks
+}
+
+// Stat implements part of the Reader interface.
+func (in instrumented) Stat(ctx context.Context, path string) (os.FileInfo, error) {
+	start := time.Now()
+	fi, err := in.r.Stat(ctx, path)
+	if in.h.OnStat != nil {
+		in.h.OnStat(path, time.Since(start), err)
+	}
+	return fi, err
+}
+
+// Open implements part of the Reader interface.
+func (in instrumented) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	start := time.Now()
+	rc, err := in.r.Open(ctx, path)
+	if in.h.OnOpen != nil {
+		in.h.OnOpen(path, time.Since(start), err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &countingReader{rc: rc, path: path, h: &in.h}, nil
+}
+
+// Glob implements part of the Reader interface.
+func (in instrumented) Glob(ctx context.Context, glob string) ([]string, error) {
+	start := time.Now()
+	names, err := in.r.Glob(ctx, glob)
+	if in.h.OnGlob != nil {
+		in.h.OnGlob(glob, time.Since(start), len(names), err)
+	}
+	return names, err
+}
+
+// countingReader reports the bytes read from a file to the hooks.
+type countingReader struct {
+	rc    io.ReadCloser
+	path  string
+	h     *Hooks
+	total int64
+}
+
+// Read implements the io.Reader interface.
+func (c *countingReader) Read(buf []byte) (int, error) {
+	n, err := c.rc.Read(buf)
+	c.total += int64(n)
+	if c.h.OnReadBytes != nil && n > 0 {
+		c.h.OnReadBytes(n)
+	}
+	return n, err
+}
+
+// Close implements the io.Closer interface.
+func (c *countingReader) Close() error {
+	err := c.rc.Close()
+	if c.h.OnClose != nil {
+		c.h.OnClose(c.path, c.total)
+	}
+	return err
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vfs
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestInstrument(t *testing.T) {
+	ctx := context.Background()
+	var opens, stats, globs []string
+	var read, closed int64
+	r := Instrument(&fakeReader{files: map[string]string{"a": "12345", "b": "678"}}, Hooks{
+		OnOpen: func(path string, _ time.Duration, err error) {
+			if err != nil {
+				path += " failed"
+			}
+			opens = append(opens, path)
+		},
+		OnReadBytes: func(n int) { read += int64(n) },
+		OnClose:     func(_ string, total int64) { closed += total },
+		OnStat:      func(path string, _ time.Duration, _ error) { stats = append(stats, path) },
+		OnGlob:      func(glob string, _ time.Duration, _ int, _ error) { globs = append(globs, glob) },
+	})
+
+	for _, path := range []string{"a", "b"} {
+		rc, err := r.Open(ctx, path)
+		if err != nil {
+			t.Fatalf("Open %q: unexpected error: %v", path, err)
+		}
+		ioutil.ReadAll(rc)
+		rc.Close()
+	}
+	if _, err := r.Open(ctx, "nonesuch"); err != os.ErrNotExist {
+		t.Errorf("Open nonesuch: got error %v, want %v", err, os.ErrNotExist)
+	}
+	r.Stat(ctx, "a")
+	r.Glob(ctx, "*")
+
+	if want := []string{"a", "b", "nonesuch failed"}; !equalStrings(opens, want) {
+		t.Errorf("OnOpen: got %q, want %q", opens, want)
+	}
+	if read != 8 || closed != 8 {
+		t.Errorf("Got %d bytes read and %d closed, want 8", read, closed)
+	}
+	if want := []string{"a"}; !equalStrings(stats, want) {
+		t.Errorf("OnStat: got %q, want %q", stats, want)
+	}
+	if want := []string{"*"}; !equalStrings(globs, want) {
+		t.Errorf("OnGlob: got %q, want %q", globs, want)
+	}
+
+	// A Reader with no hooks passes calls through.
+	if got := readFile(t, Instrument(&fakeReader{files: map[string]string{"a": "x"}}, Hooks{}), "a"); got != "x" {
+		t.Errorf("Read: got %q, want %q", got, "x")
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
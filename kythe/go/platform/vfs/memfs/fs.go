@@ -0,0 +1,149 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package memfs defines a VFS implementation that holds the contents of its
+// files in memory, for use in tests.  It follows the same conventions as the
+// archive-backed implementations in the zip and tar packages, so tests may use
+// it in their place.
+package memfs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"kythe.io/kythe/go/platform/vfs"
+
+	"golang.org/x/net/context"
+)
+
+var _ vfs.Reader = FS{}
+
+// FS implements the vfs.Reader interface over a map from slash-separated file
+// paths, such as "kythe/go/main.go", to their contents.  Directories are
+// implied by the paths of the files they contain.  An FS must not be modified
+// while it is in use.
+type FS map[string][]byte
+
+// errEscapesRoot is reported for paths that refer outside the root.
+var errEscapesRoot = fmt.Errorf("path escapes the root: %w", os.ErrInvalid)
+
+// cleanPath returns the lexically cleaned form of name, as used for lookups.
+// The empty path names the root, as does ".".
+func cleanPath(name string) (string, error) {
+	name = path.Clean(name)
+	if name == ".." || strings.HasPrefix(name, "../") {
+		return "", errEscapesRoot
+	}
+	return name, nil
+}
+
+// isDir reports whether some file of m lies beneath the directory dir.
+func (m FS) isDir(dir string) bool {
+	prefix := dir + "/"
+	for name := range m {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Stat implements part of vfs.Reader.  Files have mode 0444 and directories
+// have mode 0555, and all have the zero modification time.  If path names
+// neither a file nor a directory containing one, the error satisfies
+// os.IsNotExist.
+func (m FS) Stat(ctx context.Context, path string) (os.FileInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	name, err := cleanPath(path)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: path, Err: err}
+	}
+	base := name[strings.LastIndex(name, "/")+1:]
+	if data, ok := m[name]; ok {
+		return fileInfo{name: base, size: int64(len(data))}, nil
+	} else if name == "." || m.isDir(name) {
+		return fileInfo{name: base, mode: os.ModeDir | 0555}, nil
+	}
+	return nil, &os.PathError{Op: "stat", Path: path, Err: os.ErrNotExist}
+}
+
+// Open implements part of vfs.Reader.  If path does not name a file, the error
+// is os.ErrNotExist.
+func (m FS) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	name, err := cleanPath(path)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	data, ok := m[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Glob implements part of vfs.Reader, using vfs.Match to match the paths of
+// the files.  The matches are returned in sorted order.
+func (m FS) Glob(ctx context.Context, glob string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := vfs.CheckPattern(glob); err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range m {
+		if ok, err := vfs.Match(glob, name); err != nil {
+			return nil, err
+		} else if ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// fileInfo is an os.FileInfo for a file or directory of an FS.
+type fileInfo struct {
+	name string
+	size int64
+	mode os.FileMode // if zero, 0444
+}
+
+// These methods implement the os.FileInfo interface.
+func (f fileInfo) Name() string       { return f.name }
+func (f fileInfo) Size() int64        { return f.size }
+func (f fileInfo) ModTime() time.Time { return time.Time{} }
+func (f fileInfo) IsDir() bool        { return f.mode.IsDir() }
+func (f fileInfo) Sys() interface{}   { return nil }
+
+func (f fileInfo) Mode() os.FileMode {
+	if f.mode == 0 {
+		return 0444
+	}
+	return f.mode
+}
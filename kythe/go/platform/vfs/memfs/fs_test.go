@@ -0,0 +1,101 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package memfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestFS(t *testing.T) {
+	ctx := context.Background()
+	m := FS{
+		"a.txt":         []byte("alpha"),
+		"kythe/go/b.go": []byte("package b"),
+		"kythe/c.txt":   nil,
+	}
+
+	for path, wantDir := range map[string]bool{
+		"a.txt":            false,
+		"./kythe//go/b.go": false,
+		"kythe/c.txt":      false,
+		"kythe":            true,
+		"kythe/go/":        true,
+		"":                 true,
+	} {
+		if fi, err := m.Stat(ctx, path); err != nil {
+			t.Errorf("Stat %q: unexpected error: %v", path, err)
+		} else if fi.IsDir() != wantDir {
+			t.Errorf("Stat %q: got mode %v, want directory %v", path, fi.Mode(), wantDir)
+		}
+	}
+	if fi, err := m.Stat(ctx, "kythe/go/b.go"); err != nil || fi.Size() != 9 || fi.Name() != "b.go" {
+		t.Errorf("Stat: got %v, %v; want b.go with size 9", fi, err)
+	}
+	for _, path := range []string{"nonesuch", "kyth", "a.txt/x"} {
+		if _, err := m.Stat(ctx, path); !os.IsNotExist(err) {
+			t.Errorf("Stat %q: got error %v, want not-exist", path, err)
+		}
+	}
+	if _, err := m.Stat(ctx, "../a.txt"); err == nil {
+		t.Errorf("Stat %q: got nil error, want failure", "../a.txt")
+	}
+
+	rc, err := m.Open(ctx, "kythe/../a.txt")
+	if err != nil {
+		t.Fatalf("Open: unexpected error: %v", err)
+	}
+	if data, err := ioutil.ReadAll(rc); err != nil || string(data) != "alpha" {
+		t.Errorf("Read: got %q, %v; want %q", data, err, "alpha")
+	}
+	if _, err := m.Open(ctx, "kythe"); err != os.ErrNotExist {
+		t.Errorf("Open directory: got error %v, want %v", err, os.ErrNotExist)
+	}
+
+	for glob, want := range map[string][]string{
+		"*":         {"a.txt"},
+		"kythe/**":  {"kythe/c.txt", "kythe/go/b.go"},
+		"**/*.go":   {"kythe/go/b.go"},
+		"nonesuch*": nil,
+	} {
+		got, err := m.Glob(ctx, glob)
+		if err != nil {
+			t.Errorf("Glob %q: unexpected error: %v", glob, err)
+		} else if !equalStrings(got, want) {
+			t.Errorf("Glob %q: got %q, want %q", glob, got, want)
+		}
+	}
+	if _, err := m.Glob(ctx, "["); err != path.ErrBadPattern {
+		t.Errorf("Glob bad pattern: got error %v, want %v", err, path.ErrBadPattern)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,210 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vfs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// A RetryPolicy says which failed operations WithRetry tries again, how many
+// times, and how long it waits between the attempts.
+type RetryPolicy struct {
+	// MaxAttempts is the most times each operation is attempted, including the
+	// first; if it is less than 2, failures are not retried.
+	MaxAttempts int
+
+	// Backoff is the wait before the second attempt, which doubles after each
+	// further attempt, up to MaxBackoff if that is positive.
+	Backoff, MaxBackoff time.Duration
+
+	// Retryable reports whether an operation that failed with err should be
+	// tried again.  If it is nil, IsRetryable is used.  The end of a file is
+	// never retried.
+	Retryable func(err error) bool
+}
+
+// DefaultRetryPolicy is a RetryPolicy suitable for remote storage, making up
+// to four attempts over about a second.
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 4, Backoff: 100 * time.Millisecond, MaxBackoff: 2 * time.Second}
+
+// IsRetryable reports whether err might be transient.  It returns false for
+// errors that would recur on another attempt: those reporting that a file does
+// not exist, that permission is denied, that an argument is invalid, or that
+// an operation is not supported, and those of a context that is done.
+func IsRetryable(err error) bool {
+	switch {
+	case err == nil, os.IsNotExist(err), os.IsPermission(err):
+		return false
+	case errors.Is(err, os.ErrInvalid), errors.Is(err, ErrNotSupported),
+		errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	}
+	return true
+}
+
+// retry reports whether an operation that failed with err on the given
+// attempt should be tried again, waiting for the backoff before it returns
+// true.  Otherwise it returns the error to report, which is that of ctx if it
+// is done during the wait.
+func (p *RetryPolicy) retry(ctx context.Context, attempt int, err error) (bool, error) {
+	if attempt >= p.MaxAttempts || !p.retryable(err) {
+		return false, err
+	}
+	delay := p.Backoff
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || delay < p.MaxBackoff); i++ {
+		delay *= 2
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// retryable reports whether an operation that failed with err may be tried
+// again under p.
+func (p *RetryPolicy) retryable(err error) bool {
+	if err == nil || err == io.EOF {
+		return false
+	} else if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsRetryable(err)
+}
+
+// WithRetry returns a Reader that passes each call through to r, and retries
+// the calls that fail as policy allows, waiting between attempts unless the
+// context of the call is done.  Reads from the files it opens are retried as
+// well, resuming at the offset of the failed read, if the file implements
+// io.ReaderAt or io.Seeker so that it can be read from that offset again;
+// otherwise the errors of reads are returned as they are.
+func WithRetry(r Reader, policy RetryPolicy) Reader {
+	return retrying{r, &policy}
+}
+
+type retrying struct {
+	r Reader
+	p *RetryPolicy
+}
+
+// Stat implements part of the Reader interface.
+func (r retrying) Stat(ctx context.Context, path string) (os.FileInfo, error) {
+	for attempt := 1; ; attempt++ {
+		fi, err := r.r.Stat(ctx, path)
+		if again, err := r.p.retry(ctx, attempt, err); !again {
+			return fi, err
+		}
+	}
+}
+
+// Glob implements part of the Reader interface.
+func (r retrying) Glob(ctx context.Context, glob string) ([]string, error) {
+	for attempt := 1; ; attempt++ {
+		names, err := r.r.Glob(ctx, glob)
+		if again, err := r.p.retry(ctx, attempt, err); !again {
+			return names, err
+		}
+	}
+}
+
+// Open implements part of the Reader interface.
+func (r retrying) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	for attempt := 1; ; attempt++ {
+		rc, err := r.r.Open(ctx, path)
+		if again, err := r.p.retry(ctx, attempt, err); !again {
+			if err != nil {
+				return nil, err
+			}
+			rr := &retryReader{rc: rc, ctx: ctx, p: r.p}
+			rr.ra, _ = rc.(io.ReaderAt)
+			rr.seeker, _ = rc.(io.Seeker)
+			return rr, nil
+		}
+	}
+}
+
+// retryReader retries failed reads from rc at the offset where they began.
+type retryReader struct {
+	rc     io.ReadCloser
+	ra     io.ReaderAt // rc, if it is an io.ReaderAt
+	seeker io.Seeker   // rc, if it is an io.Seeker
+	ctx    context.Context
+	p      *RetryPolicy
+
+	pos    int64 // the offset of the next read
+	usePos bool  // read with ra, since a failed read left rc at an unknown offset
+}
+
+// Read implements the io.Reader interface.  A read that returns data along
+// with an error that may be retried returns only the data, so that the next
+// read makes a fresh attempt at the following offset; other errors are
+// returned along with the data.
+func (r *retryReader) Read(buf []byte) (int, error) {
+	n, err := r.read(buf)
+	if r.ra == nil && r.seeker == nil {
+		return n, err
+	}
+	for attempt := 1; n == 0; attempt++ {
+		again, werr := r.p.retry(r.ctx, attempt, err)
+		if !again {
+			err = werr
+			break
+		}
+		n, err = r.reread(buf)
+	}
+	r.pos += int64(n)
+	if n > 0 && r.p.retryable(err) {
+		err = nil
+	}
+	return n, err
+}
+
+// read reads from the current offset.
+func (r *retryReader) read(buf []byte) (int, error) {
+	if !r.usePos {
+		return r.rc.Read(buf)
+	}
+	n, err := r.ra.ReadAt(buf, r.pos)
+	if n > 0 && err == io.EOF {
+		err = nil // the next read reports the end
+	}
+	return n, err
+}
+
+// reread reads from the current offset after a failed read.
+func (r *retryReader) reread(buf []byte) (int, error) {
+	if r.ra != nil {
+		r.usePos = true
+	} else if _, err := r.seeker.Seek(r.pos, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return r.read(buf)
+}
+
+// Close implements the io.Closer interface.
+func (r *retryReader) Close() error { return r.rc.Close() }
@@ -0,0 +1,174 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+var errFlaky = errors.New("transient failure")
+
+// flakyReader is a Reader whose calls fail with errFlaky until each has been
+// made fails times, and whose files fail likewise on every readFails-th read.
+type flakyReader struct {
+	files            map[string]string
+	fails, readFails int
+	plain            bool  // hide the ReaderAt and Seeker methods of files
+	readErr          error // if non-nil, returned with the data of the first read
+	calls            map[string]int
+}
+
+func (f *flakyReader) fail(op string) bool {
+	if f.calls == nil {
+		f.calls = make(map[string]int)
+	}
+	f.calls[op]++
+	return f.calls[op] <= f.fails
+}
+
+func (f *flakyReader) Stat(_ context.Context, path string) (os.FileInfo, error) {
+	if f.fail("stat") {
+		return nil, errFlaky
+	} else if _, ok := f.files[path]; !ok {
+		return nil, &os.PathError{Op: "stat", Path: path, Err: os.ErrNotExist}
+	}
+	return nil, nil
+}
+
+func (f *flakyReader) Open(_ context.Context, path string) (io.ReadCloser, error) {
+	if f.fail("open") {
+		return nil, errFlaky
+	}
+	data, ok := f.files[path]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+	file := &flakyFile{Reader: strings.NewReader(data), every: f.readFails, err: f.readErr}
+	if f.plain {
+		return struct{ io.ReadCloser }{file}, nil
+	}
+	return file, nil
+}
+
+func (f *flakyReader) Glob(_ context.Context, glob string) ([]string, error) {
+	if f.fail("glob") {
+		return nil, errFlaky
+	}
+	return nil, nil
+}
+
+// flakyFile fails every so many reads, after reading part of the data, and
+// returns err along with the data of its first read.
+type flakyFile struct {
+	*strings.Reader
+	every, reads int
+	err          error
+}
+
+func (f *flakyFile) Read(buf []byte) (int, error) {
+	if f.reads++; f.reads == 1 && f.err != nil {
+		n, _ := f.Reader.Read(buf[:1])
+		return n, f.err
+	}
+	if f.every > 0 && f.reads%f.every == 0 {
+		f.Reader.Read(buf[:len(buf)/2]) // lose part of the data
+		return 0, errFlaky
+	}
+	if len(buf) > 3 {
+		buf = buf[:3]
+	}
+	return f.Reader.Read(buf)
+}
+
+func (f *flakyFile) Close() error { return nil }
+
+func TestWithRetry(t *testing.T) {
+	ctx := context.Background()
+	policy := RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+	const data = "the quick brown fox jumps over the lazy dog"
+	f := &flakyReader{files: map[string]string{"a": data}, fails: 2, readFails: 4}
+	r := WithRetry(f, policy)
+
+	if _, err := r.Stat(ctx, "a"); err != nil {
+		t.Errorf("Stat: unexpected error: %v", err)
+	}
+	if _, err := r.Glob(ctx, "*"); err != nil {
+		t.Errorf("Glob: unexpected error: %v", err)
+	}
+	if got := readFile(t, r, "a"); got != data {
+		t.Errorf("Read: got %q, want %q", got, data)
+	}
+	if _, err := r.Stat(ctx, "missing"); !os.IsNotExist(err) {
+		t.Errorf("Stat of missing file: got error %v, want not-exist", err)
+	}
+	if got := f.calls["stat"]; got != 4 {
+		t.Errorf("Stat calls: got %d, want 4", got)
+	}
+
+	// Failures beyond the attempts allowed are reported.
+	f = &flakyReader{files: map[string]string{"a": data}, fails: 3}
+	if _, err := WithRetry(f, policy).Stat(ctx, "a"); err != errFlaky {
+		t.Errorf("Stat failing %d times: got error %v, want %v", f.fails, err, errFlaky)
+	}
+
+	// Errors that the policy does not allow are not retried.
+	f = &flakyReader{files: map[string]string{"a": data}, fails: 1}
+	policy.Retryable = func(err error) bool { return false }
+	if _, err := WithRetry(f, policy).Stat(ctx, "a"); err != errFlaky {
+		t.Errorf("Stat with no retryable errors: got error %v, want %v", err, errFlaky)
+	}
+
+	// A read from a file that cannot be read again is not retried.
+	f = &flakyReader{files: map[string]string{"a": data}, readFails: 2, plain: true}
+	rc, err := WithRetry(f, policy).Open(ctx, "a")
+	if err != nil {
+		t.Fatalf("Open: unexpected error: %v", err)
+	}
+	if _, err := ioutil.ReadAll(rc); err != errFlaky {
+		t.Errorf("Read of plain file: got error %v, want %v", err, errFlaky)
+	}
+
+	// An error that may not be retried is returned along with the data read.
+	f = &flakyReader{files: map[string]string{"a": data}, readErr: os.ErrPermission}
+	rc, err = WithRetry(f, RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}).Open(ctx, "a")
+	if err != nil {
+		t.Fatalf("Open: unexpected error: %v", err)
+	}
+	if n, err := rc.Read(make([]byte, 8)); n != 1 || err != os.ErrPermission {
+		t.Errorf("Read with permanent error: got %d, %v; want 1, %v", n, err, os.ErrPermission)
+	}
+
+	// The backoff ends early if the context is done.
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	f = &flakyReader{files: map[string]string{"a": data}, fails: 2}
+	start := time.Now()
+	if _, err := WithRetry(f, RetryPolicy{MaxAttempts: 2, Backoff: time.Hour}).Stat(cctx, "a"); err != context.DeadlineExceeded {
+		t.Errorf("Stat with expired context: got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Minute {
+		t.Errorf("Stat with expired context took %v", elapsed)
+	}
+}
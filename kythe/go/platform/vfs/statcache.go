@@ -0,0 +1,117 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vfs
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// A StatCachedReader is a Reader that remembers the results of Stat for a
+// time, so that repeated calls for the same path do not reach the underlying
+// Reader.  It is safe for concurrent use.
+type StatCachedReader struct {
+	r   Reader
+	ttl time.Duration
+	now func() time.Time // the clock, replaced in tests
+
+	mu      sync.Mutex
+	entries map[string]statEntry // by path
+	sweep   int                  // sweep out expired entries when there are this many
+}
+
+type statEntry struct {
+	fi      os.FileInfo
+	err     error // if non-nil, an error satisfying os.IsNotExist
+	expires time.Time
+}
+
+// StatCache returns a Reader that serves Stat from memory for paths whose
+// status was obtained from r within the last ttl, including paths that r
+// reported do not exist.  Other errors are not remembered.  Open and Glob are
+// passed through to r.  This pays off for Readers whose Stat is expensive, such
+// as those reading remote archives; Invalidate forgets a path whose status may
+// have changed.
+func StatCache(r Reader, ttl time.Duration) *StatCachedReader {
+	return &StatCachedReader{r: r, ttl: ttl, now: time.Now, entries: make(map[string]statEntry)}
+}
+
+// Stat implements part of the Reader interface.
+func (c *StatCachedReader) Stat(ctx context.Context, path string) (os.FileInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	e, ok := c.entries[path]
+	c.mu.Unlock()
+	if ok && c.now().Before(e.expires) {
+		return e.fi, e.err
+	}
+
+	fi, err := c.r.Stat(ctx, path)
+	if err == nil || os.IsNotExist(err) {
+		c.add(path, statEntry{fi: fi, err: err, expires: c.now().Add(c.ttl)})
+	}
+	return fi, err
+}
+
+// add records e as the status of path, first removing the expired entries if
+// the cache has grown enough since that was last done.
+func (c *StatCachedReader) add(path string, e statEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.sweep {
+		now := c.now()
+		for p, old := range c.entries {
+			if !now.Before(old.expires) {
+				delete(c.entries, p)
+			}
+		}
+		c.sweep = 2*len(c.entries) + 64
+	}
+	c.entries[path] = e
+}
+
+// Invalidate forgets the status of path, so that the next call to Stat for it
+// reaches the underlying Reader.
+func (c *StatCachedReader) Invalidate(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, path)
+}
+
+// InvalidateAll forgets the status of every path.
+func (c *StatCachedReader) InvalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]statEntry)
+	c.sweep = 0
+}
+
+// Open implements part of the Reader interface.
+func (c *StatCachedReader) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	return c.r.Open(ctx, path)
+}
+
+// Glob implements part of the Reader interface.
+func (c *StatCachedReader) Glob(ctx context.Context, glob string) ([]string, error) {
+	return c.r.Glob(ctx, glob)
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vfs
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestStatCache(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeReader{files: map[string]string{"a": "aaaa", "b": "bbbb"}}
+	c := StatCache(f, time.Minute)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	stat := func(path string, wantStats int) {
+		t.Helper()
+		_, err := c.Stat(ctx, path)
+		if _, ok := f.files[path]; ok && err != nil {
+			t.Errorf("Stat %q: unexpected error: %v", path, err)
+		} else if !ok && !os.IsNotExist(err) {
+			t.Errorf("Stat %q: got error %v, want not-exist", path, err)
+		}
+		if f.stats != wantStats {
+			t.Errorf("After Stat %q: got %d calls of the underlying reader, want %d", path, f.stats, wantStats)
+		}
+	}
+	stat("a", 1)
+	stat("a", 1)
+	stat("missing", 2)
+	stat("missing", 2)
+	stat("b", 3)
+
+	c.Invalidate("a")
+	stat("a", 4)
+	stat("b", 4)
+
+	now = now.Add(time.Minute)
+	stat("b", 5)
+	stat("missing", 6)
+	stat("missing", 6)
+
+	c.InvalidateAll()
+	stat("b", 7)
+
+	if got := readFile(t, c, "a"); got != "aaaa" {
+		t.Errorf("Read %q: got %q, want %q", "a", got, "aaaa")
+	}
+	if _, err := c.Stat(canceledContext(), "a"); err != context.Canceled {
+		t.Errorf("Stat with cancelled context: got error %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestStatCacheConcurrent(t *testing.T) {
+	c := StatCache(&fakeReader{files: map[string]string{"a": "aaaa"}}, time.Millisecond)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				c.Stat(context.Background(), "a")
+				c.Stat(context.Background(), "b")
+				if j%10 == 0 {
+					c.Invalidate("a")
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func canceledContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
@@ -0,0 +1,361 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package tar defines a VFS implementation that understands a tar archive as
+// an isolated, read-only file system.  It mirrors the zip package, so that
+// callers may read either kind of archive through the same interface.
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"kythe.io/kythe/go/platform/vfs"
+
+	"golang.org/x/net/context"
+)
+
+var _ vfs.Reader = FS{}
+
+// FS implements the vfs.Reader interface for tar archives.  Since a tar
+// archive has no central directory, Open reads the whole archive once to build
+// an index of its entries.  If the source supports random access, the index
+// records where the data of each entry begin, and entries are read directly
+// from the source; otherwise the contents of every entry are held in memory.
+type FS struct {
+	r       io.ReaderAt
+	entries []*entry          // entries in archive order
+	files   map[string]*entry // the entry used for each name, without any trailing "/"
+	dirs    map[string]time.Time
+	closer  io.Closer // if non-nil, releases the temporary copy of the archive
+
+	spool      bool   // copy archives that lack random access to a temporary file
+	spoolDir   string // the directory for the temporary file
+	duplicates DuplicatePolicy
+}
+
+// An entry records the location or contents of one entry of the archive.
+type entry struct {
+	name   string // the cleaned name of the entry, as given by entryName
+	hdr    *tar.Header
+	offset int64  // the offset in the source of the data, if data == nil
+	data   []byte // the contents of the entry, if it was buffered
+}
+
+// Open returns an FS for the tar archive read from r.  If r implements both
+// io.ReaderAt and io.Seeker, as *os.File does, the contents of the entries are
+// read from r as needed, and r must remain valid while the FS is in use.
+// Otherwise the contents of every entry are held in memory, unless the
+// SpoolToFile option is given.
+func Open(r io.Reader, opts ...Option) (FS, error) {
+	var z FS
+	for _, opt := range opts {
+		if err := opt(&z); err != nil {
+			return FS{}, err
+		}
+	}
+	if _, ok := r.(randomAccess); !ok && z.spool {
+		f, err := spool(r, z.spoolDir)
+		if err != nil {
+			return FS{}, err
+		}
+		z.closer = f
+		r = f
+	}
+	if err := z.load(r); err != nil {
+		z.Close()
+		return FS{}, err
+	}
+	return z, nil
+}
+
+// OpenGz returns an FS for the gzip-compressed tar archive read from r.  Since
+// compressed data cannot be read at random, the contents of every entry are
+// held in memory, which suits small archives and makes Open fast.  For large
+// archives, the SpoolToFile option trades the memory for a temporary file
+// holding the decompressed archive, at the cost of writing it out in full
+// before the FS is returned; the FS must then be closed to remove the file.
+func OpenGz(r io.Reader, opts ...Option) (FS, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return FS{}, err
+	}
+	defer gz.Close()
+	return Open(gz, opts...)
+}
+
+// Close releases the temporary file holding the archive, if there is one.
+// Closing an FS has no effect on the reader from which it was opened.
+func (z FS) Close() error {
+	if z.closer == nil {
+		return nil
+	}
+	return z.closer.Close()
+}
+
+// randomAccess is implemented by sources from which entries can be read
+// directly.
+type randomAccess interface {
+	io.ReaderAt
+	io.Seeker
+}
+
+// load reads the archive from r and builds the index of z.
+func (z *FS) load(r io.Reader) error {
+	c := &counter{r: r}
+	src := io.Reader(c)
+	if ra, ok := r.(randomAccess); ok {
+		pos, err := ra.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		c.n = pos
+		src = seekCounter{c, ra}
+		z.r = ra
+	}
+
+	z.files, z.dirs = make(map[string]*entry), make(map[string]time.Time)
+	tr := tar.NewReader(src)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		name, ok := entryName(hdr.Name)
+		if !ok {
+			continue // ignore entries that refer outside the root
+		}
+		e := &entry{name: name, hdr: hdr, offset: c.n}
+		if z.r == nil || isSparse(hdr) {
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return fmt.Errorf("reading %q: %v", hdr.Name, err)
+			}
+			e.data = data
+		}
+		if err := z.add(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isSparse reports whether hdr describes a sparse file, whose data in the
+// archive are not the contents of the file.
+func isSparse(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for k := range hdr.PAXRecords {
+		if strings.HasPrefix(k, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// entryName returns the cleaned name of an archive entry, as lookups clean
+// the paths they are given, keeping any trailing "/" of a directory.  It
+// reports whether the name lies within the root of the archive, other than the
+// root itself.
+func entryName(name string) (string, bool) {
+	if name == "" || strings.HasPrefix(name, "/") {
+		return "", false
+	}
+	clean, err := cleanPath(name)
+	if err != nil || clean == "." {
+		return "", false
+	}
+	if strings.HasSuffix(name, "/") {
+		clean += "/"
+	}
+	return clean, true
+}
+
+// add records e in the index of z, following the duplicate policy of z if an
+// entry with the same name was already added.
+func (z *FS) add(e *entry) error {
+	z.entries = append(z.entries, e)
+	name := strings.TrimSuffix(e.name, "/")
+	if _, ok := z.files[name]; !ok || z.duplicates == LastEntryWins {
+		z.files[name] = e
+	} else if z.duplicates == RejectDuplicates {
+		return &os.PathError{Op: "open", Path: e.hdr.Name, Err: ErrDuplicateEntry}
+	}
+	t := e.hdr.ModTime
+	if e.hdr.Typeflag == tar.TypeDir {
+		z.addDir(name, t)
+	}
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		z.addDir(dir, t)
+	}
+	return nil
+}
+
+func (z *FS) addDir(dir string, t time.Time) {
+	if old, ok := z.dirs[dir]; !ok || t.After(old) {
+		z.dirs[dir] = t
+	}
+}
+
+// counter counts the bytes read from r, so that the offset of the data of each
+// entry is known.  Its count starts from the initial offset of r.
+type counter struct {
+	r io.Reader
+	n int64
+}
+
+// Read implements the io.Reader interface.
+func (c *counter) Read(buf []byte) (int, error) {
+	n, err := c.r.Read(buf)
+	c.n += int64(n)
+	return n, err
+}
+
+// seekCounter is a counter that lets the tar reader skip the data of entries
+// by seeking.
+type seekCounter struct {
+	*counter
+	s io.Seeker
+}
+
+// Seek implements the io.Seeker interface.
+func (s seekCounter) Seek(offset int64, whence int) (int64, error) {
+	pos, err := s.s.Seek(offset, whence)
+	if err == nil {
+		s.n = pos
+	}
+	return pos, err
+}
+
+// errEscapesRoot is reported for paths that refer outside the archive root.
+var errEscapesRoot = fmt.Errorf("path escapes the archive root: %w", os.ErrInvalid)
+
+// cleanPath returns the lexically cleaned form of name, as used for lookups.
+// The empty path names the root of the archive, as does ".".
+func cleanPath(name string) (string, error) {
+	name = path.Clean(name)
+	if name == ".." || strings.HasPrefix(name, "../") {
+		return "", errEscapesRoot
+	}
+	return name, nil
+}
+
+// Stat implements part of vfs.Reader using the headers of the tar archive.
+// The path must match one of the archive paths, or be a directory containing
+// one of them; otherwise, the error satisfies os.IsNotExist.
+func (z FS) Stat(ctx context.Context, path string) (os.FileInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	name, err := cleanPath(path)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: path, Err: err}
+	} else if name == "." {
+		return dirInfo{name: "."}, nil
+	}
+	if e := z.files[name]; e != nil {
+		return e.hdr.FileInfo(), nil
+	}
+	if t, ok := z.dirs[name]; ok {
+		return dirInfo{name: name[strings.LastIndex(name, "/")+1:], modTime: t}, nil
+	}
+	return nil, &os.PathError{Op: "stat", Path: path, Err: os.ErrNotExist}
+}
+
+// Open implements part of vfs.Reader, returning a reader for the contents of
+// the entry.  Like the other methods of FS, Open cleans the path before
+// looking it up, and rejects paths that refer outside the root.  It is safe to
+// open multiple files concurrently, provided the source of the archive
+// supports concurrent calls to ReadAt.
+func (z FS) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	name, err := cleanPath(path)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	e := z.files[name]
+	if e == nil {
+		return nil, os.ErrNotExist
+	}
+	if e.data != nil || z.r == nil {
+		return ioutil.NopCloser(bytes.NewReader(e.data)), nil
+	}
+	size := e.hdr.Size
+	if !hasData(e.hdr) {
+		size = 0
+	}
+	return ioutil.NopCloser(io.NewSectionReader(z.r, e.offset, size)), nil
+}
+
+// hasData reports whether the data of the entry described by hdr are stored in
+// the archive.
+func hasData(hdr *tar.Header) bool {
+	switch hdr.Typeflag {
+	case tar.TypeLink, tar.TypeSymlink, tar.TypeChar, tar.TypeBlock, tar.TypeDir, tar.TypeFifo:
+		return false
+	}
+	return true
+}
+
+// Glob implements part of vfs.Reader, using vfs.Match to match the names of
+// the entries of the archive.  The matches are returned in sorted order.
+func (z FS) Glob(ctx context.Context, glob string) ([]string, error) {
+	if err := vfs.CheckPattern(glob); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range z.entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if ok, err := vfs.Match(glob, e.name); err != nil {
+			return nil, err
+		} else if ok {
+			names = append(names, e.name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// dirInfo is a synthetic os.FileInfo for a directory that has no entry of its
+// own in the archive.
+type dirInfo struct {
+	name    string
+	modTime time.Time
+}
+
+// These methods implement the os.FileInfo interface.
+func (d dirInfo) Name() string       { return d.name }
+func (d dirInfo) Size() int64        { return 0 }
+func (d dirInfo) Mode() os.FileMode  { return os.ModeDir | 0555 }
+func (d dirInfo) ModTime() time.Time { return d.modTime }
+func (d dirInfo) IsDir() bool        { return true }
+func (d dirInfo) Sys() interface{}   { return nil }
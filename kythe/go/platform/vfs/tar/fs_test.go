@@ -0,0 +1,285 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// newArchive returns the bytes of a tar archive containing the given entries,
+// in order.  Names ending in "/" are written as directory entries; each file
+// contains "contents of " followed by its name.
+func newArchive(t *testing.T, names ...string) []byte {
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	for _, name := range names {
+		hdr := &tar.Header{Name: name, Mode: 0644, Typeflag: tar.TypeReg}
+		var data string
+		if name[len(name)-1] == '/' {
+			hdr.Typeflag, hdr.Mode = tar.TypeDir, 0755
+		} else {
+			data = "contents of " + name
+			hdr.Size = int64(len(data))
+		}
+		if err := w.WriteHeader(hdr); err != nil {
+			t.Fatalf("WriteHeader %q: %v", name, err)
+		}
+		if _, err := io.WriteString(w, data); err != nil {
+			t.Fatalf("Write %q: %v", name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// streamReader hides the random access methods of its reader.
+type streamReader struct{ io.Reader }
+
+func TestFS(t *testing.T) {
+	ctx := context.Background()
+	data := newArchive(t, "./", "./a/", "./a/b.txt", "c/d/e.go", "../evil.txt", "f.txt", "f.txt")
+
+	for _, test := range []struct {
+		desc     string
+		r        io.Reader
+		buffered bool
+	}{
+		{"random access", bytes.NewReader(data), false},
+		{"stream", streamReader{bytes.NewReader(data)}, true},
+	} {
+		z, err := Open(test.r)
+		if err != nil {
+			t.Fatalf("Open (%s): %v", test.desc, err)
+		}
+		if got := z.r == nil; got != test.buffered {
+			t.Errorf("Open (%s): got buffered %v, want %v", test.desc, got, test.buffered)
+		}
+
+		for name, want := range map[string]string{
+			"a/b.txt":  "contents of ./a/b.txt",
+			"c/d/e.go": "contents of c/d/e.go",
+			"./f.txt":  "contents of f.txt",
+		} {
+			rc, err := z.Open(ctx, name)
+			if err != nil {
+				t.Errorf("Open %q (%s): unexpected error: %v", name, test.desc, err)
+				continue
+			}
+			got, err := ioutil.ReadAll(rc)
+			rc.Close()
+			if err != nil || string(got) != want {
+				t.Errorf("Read %q (%s): got %q, %v; want %q", name, test.desc, got, err, want)
+			}
+		}
+		if _, err := z.Open(ctx, "evil.txt"); err != os.ErrNotExist {
+			t.Errorf("Open %q (%s): got error %v, want %v", "evil.txt", test.desc, err, os.ErrNotExist)
+		}
+		if _, err := z.Open(ctx, "../evil.txt"); err == nil {
+			t.Errorf("Open %q (%s): got nil error, want failure", "../evil.txt", test.desc)
+		}
+
+		for _, dir := range []string{"a", "c/d", "c"} {
+			if fi, err := z.Stat(ctx, dir); err != nil {
+				t.Errorf("Stat %q (%s): unexpected error: %v", dir, test.desc, err)
+			} else if !fi.IsDir() {
+				t.Errorf("Stat %q (%s): got mode %v, want a directory", dir, test.desc, fi.Mode())
+			}
+		}
+
+		if got, err := z.Glob(ctx, "**/*.*"); err != nil {
+			t.Errorf("Glob (%s): unexpected error: %v", test.desc, err)
+		} else if want := []string{"a/b.txt", "c/d/e.go", "f.txt", "f.txt"}; !equalStrings(got, want) {
+			t.Errorf("Glob (%s): got %q, want %q", test.desc, got, want)
+		}
+	}
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestOpenGz(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "tartest")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	data := gzipped(t, newArchive(t, "a/b.txt", "c.txt"))
+
+	for _, test := range []struct {
+		desc  string
+		opts  []Option
+		spool bool
+	}{
+		{"buffered", nil, false},
+		{"spooled", []Option{SpoolToFile(dir)}, true},
+	} {
+		z, err := OpenGz(bytes.NewReader(data), test.opts...)
+		if err != nil {
+			t.Fatalf("OpenGz (%s): %v", test.desc, err)
+		}
+		if got := z.closer != nil; got != test.spool {
+			t.Errorf("OpenGz (%s): got spooled %v, want %v", test.desc, got, test.spool)
+		}
+		rc, err := z.Open(ctx, "a/b.txt")
+		if err != nil {
+			t.Fatalf("Open (%s): unexpected error: %v", test.desc, err)
+		}
+		got, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if want := "contents of a/b.txt"; err != nil || string(got) != want {
+			t.Errorf("Read (%s): got %q, %v; want %q", test.desc, got, err, want)
+		}
+		if err := z.Close(); err != nil {
+			t.Errorf("Close (%s): unexpected error: %v", test.desc, err)
+		}
+	}
+
+	if names, err := ioutil.ReadDir(dir); err != nil {
+		t.Errorf("ReadDir: %v", err)
+	} else if len(names) != 0 {
+		t.Errorf("Temporary files remain after Close: %v", names)
+	}
+	if _, err := OpenGz(bytes.NewReader(newArchive(t, "a.txt"))); err == nil {
+		t.Error("OpenGz of an uncompressed archive: got nil error, want failure")
+	}
+}
+
+func TestNonCanonicalNames(t *testing.T) {
+	ctx := context.Background()
+	z, err := Open(bytes.NewReader(newArchive(t, "a//b.txt", "a/./c/", "a/./c/d.txt", "x/../y.txt")))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for name, want := range map[string]string{
+		"a/b.txt":   "contents of a//b.txt",
+		"a//b.txt":  "contents of a//b.txt",
+		"a/c/d.txt": "contents of a/./c/d.txt",
+		"y.txt":     "contents of x/../y.txt",
+	} {
+		rc, err := z.Open(ctx, name)
+		if err != nil {
+			t.Errorf("Open %q: unexpected error: %v", name, err)
+			continue
+		}
+		got, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil || string(got) != want {
+			t.Errorf("Read %q: got %q, %v; want %q", name, got, err, want)
+		}
+	}
+	if fi, err := z.Stat(ctx, "a/c"); err != nil || !fi.IsDir() {
+		t.Errorf("Stat %q: got %v, %v; want a directory", "a/c", fi, err)
+	}
+	if got, err := z.Glob(ctx, "a/*/*.txt"); err != nil || !equalStrings(got, []string{"a/c/d.txt"}) {
+		t.Errorf("Glob %q: got %q, %v; want %q", "a/*/*.txt", got, err, []string{"a/c/d.txt"})
+	}
+}
+
+func TestEmptyArchive(t *testing.T) {
+	ctx := context.Background()
+	z, err := Open(bytes.NewReader(newArchive(t)))
+	if err != nil {
+		t.Fatalf("Open of empty archive: unexpected error: %v", err)
+	}
+	if fi, err := z.Stat(ctx, "."); err != nil || !fi.IsDir() {
+		t.Errorf("Stat %q: got %v, %v; want a directory", ".", fi, err)
+	}
+	if got, err := z.Glob(ctx, "*"); err != nil || len(got) != 0 {
+		t.Errorf("Glob %q: got %q, %v; want no matches", "*", got, err)
+	}
+	if _, err := z.Stat(ctx, "a.txt"); !os.IsNotExist(err) {
+		t.Errorf("Stat %q: got error %v, want not exist", "a.txt", err)
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	for _, data := range []string{"first", "second"} {
+		if err := w.WriteHeader(&tar.Header{Name: "a.txt", Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(data))}); err != nil {
+			t.Fatalf("WriteHeader: %v", err)
+		}
+		io.WriteString(w, data)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	for _, test := range []struct {
+		opts []Option
+		want string
+	}{
+		{nil, "second"},
+		{[]Option{OnDuplicate(LastEntryWins)}, "second"},
+		{[]Option{OnDuplicate(FirstEntryWins)}, "first"},
+	} {
+		z, err := Open(bytes.NewReader(buf.Bytes()), test.opts...)
+		if err != nil {
+			t.Fatalf("Open: unexpected error: %v", err)
+		}
+		rc, err := z.Open(ctx, "a.txt")
+		if err != nil {
+			t.Fatalf("Open %q: unexpected error: %v", "a.txt", err)
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil || string(data) != test.want {
+			t.Errorf("Read %q: got %q, %v; want %q", "a.txt", data, err, test.want)
+		}
+	}
+
+	if _, err := Open(bytes.NewReader(buf.Bytes()), OnDuplicate(RejectDuplicates)); !errors.Is(err, ErrDuplicateEntry) {
+		t.Errorf("Open with RejectDuplicates: got error %v, want %v", err, ErrDuplicateEntry)
+	}
+	if _, err := Open(bytes.NewReader(buf.Bytes()), OnDuplicate(-1)); err == nil {
+		t.Error("Open with an invalid duplicate policy: got no error")
+	}
+}
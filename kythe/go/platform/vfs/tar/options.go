@@ -0,0 +1,105 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tar
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// An Option is a configurable setting for an FS, applied when it is opened.
+type Option func(*FS) error
+
+// SpoolToFile returns an Option that copies an archive whose source does not
+// support random access, such as a compressed stream, to a temporary file in
+// dir, from which its entries are then read as needed.  If dir is empty, the
+// default directory for temporary files is used.  The file is removed when the
+// FS is closed.
+func SpoolToFile(dir string) Option {
+	return func(z *FS) error {
+		z.spool, z.spoolDir = true, dir
+		return nil
+	}
+}
+
+// A DuplicatePolicy determines which entry is used when an archive has several
+// entries with the same name.  Glob still lists the name once for each entry.
+type DuplicatePolicy int
+
+const (
+	// LastEntryWins uses the last entry with each name, as tar itself does
+	// when extracting an archive.  It is the default.
+	LastEntryWins DuplicatePolicy = iota
+
+	// FirstEntryWins uses the first entry with each name.
+	FirstEntryWins
+
+	// RejectDuplicates makes opening the archive fail with an error wrapping
+	// ErrDuplicateEntry.
+	RejectDuplicates
+)
+
+// ErrDuplicateEntry is reported for archives having several entries with the
+// same name, when the RejectDuplicates policy is in effect.
+var ErrDuplicateEntry = errors.New("duplicate entry name")
+
+// OnDuplicate returns an Option that sets the policy for archives having
+// several entries with the same name, as appended archives often do.  A
+// directory entry "a/" has the same name as a file entry "a".
+func OnDuplicate(p DuplicatePolicy) Option {
+	return func(z *FS) error {
+		if p < LastEntryWins || p > RejectDuplicates {
+			return fmt.Errorf("invalid duplicate policy %d", p)
+		}
+		z.duplicates = p
+		return nil
+	}
+}
+
+// tempFile is a temporary file that is removed when it is closed.
+type tempFile struct{ *os.File }
+
+// Close implements the io.Closer interface.
+func (f tempFile) Close() error {
+	err := f.File.Close()
+	if rerr := os.Remove(f.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// spool copies the contents of r to a new temporary file in dir, positioned
+// at its start.
+func spool(r io.Reader, dir string) (tempFile, error) {
+	f, err := ioutil.TempFile(dir, "tar")
+	if err != nil {
+		return tempFile{}, err
+	}
+	tf := tempFile{f}
+	if _, err := io.Copy(f, r); err != nil {
+		tf.Close()
+		return tempFile{}, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		tf.Close()
+		return tempFile{}, err
+	}
+	return tf, nil
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vfs
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Throttle returns a Reader that passes each call through to r, but limits the
+// rate at which data can be read from the files it opens to bytesPerSec, in
+// total across all files.  The limit is enforced by a token bucket holding a
+// tenth of a second's worth of bytes, so short bursts may exceed the rate.  A
+// read that must wait for the bucket to refill fails with the error of the
+// context passed to Open, if that context is done before the wait ends.  If
+// bytesPerSec is not positive, r is returned unchanged.
+func Throttle(r Reader, bytesPerSec int64) Reader {
+	if bytesPerSec <= 0 {
+		return r
+	}
+	burst := bytesPerSec / 10
+	if burst < 1 {
+		burst = 1
+	}
+	return throttled{r, &limiter{rate: float64(bytesPerSec), burst: burst, tokens: float64(burst), last: time.Now()}}
+}
+
+type throttled struct {
+	r Reader
+	l *limiter
+}
+
+// Stat implements part of the Reader interface.
+func (t throttled) Stat(ctx context.Context, path string) (os.FileInfo, error) {
+	return t.r.Stat(ctx, path)
+}
+
+// Glob implements part of the Reader interface.
+func (t throttled) Glob(ctx context.Context, glob string) ([]string, error) {
+	return t.r.Glob(ctx, glob)
+}
+
+// Open implements part of the Reader interface.
+func (t throttled) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	rc, err := t.r.Open(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return &throttledReader{rc: rc, ctx: ctx, l: t.l}, nil
+}
+
+// throttledReader charges the bytes read from a file to a limiter.
+type throttledReader struct {
+	rc  io.ReadCloser
+	ctx context.Context
+	l   *limiter
+}
+
+// Read implements the io.Reader interface.  No single read returns more bytes
+// than the bucket holds.
+func (t *throttledReader) Read(buf []byte) (int, error) {
+	if int64(len(buf)) > t.l.burst {
+		buf = buf[:t.l.burst]
+	}
+	n, err := t.rc.Read(buf)
+	if werr := t.l.wait(t.ctx, n); werr != nil {
+		return n, werr
+	}
+	return n, err
+}
+
+// Close implements the io.Closer interface.
+func (t *throttledReader) Close() error { return t.rc.Close() }
+
+// A limiter is a token bucket, holding up to burst tokens, that refills at a
+// constant rate.
+type limiter struct {
+	rate  float64 // tokens per second
+	burst int64
+
+	mu     sync.Mutex
+	tokens float64 // may be negative, when readers are waiting
+	last   time.Time
+}
+
+// wait takes n tokens from the bucket, and blocks until the bucket would no
+// longer be in debt or ctx is done.
+func (l *limiter) wait(ctx context.Context, n int) error {
+	if n == 0 {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > float64(l.burst) {
+		l.tokens = float64(l.burst)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	debt := -l.tokens
+	l.mu.Unlock()
+
+	if debt <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(time.Duration(debt / l.rate * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
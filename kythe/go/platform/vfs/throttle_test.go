@@ -0,0 +1,58 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vfs
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestThrottle(t *testing.T) {
+	const rate = 20000 // bytes per second
+	data := strings.Repeat("x", 6000)
+	r := Throttle(&fakeReader{files: map[string]string{"a": data, "b": data}}, rate)
+
+	// The bucket starts full, so the time to read the data is at least that
+	// needed to refill it with the remainder.
+	start := time.Now()
+	if got := readFile(t, r, "a"); got != data {
+		t.Errorf("Read: got %d bytes, want %d", len(got), len(data))
+	}
+	elapsed := time.Since(start)
+	if min := time.Duration(len(data)-rate/10) * time.Second / rate; elapsed < min {
+		t.Errorf("Reading %d bytes at %d bytes/s took %v, want at least %v", len(data), rate, elapsed, min)
+	} else if elapsed > 10*min {
+		t.Errorf("Reading %d bytes at %d bytes/s took %v, want about %v", len(data), rate, elapsed, min)
+	}
+
+	// A cancelled context interrupts a read that must wait.
+	ctx, cancel := context.WithCancel(context.Background())
+	rc, err := r.Open(ctx, "b")
+	if err != nil {
+		t.Fatalf("Open: unexpected error: %v", err)
+	}
+	defer rc.Close()
+	cancel()
+	if _, err := io.Copy(ioutil.Discard, rc); err != context.Canceled {
+		t.Errorf("Read after cancellation: got error %v, want %v", err, context.Canceled)
+	}
+}
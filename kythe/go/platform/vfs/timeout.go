@@ -0,0 +1,130 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vfs
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// WithTimeout returns a Reader that passes each call through to r with a
+// context whose deadline is d after the call begins.  The deadline is enforced
+// even if r ignores its context: the call is made in its own goroutine, and
+// fails with the error of the context once the context is done, leaving the
+// goroutine to finish in the background, whereupon any file it opened is
+// closed.  Since the files opened by some Readers consult the context passed
+// to Open as they are read, that context is released only when the file is
+// closed.  If d is not positive, r is returned unchanged.
+func WithTimeout(r Reader, d time.Duration) Reader {
+	if d <= 0 {
+		return r
+	}
+	return timeout{r, d}
+}
+
+type timeout struct {
+	r Reader
+	d time.Duration
+}
+
+// Stat implements part of the Reader interface.
+func (t timeout) Stat(ctx context.Context, path string) (os.FileInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	type result struct {
+		fi  os.FileInfo
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		fi, err := t.r.Stat(ctx, path)
+		ch <- result{fi, err}
+	}()
+	select {
+	case res := <-ch:
+		return res.fi, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Glob implements part of the Reader interface.
+func (t timeout) Glob(ctx context.Context, glob string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	type result struct {
+		names []string
+		err   error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		names, err := t.r.Glob(ctx, glob)
+		ch <- result{names, err}
+	}()
+	select {
+	case res := <-ch:
+		return res.names, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Open implements part of the Reader interface.
+func (t timeout) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.d)
+	type result struct {
+		rc  io.ReadCloser
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		rc, err := t.r.Open(ctx, path)
+		ch <- result{rc, err}
+	}()
+	select {
+	case res := <-ch:
+		if res.err != nil {
+			cancel()
+			return nil, res.err
+		}
+		return timeoutFile{res.rc, cancel}, nil
+	case <-ctx.Done():
+		cancel()
+		go func() {
+			if res := <-ch; res.err == nil {
+				res.rc.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// timeoutFile releases the context of the Open call that returned it when it
+// is closed.
+type timeoutFile struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close implements the io.Closer interface.
+func (f timeoutFile) Close() error {
+	defer f.cancel()
+	return f.ReadCloser.Close()
+}
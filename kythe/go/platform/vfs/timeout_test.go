@@ -0,0 +1,107 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vfs
+
+import (
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// stuckReader is a Reader whose calls ignore their context and block until
+// release is closed.
+type stuckReader struct {
+	release chan struct{}
+
+	mu     sync.Mutex
+	closed int // the number of files closed
+}
+
+func (s *stuckReader) Stat(context.Context, string) (os.FileInfo, error) {
+	<-s.release
+	return nil, nil
+}
+
+func (s *stuckReader) Glob(context.Context, string) ([]string, error) {
+	<-s.release
+	return nil, nil
+}
+
+func (s *stuckReader) Open(context.Context, string) (io.ReadCloser, error) {
+	<-s.release
+	return stuckFile{s}, nil
+}
+
+type stuckFile struct{ s *stuckReader }
+
+func (stuckFile) Read([]byte) (int, error) { return 0, io.EOF }
+
+func (f stuckFile) Close() error {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	f.s.closed++
+	return nil
+}
+
+func TestWithTimeout(t *testing.T) {
+	ctx := context.Background()
+	s := &stuckReader{release: make(chan struct{})}
+	r := WithTimeout(s, 10*time.Millisecond)
+
+	if _, err := r.Stat(ctx, "a"); err != context.DeadlineExceeded {
+		t.Errorf("Stat: got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if _, err := r.Glob(ctx, "*"); err != context.DeadlineExceeded {
+		t.Errorf("Glob: got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if _, err := r.Open(ctx, "a"); err != context.DeadlineExceeded {
+		t.Errorf("Open: got error %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// Once the calls can finish, the file opened too late is closed.
+	close(s.release)
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(time.Millisecond) {
+		s.mu.Lock()
+		closed := s.closed
+		s.mu.Unlock()
+		if closed == 1 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("Got %d files closed, want 1", closed)
+		}
+	}
+
+	// Calls that finish in time succeed.
+	if _, err := r.Stat(ctx, "a"); err != nil {
+		t.Errorf("Stat: unexpected error: %v", err)
+	}
+	rc, err := r.Open(ctx, "a")
+	if err != nil {
+		t.Fatalf("Open: unexpected error: %v", err)
+	}
+	if err := rc.Close(); err != nil {
+		t.Errorf("Close: unexpected error: %v", err)
+	}
+
+	if got := WithTimeout(s, 0); got != Reader(s) {
+		t.Errorf("WithTimeout(r, 0): got %v, want r unchanged", got)
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"time"
 
 	"golang.org/x/net/context"
 )
@@ -140,6 +141,12 @@ func (LocalFS) Create(_ context.Context, path string) (io.WriteCloser, error) {
 	return os.Create(path)
 }
 
+// Chtimes changes the access and modification times of the named file, as
+// os.Chtimes does.
+func (LocalFS) Chtimes(_ context.Context, path string, atime, mtime time.Time) error {
+	return os.Chtimes(path, atime, mtime)
+}
+
 // Rename implements part of the VFS interface.
 func (LocalFS) Rename(_ context.Context, oldPath, newPath string) error {
 	return os.Rename(oldPath, newPath)
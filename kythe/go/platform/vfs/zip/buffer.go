@@ -0,0 +1,73 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// defaultBufferSize is the size of the buffers used to copy the contents of
+// entries, unless set by BufferSize.
+const defaultBufferSize = 32 << 10
+
+// BufferSize returns an Option that sets the size of the buffers with which
+// CopyFile, CopyTo, CopySubset, Extract, FindBySHA256, and WriteTo copy
+// data.  The default is 32 KiB.  Buffers are drawn from a pool
+// shared by every FS using the same size, rather than allocated for each
+// copy, so that a process reading many entries makes little garbage.
+func BufferSize(n int) Option {
+	return func(z *FS) error {
+		if n <= 0 {
+			return fmt.Errorf("invalid buffer size %d", n)
+		}
+		z.bufSize = n
+		return nil
+	}
+}
+
+// bufferPools holds a *sync.Pool of buffers for each size in use.
+var bufferPools sync.Map
+
+// copyBuffer copies from src to dst, as io.Copy does, using a pooled buffer
+// of the size set for z.
+func (z FS) copyBuffer(dst io.Writer, src io.Reader) (int64, error) {
+	size := z.bufSize
+	if size <= 0 {
+		size = defaultBufferSize
+	}
+	p, ok := bufferPools.Load(size)
+	if !ok {
+		p, _ = bufferPools.LoadOrStore(size, &sync.Pool{New: func() interface{} {
+			buf := make([]byte, size)
+			return &buf
+		}})
+	}
+	pool := p.(*sync.Pool)
+	buf := pool.Get().(*[]byte)
+	defer pool.Put(buf)
+	// Hide any ReadFrom method of dst or WriteTo method of src, which would not
+	// use buf.
+	if _, ok := dst.(io.ReaderFrom); ok {
+		dst = struct{ io.Writer }{dst}
+	}
+	if _, ok := src.(io.WriterTo); ok {
+		src = struct{ io.Reader }{src}
+	}
+	return io.CopyBuffer(dst, src, *buf)
+}
@@ -0,0 +1,31 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+// Comment returns the comment recorded for the archive as a whole, which
+// producers sometimes use to record provenance metadata.
+func (z FS) Comment() string { return z.archive().Comment }
+
+// EntryComment returns the comment recorded for the archive entry at path.
+// The path must name an entry of the archive, not an implicit directory.
+func (z FS) EntryComment(path string) (string, error) {
+	f, err := z.lookup("comment", path)
+	if err != nil {
+		return "", err
+	}
+	return f.Comment, nil
+}
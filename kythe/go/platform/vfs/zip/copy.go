@@ -0,0 +1,270 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"kythe.io/kythe/go/platform/vfs"
+
+	"golang.org/x/net/context"
+)
+
+// A CopyOption is a configurable setting for CopyTo.
+type CopyOption func(*copyOptions)
+
+type copyOptions struct {
+	filter   func(name string) bool
+	rename   func(name string) string
+	progress func(done, total int)
+}
+
+// CopyFilter returns a CopyOption that restricts the copy to the entries for
+// whose names keep returns true.  Names are slash-separated paths relative to
+// the root of the source, without any trailing "/".
+func CopyFilter(keep func(name string) bool) CopyOption {
+	return func(o *copyOptions) { o.filter = keep }
+}
+
+// CopyRename returns a CopyOption that copies each entry to the path returned
+// by rename for its name, such as "third_party/"+name to re-prefix the copy.
+// Names are as for CopyFilter.
+func CopyRename(rename func(name string) string) CopyOption {
+	return func(o *copyOptions) { o.rename = rename }
+}
+
+// CopyProgress returns a CopyOption that reports the progress of the copy by
+// calling fn after each file is copied, with the number of files copied so far
+// and the total number to copy.  Directories are not counted.
+func CopyProgress(fn func(done, total int)) CopyOption {
+	return func(o *copyOptions) { o.progress = fn }
+}
+
+// CopyTo recreates the contents of src in dst: first each directory, whether
+// it has an entry of its own or not, then each file, in archive order.
+// Directories are created with mode 0755.  The modification times of the files
+// are preserved if dst can record them, that is, if it has a method
+//
+//	CreateModified(ctx context.Context, path string, modTime time.Time) (io.WriteCloser, error)
+//
+// as a *Writer does, or a method
+//
+//	Chtimes(ctx context.Context, path string, atime, mtime time.Time) error
+//
+// as vfs.LocalFS does, which is called once each file is written.
+func CopyTo(ctx context.Context, src FS, dst vfs.Writer, opts ...CopyOption) error {
+	var o copyOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	target := func(name string) string {
+		if o.rename != nil {
+			return o.rename(name)
+		}
+		return name
+	}
+
+	type copyFile struct {
+		f      *zip.File
+		target string
+	}
+	var files []copyFile
+	dirs := make(map[string]bool)
+	for _, f := range src.index().entries {
+		name, ok := src.rel(f)
+		if !ok {
+			continue
+		}
+		isDir := strings.HasSuffix(name, "/")
+		name = strings.TrimSuffix(name, "/")
+		if o.filter != nil && !o.filter(name) {
+			continue
+		}
+		if t := target(name); isDir {
+			dirs[t] = true
+		} else {
+			if dir := path.Dir(t); dir != "." && dir != "/" {
+				dirs[dir] = true
+			}
+			files = append(files, copyFile{f, t})
+		}
+	}
+
+	// Creating the directories in sorted order creates parents first.
+	var names []string
+	for dir := range dirs {
+		names = append(names, dir)
+	}
+	sort.Strings(names)
+	for _, dir := range names {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := dst.MkdirAll(ctx, dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	p := newProgress(o.progress, len(files))
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := src.copyEntry(ctx, f.f, dst, f.target); err != nil {
+			return err
+		}
+		p.add(1)
+	}
+	return nil
+}
+
+// copyEntry copies the contents of f to the file target, which it creates in
+// dst.
+func (z FS) copyEntry(ctx context.Context, f *zip.File, dst vfs.Writer, target string) error {
+	rc, err := z.openEntry(f)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	var wc io.WriteCloser
+	if mc, ok := dst.(modifiedCreator); ok {
+		wc, err = mc.CreateModified(ctx, target, entryTime(f))
+	} else {
+		wc, err = dst.Create(ctx, target)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := z.copyBuffer(wc, rc); err != nil {
+		wc.Close()
+		return err
+	}
+	if err := wc.Close(); err != nil {
+		return err
+	}
+	if ct, ok := dst.(chtimer); ok {
+		t := entryTime(f)
+		return ct.Chtimes(ctx, target, t, t)
+	}
+	return nil
+}
+
+// modifiedCreator and chtimer are the optional methods by which CopyTo
+// preserves modification times.
+type (
+	modifiedCreator interface {
+		CreateModified(ctx context.Context, path string, modTime time.Time) (io.WriteCloser, error)
+	}
+	chtimer interface {
+		Chtimes(ctx context.Context, path string, atime, mtime time.Time) error
+	}
+)
+
+// CopySubset writes to dst a zip archive holding those entries of z for whose
+// names keep returns true, in archive order.  Names are as for CopyFilter.  The
+// entries are copied without being decompressed, so the copy is quick and its
+// entries are byte-for-byte those of z, including any encryption.  Each kept
+// entry is preceded by entries for those of its parent directories not
+// already written: the entries of z for them if it has any, or otherwise new
+// entries.
+func (z FS) CopySubset(ctx context.Context, dst io.Writer, keep func(name string) bool) error {
+	zw := zip.NewWriter(dst)
+	idx := z.index()
+	written := make(map[string]bool) // directories written, without a "/"
+	var addDir func(dir string) error
+	addDir = func(dir string) error {
+		if dir == "." || written[dir] {
+			return nil
+		}
+		if err := addDir(path.Dir(dir)); err != nil {
+			return err
+		}
+		written[dir] = true
+		if f := z.find(dir); f != nil && strings.HasSuffix(f.Name, "/") {
+			return z.copyRaw(zw, f, dir+"/")
+		}
+		fh := &zip.FileHeader{Name: dir + "/", Modified: idx.dirs[z.key(z.prefix+dir)]}
+		fh.SetMode(os.ModeDir | 0755)
+		_, err := zw.CreateHeader(fh)
+		return err
+	}
+
+	for i, f := range idx.entries {
+		if i%checkInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		name, ok := z.rel(f)
+		if !ok {
+			continue
+		}
+		trimmed := strings.TrimSuffix(name, "/")
+		if !keep(trimmed) {
+			continue
+		}
+		if name != trimmed {
+			if err := addDir(trimmed); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := addDir(path.Dir(name)); err != nil {
+			return err
+		}
+		if err := z.copyRaw(zw, f, name); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// copyRaw copies the entry f to zw under the given name, without decompressing
+// it.
+func (z FS) copyRaw(zw *zip.Writer, f *zip.File, name string) error {
+	fh := f.FileHeader
+	fh.Name = name
+	if !z.rawNames && !isASCII(name) {
+		fh.Flags |= flagUTF8 // the name may have been decoded from CP437
+	}
+	r, err := f.OpenRaw()
+	if err != nil {
+		return err
+	}
+	w, err := zw.CreateRaw(&fh)
+	if err != nil {
+		return err
+	}
+	_, err = z.copyBuffer(w, r)
+	return err
+}
+
+// isASCII reports whether s consists only of ASCII characters.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,83 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"strings"
+	"unicode/utf8"
+)
+
+// flagUTF8 is the general purpose flag marking an entry whose name and comment
+// are encoded in UTF-8.
+const flagUTF8 = 0x800
+
+// cp437 maps the bytes 0x80 through 0xff of code page 437, the original IBM PC
+// character set, to the runes they represent.  The lower half of the code page
+// agrees with ASCII.
+var cp437 = []rune("" +
+	"ÇüéâäàåçêëèïîìÄÅ" +
+	"ÉæÆôöòûùÿÖÜ¢£¥₧ƒ" +
+	"áíóúñÑªº¿⌐¬½¼¡«»" +
+	"░▒▓│┤╡╢╖╕╣║╗╝╜╛┐" +
+	"└┴┬├─┼╞╟╚╔╩╦╠═╬╧" +
+	"╨╤╥╙╘╒╓╫╪┘┌█▄▌▐▀" +
+	"αßΓπΣσµτΦΘΩδ∞φε∩" +
+	"≡±≥≤⌠⌡÷≈°∙·√ⁿ²■\u00a0")
+
+// decodeCP437 returns the UTF-8 form of s, which is encoded in code page 437.
+func decodeCP437(s string) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		if b := s[i]; b < 0x80 {
+			buf.WriteByte(b)
+		} else {
+			buf.WriteRune(cp437[b-0x80])
+		}
+	}
+	return buf.String()
+}
+
+// RawNames returns an Option that leaves the names of entries exactly as they
+// are stored in the archive.  By default, the name of an entry that is not
+// marked as UTF-8 and is not valid UTF-8 is decoded from code page 437, the
+// encoding the zip format specifies for such names.  Names that are valid
+// UTF-8 are never decoded, since many tools write UTF-8 names without marking
+// them.
+func RawNames() Option {
+	return func(z *FS) error {
+		z.rawNames = true
+		return nil
+	}
+}
+
+// decodeNames replaces the names of the entries of r that are encoded in code
+// page 437 with their UTF-8 forms, and returns the set of entries it changed,
+// or nil if there are none.
+func decodeNames(r *zip.Reader) map[*zip.File]bool {
+	var decoded map[*zip.File]bool
+	for _, f := range r.File {
+		if f.Flags&flagUTF8 == 0 && !utf8.ValidString(f.Name) {
+			f.Name = decodeCP437(f.Name)
+			if decoded == nil {
+				decoded = make(map[*zip.File]bool)
+			}
+			decoded[f] = true
+		}
+	}
+	return decoded
+}
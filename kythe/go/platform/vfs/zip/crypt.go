@@ -0,0 +1,365 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Passphrase returns an Option that lets Open read encrypted entries, using
+// the password returned by fn for the entry with the given name.  Entries may
+// be encrypted with either the traditional PKWARE scheme ("ZipCrypto") or the
+// WinZip AES scheme, with 128-, 192-, or 256-bit keys.  Entries that are not
+// encrypted are read as usual, and Stat never needs a password, since the
+// central directory of an archive is not encrypted.
+//
+// Decrypted entries are always checked for integrity when fully read, either
+// by their CRC-32 or by the authentication code of the WinZip AES scheme.
+// Only the Store and Deflate methods are supported for encrypted entries.
+func Passphrase(fn func(name string) ([]byte, error)) Option {
+	return func(z *FS) error {
+		if fn == nil {
+			return errors.New("invalid passphrase function")
+		}
+		z.passphrase = fn
+		return nil
+	}
+}
+
+// WithPassword returns an Option that lets Open read encrypted entries using
+// the same password for every entry.  See Passphrase.
+func WithPassword(password string) Option {
+	return Passphrase(func(string) ([]byte, error) { return []byte(password), nil })
+}
+
+var (
+	// ErrEncrypted is returned when opening an encrypted entry of an archive
+	// for which no password was provided.
+	ErrEncrypted = errors.New("entry is encrypted")
+
+	// ErrPassword is returned when opening an encrypted entry with the wrong
+	// password.
+	ErrPassword = errors.New("incorrect password")
+)
+
+const (
+	flagEncrypted      = 0x1    // general purpose flag: the entry is encrypted
+	flagDataDescriptor = 0x8    // general purpose flag: sizes follow the data
+	methodAES          = 99     // the method of WinZip AES entries
+	extraAES           = 0x9901 // the extra field of WinZip AES entries
+	aesMACLen          = 10     // the length of the WinZip AES authentication code
+	aesIterations      = 1000   // the PBKDF2 iteration count for WinZip AES keys
+)
+
+// isEncrypted reports whether the data of f are encrypted.
+func isEncrypted(f *zip.File) bool { return f.Flags&flagEncrypted != 0 }
+
+// openEncrypted returns a reader for the decrypted contents of f.
+func (z FS) openEncrypted(f *zip.File) (io.ReadCloser, error) {
+	if z.passphrase == nil {
+		return nil, fmt.Errorf("entry %q: %w", f.Name, ErrEncrypted)
+	}
+	password, err := z.passphrase(f.Name)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+	if f.Method == methodAES {
+		return z.openAES(f, raw, password)
+	}
+	return z.openZipCrypto(f, raw, password)
+}
+
+// decompressReader returns a reader for the data of r, which are compressed
+// by the given method: one that the zip package supports, or one of those
+// that z registers with it.
+func (z FS) decompressReader(method uint16, r io.Reader) (io.ReadCloser, error) {
+	switch method {
+	case zip.Store:
+		return ioutil.NopCloser(r), nil
+	case zip.Deflate:
+		return flate.NewReader(r), nil
+	}
+	if d := z.decompressor(method); d != nil {
+		return d(r), nil
+	}
+	return nil, zip.ErrAlgorithm
+}
+
+// cryptoKeys holds the state of the traditional PKWARE encryption scheme.
+type cryptoKeys struct{ k0, k1, k2 uint32 }
+
+func newCryptoKeys(password []byte) *cryptoKeys {
+	k := &cryptoKeys{0x12345678, 0x23456789, 0x34567890}
+	for _, b := range password {
+		k.update(b)
+	}
+	return k
+}
+
+func crc32Byte(crc uint32, b byte) uint32 {
+	return crc32.IEEETable[byte(crc)^b] ^ crc>>8
+}
+
+// update mixes the plaintext byte b into the keys.
+func (k *cryptoKeys) update(b byte) {
+	k.k0 = crc32Byte(k.k0, b)
+	k.k1 = (k.k1+k.k0&0xff)*134775813 + 1
+	k.k2 = crc32Byte(k.k2, byte(k.k1>>24))
+}
+
+// streamByte returns the next byte of the key stream.
+func (k *cryptoKeys) streamByte() byte {
+	t := k.k2 | 2
+	return byte((t * (t ^ 1)) >> 8)
+}
+
+// decrypt decrypts buf in place.
+func (k *cryptoKeys) decrypt(buf []byte) {
+	for i, c := range buf {
+		buf[i] = c ^ k.streamByte()
+		k.update(buf[i])
+	}
+}
+
+// zipCryptoReader decrypts data encrypted by the traditional PKWARE scheme.
+type zipCryptoReader struct {
+	r    io.Reader
+	keys *cryptoKeys
+}
+
+// Read implements the io.Reader interface.
+func (z *zipCryptoReader) Read(buf []byte) (int, error) {
+	n, err := z.r.Read(buf)
+	z.keys.decrypt(buf[:n])
+	return n, err
+}
+
+// openZipCrypto returns a reader for the contents of f, whose raw data are
+// read from raw and were encrypted by the traditional PKWARE scheme.
+func (z FS) openZipCrypto(f *zip.File, raw io.Reader, password []byte) (io.ReadCloser, error) {
+	// The data are preceded by a 12-byte header whose last byte is a check on
+	// the password: the high byte of the CRC-32, or of the modification time
+	// if the CRC-32 was not known when the header was written.
+	var header [12]byte
+	if _, err := io.ReadFull(raw, header[:]); err != nil {
+		return nil, fmt.Errorf("entry %q: reading encryption header: %w", f.Name, zip.ErrFormat)
+	}
+	keys := newCryptoKeys(password)
+	keys.decrypt(header[:])
+	check := byte(f.CRC32 >> 24)
+	if f.Flags&flagDataDescriptor != 0 {
+		check = byte(f.ModifiedTime >> 8)
+	}
+	if header[11] != check {
+		return nil, fmt.Errorf("entry %q: %w", f.Name, ErrPassword)
+	}
+	rc, err := z.decompressReader(f.Method, &zipCryptoReader{r: raw, keys: keys})
+	if err != nil {
+		return nil, err
+	}
+	return newVerifyingReader(rc, f), nil
+}
+
+// aesField is the content of the WinZip AES extra field.
+type aesField struct {
+	version  uint16 // 1 for AE-1, which records a CRC-32; 2 for AE-2, which does not
+	strength byte   // 1, 2, or 3 for 128-, 192-, or 256-bit keys
+	method   uint16 // the compression method of the plaintext
+}
+
+// keyLen returns the length in bytes of the AES key.
+func (a aesField) keyLen() int { return 8 + 8*int(a.strength) }
+
+// findAESField returns the WinZip AES extra field of f.
+func findAESField(f *zip.File) (aesField, error) {
+	extra := f.Extra
+	for len(extra) >= 4 {
+		tag := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		extra = extra[4:]
+		if size > len(extra) {
+			break
+		}
+		if data := extra[:size]; tag == extraAES && size >= 7 && string(data[2:4]) == "AE" {
+			a := aesField{
+				version:  binary.LittleEndian.Uint16(data),
+				strength: data[4],
+				method:   binary.LittleEndian.Uint16(data[5:]),
+			}
+			if a.strength < 1 || a.strength > 3 {
+				break
+			}
+			return a, nil
+		}
+		extra = extra[size:]
+	}
+	return aesField{}, fmt.Errorf("entry %q: missing AES extra field: %w", f.Name, zip.ErrFormat)
+}
+
+// openAES returns a reader for the contents of f, whose raw data are read from
+// raw and were encrypted by the WinZip AES scheme.
+func (z FS) openAES(f *zip.File, raw io.Reader, password []byte) (io.ReadCloser, error) {
+	field, err := findAESField(f)
+	if err != nil {
+		return nil, err
+	}
+
+	// The data are preceded by a salt and a password verifier, and followed
+	// by an authentication code.
+	keyLen := field.keyLen()
+	header := make([]byte, keyLen/2+2)
+	size := int64(f.CompressedSize64) - int64(len(header)) - aesMACLen
+	if size < 0 {
+		return nil, fmt.Errorf("entry %q: truncated AES data: %w", f.Name, zip.ErrFormat)
+	}
+	if _, err := io.ReadFull(raw, header); err != nil {
+		return nil, fmt.Errorf("entry %q: reading encryption header: %w", f.Name, zip.ErrFormat)
+	}
+	salt, verifier := header[:keyLen/2], header[keyLen/2:]
+	keys := pbkdf2.Key(password, salt, aesIterations, 2*keyLen+2, sha1.New)
+	if !bytes.Equal(keys[2*keyLen:], verifier) {
+		return nil, fmt.Errorf("entry %q: %w", f.Name, ErrPassword)
+	}
+	block, err := aes.NewCipher(keys[:keyLen])
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := z.decompressReader(field.method, &aesReader{
+		name:      f.Name,
+		raw:       raw,
+		remaining: size,
+		stream:    newWinZipCTR(block),
+		mac:       hmac.New(sha1.New, keys[keyLen:2*keyLen]),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if field.version == 1 {
+		return newVerifyingReader(rc, f), nil
+	}
+	return rc, nil
+}
+
+// aesReader decrypts data encrypted by the WinZip AES scheme, and checks their
+// authentication code as soon as the last byte of the data is read. The check
+// is not left until io.EOF, since decompressors such as flate stop reading at
+// the end of their stream without asking for more.
+type aesReader struct {
+	name      string
+	raw       io.Reader // the encrypted data followed by the authentication code
+	remaining int64     // the number of encrypted bytes not yet read
+	checked   bool      // whether the authentication code has been checked
+	stream    cipher.Stream
+	mac       hash.Hash
+}
+
+// Read implements the io.Reader interface. The read that reaches the end of
+// the data returns no bytes if the check fails, so that the error is not held
+// behind buffered data by the caller.
+func (a *aesReader) Read(buf []byte) (int, error) {
+	if a.remaining == 0 {
+		if !a.checked {
+			if err := a.check(); err != nil {
+				return 0, err
+			}
+		}
+		return 0, io.EOF
+	}
+	if int64(len(buf)) > a.remaining {
+		buf = buf[:a.remaining]
+	}
+	n, err := a.raw.Read(buf)
+	a.remaining -= int64(n)
+	a.mac.Write(buf[:n])
+	a.stream.XORKeyStream(buf[:n], buf[:n])
+	if a.remaining > 0 {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return n, err
+	}
+	if err := a.check(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// check reads the authentication code following the data, and compares it to
+// the code computed over the data read.
+func (a *aesReader) check() error {
+	a.checked = true
+	var code [aesMACLen]byte
+	if _, err := io.ReadFull(a.raw, code[:]); err != nil {
+		return fmt.Errorf("entry %q: reading authentication code: %w", a.name, zip.ErrFormat)
+	}
+	if !hmac.Equal(a.mac.Sum(nil)[:aesMACLen], code[:]) {
+		return fmt.Errorf("entry %q failed authentication: %w", a.name, zip.ErrChecksum)
+	}
+	return nil
+}
+
+// winZipCTR implements the counter mode used by the WinZip AES scheme, which
+// differs from cipher.NewCTR in that the counter is little-endian and starts
+// from 1.
+type winZipCTR struct {
+	block   cipher.Block
+	counter [aes.BlockSize]byte
+	stream  [aes.BlockSize]byte
+	used    int // the number of bytes of stream already used
+}
+
+func newWinZipCTR(block cipher.Block) *winZipCTR {
+	return &winZipCTR{block: block, used: aes.BlockSize}
+}
+
+// XORKeyStream implements the cipher.Stream interface.
+func (c *winZipCTR) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if c.used == aes.BlockSize {
+			for j := range c.counter {
+				c.counter[j]++
+				if c.counter[j] != 0 {
+					break
+				}
+			}
+			c.block.Encrypt(c.stream[:], c.counter[:])
+			c.used = 0
+		}
+		dst[i] = src[i] ^ c.stream[c.used]
+		c.used++
+	}
+}
@@ -0,0 +1,263 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io/ioutil"
+	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/net/context"
+)
+
+const testPassword = "open sesame"
+
+func deflate(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		t.Fatalf("flate.NewWriter: %v", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// zipCryptoEntry encrypts data, compressed by method, with the traditional
+// PKWARE scheme.
+func zipCryptoEntry(t *testing.T, name string, method uint16, data []byte) (*zip.FileHeader, []byte) {
+	fh := &zip.FileHeader{
+		Name:               name,
+		Method:             method,
+		Flags:              flagEncrypted,
+		CRC32:              crc32.ChecksumIEEE(data),
+		UncompressedSize64: uint64(len(data)),
+	}
+	plain := append([]byte("0123456789a"), byte(fh.CRC32>>24))
+	if method == zip.Deflate {
+		plain = append(plain, deflate(t, data)...)
+	} else {
+		plain = append(plain, data...)
+	}
+	keys := newCryptoKeys([]byte(testPassword))
+	enc := make([]byte, len(plain))
+	for i, p := range plain {
+		enc[i] = p ^ keys.streamByte()
+		keys.update(p)
+	}
+	fh.CompressedSize64 = uint64(len(enc))
+	return fh, enc
+}
+
+// aesEntry encrypts data, compressed by method, with the WinZip AES scheme.
+func aesEntry(t *testing.T, name string, version uint16, strength byte, method uint16, data []byte) (*zip.FileHeader, []byte) {
+	fh := &zip.FileHeader{
+		Name:               name,
+		Method:             methodAES,
+		Flags:              flagEncrypted,
+		UncompressedSize64: uint64(len(data)),
+	}
+	if version == 1 {
+		fh.CRC32 = crc32.ChecksumIEEE(data)
+	}
+	field := make([]byte, 11)
+	binary.LittleEndian.PutUint16(field, extraAES)
+	binary.LittleEndian.PutUint16(field[2:], 7)
+	binary.LittleEndian.PutUint16(field[4:], version)
+	copy(field[6:], "AE")
+	field[8] = strength
+	binary.LittleEndian.PutUint16(field[9:], method)
+	fh.Extra = field
+
+	keyLen := aesField{strength: strength}.keyLen()
+	salt := bytes.Repeat([]byte{7}, keyLen/2)
+	keys := pbkdf2.Key([]byte(testPassword), salt, aesIterations, 2*keyLen+2, sha1.New)
+	block, err := aes.NewCipher(keys[:keyLen])
+	if err != nil {
+		t.Fatalf("aes.NewCipher: %v", err)
+	}
+	if method == zip.Deflate {
+		data = deflate(t, data)
+	}
+	enc := make([]byte, len(data))
+	newWinZipCTR(block).XORKeyStream(enc, data)
+	mac := hmac.New(sha1.New, keys[keyLen:2*keyLen])
+	mac.Write(enc)
+
+	raw := append(append(salt, keys[2*keyLen:]...), enc...)
+	raw = append(raw, mac.Sum(nil)[:aesMACLen]...)
+	fh.CompressedSize64 = uint64(len(raw))
+	return fh, raw
+}
+
+func newEncryptedArchive(t *testing.T, contents map[string]string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	add := func(fh *zip.FileHeader, raw []byte) {
+		fw, err := w.CreateRaw(fh)
+		if err != nil {
+			t.Fatalf("CreateRaw %q: %v", fh.Name, err)
+		}
+		if _, err := fw.Write(raw); err != nil {
+			t.Fatalf("Write %q: %v", fh.Name, err)
+		}
+	}
+	data := func(name string) []byte { return []byte(contents[name]) }
+	add(zipCryptoEntry(t, "crypto-store.txt", zip.Store, data("crypto-store.txt")))
+	add(zipCryptoEntry(t, "crypto-deflate.txt", zip.Deflate, data("crypto-deflate.txt")))
+	add(aesEntry(t, "aes128.txt", 1, 1, zip.Store, data("aes128.txt")))
+	add(aesEntry(t, "aes192.txt", 2, 2, zip.Deflate, data("aes192.txt")))
+	add(aesEntry(t, "aes256.txt", 2, 3, zip.Deflate, data("aes256.txt")))
+	fw, err := w.Create("plain.txt")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	fw.Write(data("plain.txt"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestEncryptedEntries(t *testing.T) {
+	ctx := context.Background()
+	contents := map[string]string{
+		"crypto-store.txt":   "stored with ZipCrypto",
+		"crypto-deflate.txt": "deflated with ZipCrypto, deflated with ZipCrypto",
+		"aes128.txt":         "stored with AES-128",
+		"aes192.txt":         "deflated with AES-192, deflated with AES-192",
+		"aes256.txt":         "deflated with AES-256, deflated with AES-256",
+		"plain.txt":          "not encrypted at all",
+	}
+	data := newEncryptedArchive(t, contents)
+
+	z, err := Open(bytes.NewReader(data), WithPassword(testPassword), VerifyChecksums())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for name, want := range contents {
+		rc, err := z.Open(ctx, name)
+		if err != nil {
+			t.Errorf("Open %q: unexpected error: %v", name, err)
+			continue
+		}
+		got, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Errorf("Read %q: unexpected error: %v", name, err)
+		} else if string(got) != want {
+			t.Errorf("Read %q: got %q, want %q", name, got, want)
+		}
+	}
+
+	// Without a password, metadata are available but contents are not.
+	z, err = Open(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if fi, err := z.Stat(ctx, "aes256.txt"); err != nil {
+		t.Errorf("Stat: unexpected error: %v", err)
+	} else if got, want := fi.Size(), int64(len(contents["aes256.txt"])); got != want {
+		t.Errorf("Stat: got size %d, want %d", got, want)
+	}
+	for _, name := range []string{"crypto-store.txt", "aes256.txt"} {
+		if _, err := z.Open(ctx, name); !errors.Is(err, ErrEncrypted) {
+			t.Errorf("Open %q without password: got error %v, want %v", name, err, ErrEncrypted)
+		}
+	}
+
+	z, err = Open(bytes.NewReader(data), WithPassword("open barley"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for _, name := range []string{"crypto-deflate.txt", "aes128.txt"} {
+		if _, err := z.Open(ctx, name); !errors.Is(err, ErrPassword) {
+			t.Errorf("Open %q with wrong password: got error %v, want %v", name, err, ErrPassword)
+		}
+	}
+}
+
+func TestAESAuthentication(t *testing.T) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	fh, raw := aesEntry(t, "tampered.txt", 2, 3, zip.Store, []byte("the original contents"))
+	raw[len(raw)-aesMACLen-1] ^= 1 // flip a bit of the ciphertext
+	fw, err := w.CreateRaw(fh)
+	if err != nil {
+		t.Fatalf("CreateRaw: %v", err)
+	}
+	fw.Write(raw)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	z, err := Open(bytes.NewReader(buf.Bytes()), WithPassword(testPassword))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	rc, err := z.Open(context.Background(), "tampered.txt")
+	if err != nil {
+		t.Fatalf("Open: unexpected error: %v", err)
+	}
+	defer rc.Close()
+	if _, err := ioutil.ReadAll(rc); !errors.Is(err, zip.ErrChecksum) {
+		t.Errorf("Read: got error %v, want %v", err, zip.ErrChecksum)
+	}
+}
+
+func TestAESAuthenticationDeflate(t *testing.T) {
+	// Decompression stops at the end of the Deflate stream, before the
+	// encrypted data are exhausted by a read returning io.EOF.
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	fh, raw := aesEntry(t, "tampered.txt", 2, 3, zip.Deflate, bytes.Repeat([]byte("the original contents "), 100))
+	raw[len(raw)-1] ^= 1 // flip a bit of the authentication code
+	fw, err := w.CreateRaw(fh)
+	if err != nil {
+		t.Fatalf("CreateRaw: %v", err)
+	}
+	fw.Write(raw)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	z, err := Open(bytes.NewReader(buf.Bytes()), WithPassword(testPassword))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	rc, err := z.Open(context.Background(), "tampered.txt")
+	if err != nil {
+		t.Fatalf("Open: unexpected error: %v", err)
+	}
+	defer rc.Close()
+	if _, err := ioutil.ReadAll(rc); !errors.Is(err, zip.ErrChecksum) {
+		t.Errorf("Read: got error %v, want %v", err, zip.ErrChecksum)
+	}
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"compress/bzip2"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+)
+
+// methodBzip2 is the compression method of entries compressed with bzip2
+// (APPNOTE.TXT section 4.4.5).
+const methodBzip2 = 12
+
+// extraMethods lists the compression methods, beyond those of the zip
+// package, that an FS may be able to decompress.
+var extraMethods = []uint16{methodBzip2, methodZstd}
+
+// decompressor returns the decompressor with which z reads entries compressed
+// by method, if it is one that z adds to those of the zip package, or nil.
+// Entries compressed with bzip2 can always be read, since the standard
+// library supports it; Zstandard requires the Zstd option.
+func (z FS) decompressor(method uint16) zip.Decompressor {
+	switch {
+	case method == methodBzip2:
+		return newBzip2Reader
+	case method == methodZstd && z.zstd:
+		return newZstdReader
+	}
+	return nil
+}
+
+// register adds the decompressors available to z to rc.
+func (z FS) register(rc *zip.Reader) {
+	for _, method := range extraMethods {
+		if d := z.decompressor(method); d != nil {
+			rc.RegisterDecompressor(method, d)
+		}
+	}
+}
+
+// newBzip2Reader returns a reader for the bzip2-compressed data of r.
+func newBzip2Reader(r io.Reader) io.ReadCloser { return ioutil.NopCloser(bzip2.NewReader(r)) }
+
+// RequireSupportedMethods returns an Option that makes opening the archive
+// fail if any of its entries is compressed by a method that the FS cannot
+// decompress, given its other options, so that such archives are rejected up
+// front rather than when the entry is first read.  The error is a
+// ValidationError listing each such entry, with an error wrapping
+// zip.ErrAlgorithm.  The method of a WinZip AES entry is that of its
+// plaintext.
+func RequireSupportedMethods() Option {
+	return func(z *FS) error {
+		z.requireMethods = true
+		return nil
+	}
+}
+
+// checkMethods returns a ValidationError for the entries of rc that z cannot
+// decompress, or nil if there are none.
+func (z FS) checkMethods(rc *zip.Reader) error {
+	var errs ValidationError
+	for _, f := range rc.File {
+		method := f.Method
+		if method == methodAES && isEncrypted(f) {
+			field, err := findAESField(f)
+			if err != nil {
+				errs = append(errs, &EntryError{Name: f.Name, Err: err})
+				continue
+			}
+			method = field.method
+		}
+		if method != zip.Store && method != zip.Deflate && z.decompressor(method) == nil {
+			errs = append(errs, &EntryError{Name: f.Name, Err: fmt.Errorf("compression method %d: %w", method, zip.ErrAlgorithm)})
+		}
+	}
+	if errs == nil {
+		return nil
+	}
+	sort.Sort(errs)
+	return errs
+}
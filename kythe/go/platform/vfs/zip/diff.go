@@ -0,0 +1,131 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// A Change is the kind of difference between two archives at one path.
+type Change int
+
+// The kinds of Change.
+const (
+	Added   Change = iota + 1 // present only in the second archive
+	Removed                   // present only in the first archive
+	Changed                   // present in both, with different contents
+)
+
+// String returns the name of c, such as "Added".
+func (c Change) String() string {
+	switch c {
+	case Added:
+		return "Added"
+	case Removed:
+		return "Removed"
+	case Changed:
+		return "Changed"
+	}
+	return fmt.Sprintf("Change(%d)", int(c))
+}
+
+// A DiffEntry reports how one path differs between two archives.
+type DiffEntry struct {
+	Name   string
+	Change Change
+	A, B   EntryInfo // the entry in each archive, or zero if it has none
+}
+
+// ErrNoCRC is reported by Diff and Fingerprint for entries that do not record the CRC-32 of
+// their contents, as WinZip AES entries of the AE-2 format do not.
+var ErrNoCRC = errors.New("entry does not record a CRC-32")
+
+// Diff compares the entries beneath the roots of a and b, and reports, sorted
+// by name, each path that is Added in b, Removed from a, or Changed, having a
+// different size or CRC-32 in each.  Names are as for Entries, so directory
+// entries are compared by name alone.  Only the central directories are read,
+// so the comparison is fast but does not notice contents that differ despite
+// having the same CRC-32, nor differences in modification time or compression,
+// which do not affect the contents.  If a path has an entry in both archives
+// that records no CRC-32, Diff fails with an *EntryError wrapping ErrNoCRC.
+func Diff(a, b FS) ([]DiffEntry, error) {
+	// The entries are kept with their infos, since looking them up again by
+	// their relative names would miss those whose stored names differ.
+	type diffEntry struct {
+		info EntryInfo
+		f    *zip.File
+	}
+	byName := func(z FS) map[string]diffEntry {
+		infos := make(map[string]diffEntry)
+		for _, f := range z.index().entries {
+			if name, ok := z.rel(f); ok {
+				infos[name] = diffEntry{newEntryInfo(f, name), f}
+			}
+		}
+		return infos
+	}
+	as, bs := byName(a), byName(b)
+
+	// Visit the names in order, so that the entry reported for ErrNoCRC
+	// does not depend on the order of iteration over the map.
+	names := make([]string, 0, len(as))
+	for name := range as {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var diffs []DiffEntry
+	for _, name := range names {
+		ea := as[name]
+		eb, ok := bs[name]
+		switch {
+		case !ok:
+			diffs = append(diffs, DiffEntry{Name: name, Change: Removed, A: ea.info})
+		case ea.info.Size != eb.info.Size:
+			diffs = append(diffs, DiffEntry{Name: name, Change: Changed, A: ea.info, B: eb.info})
+		case noCRC(ea.f) || noCRC(eb.f):
+			return nil, &EntryError{Name: name, Err: ErrNoCRC}
+		case ea.info.CRC32 != eb.info.CRC32:
+			diffs = append(diffs, DiffEntry{Name: name, Change: Changed, A: ea.info, B: eb.info})
+		}
+	}
+	for name, eb := range bs {
+		if _, ok := as[name]; !ok {
+			diffs = append(diffs, DiffEntry{Name: name, Change: Added, B: eb.info})
+		}
+	}
+	sort.Sort(diffsByName(diffs))
+	return diffs, nil
+}
+
+type diffsByName []DiffEntry
+
+func (d diffsByName) Len() int           { return len(d) }
+func (d diffsByName) Less(i, j int) bool { return d[i].Name < d[j].Name }
+func (d diffsByName) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+// noCRC reports whether f records no CRC-32 of its contents.
+func noCRC(f *zip.File) bool {
+	if f == nil || f.Method != methodAES || f.UncompressedSize64 == 0 {
+		return false
+	}
+	field, err := findAESField(f)
+	return err == nil && field.version == 2
+}
@@ -0,0 +1,108 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// FindByCRC returns the names of the files beneath the root of z whose CRC-32,
+// as recorded in the central directory, is crc, in sorted order.  No entries
+// are read.  Since different contents may have the same CRC-32, the files
+// found need not have the same contents, and callers needing certainty should
+// compare the contents or use FindBySHA256.
+func (z FS) FindByCRC(crc uint32) []string {
+	var names []string
+	for _, f := range z.index().entries {
+		if name, ok := z.rel(f); ok && f.CRC32 == crc && !strings.HasSuffix(name, "/") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FindBySHA256 returns the names of the files beneath the root of z whose
+// contents have the SHA-256 digest given in hexadecimal by hash, in sorted
+// order.  Each file is read to compute its digest, unless it was computed by
+// an earlier call; the digests are kept with the index of the archive, which
+// copies of z share, so that searching again is fast.  An error reading an
+// entry is reported as an *EntryError, and stops the search.
+func (z FS) FindBySHA256(ctx context.Context, hash string) ([]string, error) {
+	want, err := hex.DecodeString(hash)
+	if err != nil || len(want) != sha256.Size {
+		return nil, fmt.Errorf("invalid SHA-256 digest %q: %w", hash, os.ErrInvalid)
+	}
+	var names []string
+	for _, f := range z.index().entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		name, ok := z.rel(f)
+		if !ok || strings.HasSuffix(name, "/") {
+			continue
+		}
+		sum, err := z.digest(ctx, f)
+		if err != nil {
+			return nil, &EntryError{Name: f.Name, Err: err}
+		} else if bytes.Equal(sum[:], want) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// digest returns the SHA-256 digest of the contents of f, computing it only if
+// it is not already known.
+func (z FS) digest(ctx context.Context, f *zip.File) ([sha256.Size]byte, error) {
+	idx := z.index()
+	idx.mu.Lock()
+	sum, ok := idx.digests[f]
+	idx.mu.Unlock()
+	if ok {
+		return sum, nil
+	}
+
+	rc, err := z.openEntry(f)
+	if err != nil {
+		return sum, err
+	}
+	defer rc.Close()
+	h := sha256.New()
+	if _, err := z.copyBuffer(h, ctxReader{ctx, rc}); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.digests == nil {
+		idx.digests = make(map[*zip.File][sha256.Size]byte)
+	}
+	idx.digests[f] = sum
+	return sum, nil
+}
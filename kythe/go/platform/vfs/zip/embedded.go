@@ -0,0 +1,109 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+const (
+	eocdSignature = "PK\x05\x06" // begins the end of central directory record
+	eocdLen       = 22           // the length of the record, without its comment
+	scanChunk     = 64 << 10     // the size of the blocks read when scanning
+
+	// maxEmbeddedScan bounds how far from the end of its input openEmbedded
+	// scans for the end of an archive, so that a large file that is not an
+	// archive at all is not read in full.
+	maxEmbeddedScan = 64 << 20
+
+	// maxEmbeddedCandidates bounds the number of records that openEmbedded
+	// tries to read an archive from, so that input crafted with many of them
+	// cannot make it parse a central directory for each.
+	maxEmbeddedCandidates = 32
+)
+
+// openEmbedded returns a reader for a zip archive that is followed by other
+// data in r, as when an archive is concatenated with another file.  The zip
+// package finds the end of an archive only if it lies near the end of r, so
+// openEmbedded instead scans backward through all of r for end of central
+// directory records, and returns the archive ending with the last record that
+// describes a valid archive.  Data preceding the archive are skipped by the
+// zip package itself.  Only the last maxEmbeddedScan bytes of r are scanned,
+// and only the last maxEmbeddedCandidates plausible records are tried.  If
+// there is no such record, openEmbedded returns zip.ErrFormat.
+func openEmbedded(r io.ReaderAt, size int64) (*zip.Reader, error) {
+	buf := make([]byte, scanChunk+len(eocdSignature)-1)
+	candidates := 0
+	for end := size; end > 0 && size-end < maxEmbeddedScan; end -= scanChunk {
+		start := end - scanChunk
+		if start < 0 {
+			start = 0
+		}
+		// Overlap the following block, so that no signature is split.
+		n := end - start + int64(len(eocdSignature)) - 1
+		if start+n > size {
+			n = size - start
+		}
+		chunk := buf[:n]
+		if _, err := r.ReadAt(chunk, start); err != nil && err != io.EOF {
+			return nil, err
+		}
+		for i := bytes.LastIndex(chunk, []byte(eocdSignature)); i >= 0; i = bytes.LastIndex(chunk[:i], []byte(eocdSignature)) {
+			rc, tried := tryArchive(r, size, start+int64(i))
+			if rc != nil {
+				return rc, nil
+			} else if tried {
+				if candidates++; candidates >= maxEmbeddedCandidates {
+					return nil, zip.ErrFormat
+				}
+			}
+		}
+	}
+	return nil, zip.ErrFormat
+}
+
+// tryArchive returns a reader for the archive ended by the end of central
+// directory record at offset pos of r, or nil if there is none.  It reports
+// whether the record was plausible enough to try reading the archive, which a
+// record whose central directory would not precede it is not.
+func tryArchive(r io.ReaderAt, size, pos int64) (*zip.Reader, bool) {
+	var rec [eocdLen]byte
+	if pos+eocdLen > size {
+		return nil, false
+	}
+	if _, err := r.ReadAt(rec[:], pos); err != nil {
+		return nil, false
+	}
+	end := pos + eocdLen + int64(binary.LittleEndian.Uint16(rec[20:]))
+	if end > size {
+		return nil, false
+	}
+	// The fields of a zip64 archive are all ones, and the real ones are kept
+	// in another record.
+	dirSize, dirOffset := binary.LittleEndian.Uint32(rec[12:]), binary.LittleEndian.Uint32(rec[16:])
+	if dirSize != 0xffffffff && dirOffset != 0xffffffff && int64(dirOffset)+int64(dirSize) > pos {
+		return nil, false
+	}
+	rc, err := zip.NewReader(io.NewSectionReader(r, 0, end), end)
+	if err != nil {
+		return nil, true
+	}
+	return rc, true
+}
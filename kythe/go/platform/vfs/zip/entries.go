@@ -0,0 +1,230 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// EntryInfo describes an entry of the archive as recorded in its central
+// directory.
+type EntryInfo struct {
+	Name           string    // the name of the entry, relative to the root of the FS
+	Size           int64     // the uncompressed size in bytes
+	CompressedSize int64     // the size in bytes of the data stored in the archive
+	Modified       time.Time // the modification time, in UTC
+	Method         uint16    // the compression method, such as zip.Deflate
+	CRC32          uint32    // the CRC-32 of the uncompressed data
+}
+
+// Entries returns information about every entry of the archive beneath the
+// root of z, in archive order, without reading any of their contents.  Entries
+// for directories, if the archive has any, are included with names ending in
+// "/".  Entries hidden by others of the same name are omitted, so positions in
+// the result may differ from those used by NameAt and OpenIndex.
+func (z FS) Entries() []EntryInfo {
+	var infos []EntryInfo
+	for _, f := range z.index().entries {
+		name, ok := z.rel(f)
+		if !ok {
+			continue
+		}
+		infos = append(infos, newEntryInfo(f, name))
+	}
+	return infos
+}
+
+// ErrStop may be returned by the function passed to ForEach to stop the
+// iteration early without error.
+var ErrStop = errors.New("stop iteration")
+
+// ForEach calls fn with information about each entry of the archive beneath
+// the root of z, in archive order, as given by Entries, but without building
+// a slice of all of them.  The calls are sequential, and z may be used
+// concurrently by other goroutines meanwhile.  If fn returns ErrStop, ForEach
+// stops and returns nil; if it returns another error, ForEach stops and
+// returns that error.  If ctx ends first, ForEach returns its error.
+func (z FS) ForEach(ctx context.Context, fn func(EntryInfo) error) error {
+	for _, f := range z.index().entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name, ok := z.rel(f)
+		if !ok {
+			continue
+		}
+		if err := fn(newEntryInfo(f, name)); err == ErrStop {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NameAt returns the name, as stored, of the entry at position i of the
+// central directory of the whole archive, that is, of z.Archive.File[i],
+// regardless of the root of z.  Together with OpenIndex, it gives a handle on
+// each entry that is stable even when several entries have the same name.  If
+// i is out of range, the error wraps os.ErrInvalid.
+func (z FS) NameAt(i int) (string, error) {
+	f, err := z.fileAt("name", i)
+	if err != nil {
+		return "", err
+	}
+	return f.Name, nil
+}
+
+// OpenIndex returns a reader for the contents of the entry at position i of
+// the central directory, as for NameAt, without looking up its name, so that
+// every entry can be read, including those hidden by later entries of the
+// same name.  Symbolic links are not followed.  The reader is otherwise as
+// returned by Open.
+func (z FS) OpenIndex(ctx context.Context, i int) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f, err := z.fileAt("open", i)
+	if err != nil {
+		return nil, err
+	}
+	if z.shareable(f) {
+		return z.openShared(ctx, f)
+	}
+	return z.openEntry(f)
+}
+
+// fileAt returns the entry at position i of the central directory, or an
+// error labelled with op if there is none.
+func (z FS) fileAt(op string, i int) (*zip.File, error) {
+	files := z.archive().File
+	if i < 0 || i >= len(files) {
+		return nil, fmt.Errorf("%s: entry index %d out of range [0, %d): %w", op, i, len(files), os.ErrInvalid)
+	}
+	return files[i], nil
+}
+
+// newEntryInfo returns the EntryInfo for f, whose name relative to the root is
+// as given.
+func newEntryInfo(f *zip.File, name string) EntryInfo {
+	return EntryInfo{
+		Name:           name,
+		Size:           int64(f.UncompressedSize64),
+		CompressedSize: int64(f.CompressedSize64),
+		Modified:       entryTime(f),
+		Method:         f.Method,
+		CRC32:          f.CRC32,
+	}
+}
+
+// A manifestEntry is the record of one entry in a manifest.
+type manifestEntry struct {
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	CRC32    uint32 `json:"crc32"`
+	Method   uint16 `json:"method"`
+	Modified string `json:"modified"` // RFC 3339, in UTC
+}
+
+// WriteManifest writes to w a JSON array describing every entry of the archive
+// beneath the root of z, sorted by name.  Each element records the name, size,
+// CRC-32, compression method, and modification time of an entry, with the time
+// in RFC 3339 format in UTC.  Details of the layout of the archive, such as the
+// offsets and compressed sizes of entries, are omitted, so the manifests of two
+// archives with the same contents are identical.
+func (z FS) WriteManifest(w io.Writer) error {
+	infos := z.Entries()
+	sort.Stable(infosByName(infos))
+	manifest := make([]manifestEntry, len(infos))
+	for i, e := range infos {
+		manifest[i] = manifestEntry{
+			Name:     e.Name,
+			Size:     e.Size,
+			CRC32:    e.CRC32,
+			Method:   e.Method,
+			Modified: e.Modified.UTC().Format(time.RFC3339),
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(manifest)
+}
+
+// Fingerprint returns a digest of the logical contents of the archive beneath
+// the root of z, which changes if and only if a file is added, removed,
+// renamed, or changed in size or CRC-32, and not when the archive is repacked
+// in another order, with other modification times or compression, or with or
+// without entries for directories.  Only the central directory is read, so it
+// is cheap enough to use as a cache key.
+//
+// The digest is the lowercase hexadecimal SHA-256 of the concatenation, over
+// the file entries sorted by their names cleaned as for Stat and relative to
+// the root, of
+//
+//   - the length in bytes of the name, as a big-endian uint64;
+//   - the name, in UTF-8;
+//   - the uncompressed size, as a big-endian uint64; and
+//   - the CRC-32 of the contents, as a big-endian uint32.
+//
+// For an entry that records no CRC-32, as a WinZip AE-2 entry does not, the
+// error is an *EntryError wrapping ErrNoCRC.
+func (z FS) Fingerprint() (string, error) {
+	var infos []EntryInfo
+	for _, f := range z.index().entries {
+		name, ok := z.relClean(f)
+		if !ok || strings.HasSuffix(name, "/") {
+			continue
+		}
+		if noCRC(f) {
+			return "", &EntryError{Name: name, Err: ErrNoCRC}
+		}
+		infos = append(infos, newEntryInfo(f, name))
+	}
+	sort.Sort(infosByName(infos))
+
+	h := sha256.New()
+	var buf [8]byte
+	for _, e := range infos {
+		binary.BigEndian.PutUint64(buf[:], uint64(len(e.Name)))
+		h.Write(buf[:])
+		io.WriteString(h, e.Name)
+		binary.BigEndian.PutUint64(buf[:], uint64(e.Size))
+		h.Write(buf[:])
+		binary.BigEndian.PutUint32(buf[:4], e.CRC32)
+		h.Write(buf[:4])
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+type infosByName []EntryInfo
+
+func (b infosByName) Len() int           { return len(b) }
+func (b infosByName) Less(i, j int) bool { return b[i].Name < b[j].Name }
+func (b infosByName) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
@@ -0,0 +1,160 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"time"
+)
+
+// IDs of the extra fields that the FS interprets, as assigned in APPNOTE.TXT
+// section 4.5 and by Info-ZIP.
+const (
+	ExtraZip64      = 0x0001 // 64-bit sizes and offsets
+	ExtraNTFS       = 0x000a // NTFS times
+	ExtraPKWAREUnix = 0x000d // Unix times and owner, as written by PKWARE
+	ExtraTimestamp  = 0x5455 // Info-ZIP extended timestamp
+	ExtraInfoZIPOld = 0x5855 // Info-ZIP Unix times and owner, superseded by the timestamp and owner fields
+	ExtraUnixOwner  = 0x7875 // Info-ZIP Unix UID and GID
+)
+
+// An ExtraField is one record of the extra field of an archive entry.
+type ExtraField struct {
+	ID   uint16 // the header ID, such as ExtraTimestamp
+	Data []byte // the data of the record, without its ID and length
+}
+
+// ExtraFields returns the records of the extra field of the archive entry at
+// path, as stored in the central directory, in order.  The data of each record
+// are a copy.  If the extra field is malformed, ExtraFields returns the
+// records before the fault together with an error wrapping zip.ErrFormat.
+// Paths may name entries of nested archives, as for Header.
+func (z FS) ExtraFields(path string) ([]ExtraField, error) {
+	fh, err := z.Header(path)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := parseExtra(fh.Extra)
+	for i, f := range fields {
+		fields[i].Data = append([]byte(nil), f.Data...)
+	}
+	if err != nil {
+		return fields, &os.PathError{Op: "extra", Path: path, Err: err}
+	}
+	return fields, nil
+}
+
+// parseExtra splits extra into its records, which share its storage.
+func parseExtra(extra []byte) ([]ExtraField, error) {
+	var fields []ExtraField
+	for len(extra) > 0 {
+		if len(extra) < 4 {
+			return fields, fmt.Errorf("truncated extra field: %w", zip.ErrFormat)
+		}
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if size > len(extra)-4 {
+			return fields, fmt.Errorf("extra field %#04x overruns the header: %w", id, zip.ErrFormat)
+		}
+		fields = append(fields, ExtraField{ID: id, Data: extra[4 : 4+size]})
+		extra = extra[4+size:]
+	}
+	return fields, nil
+}
+
+// unixTime returns the modification time recorded by one of the Unix extra
+// fields that archive/zip does not interpret, if f has one and has neither of
+// the fields that it does.
+func unixTime(f *zip.File) (time.Time, bool) {
+	fields, _ := parseExtra(f.Extra)
+	var t time.Time
+	var ok bool
+	for _, field := range fields {
+		switch field.ID {
+		case ExtraTimestamp, ExtraNTFS:
+			return time.Time{}, false // already used by archive/zip
+		case ExtraPKWAREUnix, ExtraInfoZIPOld:
+			// Both begin with the access and modification times, as 32-bit
+			// seconds since the Unix epoch.
+			if len(field.Data) >= 8 {
+				t, ok = time.Unix(int64(binary.LittleEndian.Uint32(field.Data[4:])), 0).UTC(), true
+			}
+		}
+	}
+	return t, ok
+}
+
+// hasUnixField reports whether f has an extra field that only Unix systems
+// write.
+func hasUnixField(f *zip.File) bool {
+	fields, _ := parseExtra(f.Extra)
+	for _, field := range fields {
+		switch field.ID {
+		case ExtraPKWAREUnix, ExtraInfoZIPOld, ExtraUnixOwner:
+			return true
+		}
+	}
+	return false
+}
+
+// Unix file type bits, as stored in the upper half of the external attributes.
+const (
+	unixIFMT   = 0xf000
+	unixIFSOCK = 0xc000
+	unixIFLNK  = 0xa000
+	unixIFREG  = 0x8000
+	unixIFBLK  = 0x6000
+	unixIFDIR  = 0x4000
+	unixIFCHR  = 0x2000
+	unixIFIFO  = 0x1000
+	unixISUID  = 0x800
+	unixISGID  = 0x400
+	unixISVTX  = 0x200
+)
+
+// unixMode converts the Unix mode m to an os.FileMode, as archive/zip does for
+// entries created on Unix.
+func unixMode(m uint32) os.FileMode {
+	mode := os.FileMode(m & 0777)
+	switch m & unixIFMT {
+	case unixIFBLK:
+		mode |= os.ModeDevice
+	case unixIFCHR:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case unixIFDIR:
+		mode |= os.ModeDir
+	case unixIFIFO:
+		mode |= os.ModeNamedPipe
+	case unixIFLNK:
+		mode |= os.ModeSymlink
+	case unixIFSOCK:
+		mode |= os.ModeSocket
+	}
+	if m&unixISGID != 0 {
+		mode |= os.ModeSetgid
+	}
+	if m&unixISUID != 0 {
+		mode |= os.ModeSetuid
+	}
+	if m&unixISVTX != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
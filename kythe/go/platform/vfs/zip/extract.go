@@ -0,0 +1,443 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// An ExtractOption is a configurable setting for Extract.
+type ExtractOption func(*extractOptions)
+
+type extractOptions struct {
+	parallelism int
+	progress    func(done, total int)
+	perms       bool
+	special     bool
+}
+
+// ExtractParallelism returns an ExtractOption that sets the number of files
+// that are extracted concurrently.  The default, also used if n <= 0, is
+// runtime.GOMAXPROCS(0), since decompression is usually the bottleneck.
+func ExtractParallelism(n int) ExtractOption {
+	return func(o *extractOptions) { o.parallelism = n }
+}
+
+// ExtractProgress returns an ExtractOption that calls fn after each entry is
+// extracted, with the number of entries extracted so far and the total.  The
+// calls are not concurrent.
+func ExtractProgress(fn func(done, total int)) ExtractOption {
+	return func(o *extractOptions) { o.progress = fn }
+}
+
+// PreservePermissions returns an ExtractOption that gives each extracted file
+// and directory the permissions recorded in the archive.  By default, files
+// are created with mode 0644 and directories with mode 0755, before the umask.
+func PreservePermissions() ExtractOption {
+	return func(o *extractOptions) { o.perms = true }
+}
+
+// ExtractSpecialFiles returns an ExtractOption that recreates the named pipes
+// (FIFOs) of the archive, on platforms that support them, rather than
+// skipping them.  Devices and sockets are always skipped, since the archive
+// does not record enough to recreate them.
+func ExtractSpecialFiles() ExtractOption {
+	return func(o *extractOptions) { o.special = true }
+}
+
+// specialModes are the mode bits of special files: those that are neither
+// regular files, directories, nor symbolic links.
+const specialModes = os.ModeNamedPipe | os.ModeDevice | os.ModeCharDevice | os.ModeSocket | os.ModeIrregular
+
+// isSpecial reports whether f is the entry of a special file, as recorded by
+// the Unix mode bits of its external attributes.
+func isSpecial(f *zip.File) bool { return entryMode(f)&specialModes != 0 }
+
+// extractTarget returns the local path to which the entry with the given name
+// should be extracted beneath destDir.  It reports ErrUnsafePath for names that
+// would escape destDir, whether by means of "..", an absolute path, or a
+// separator that is special to the host OS.
+func extractTarget(destDir, name string) (string, error) {
+	clean, err := cleanPath(name)
+	if err != nil || strings.HasPrefix(clean, "/") || strings.Contains(clean, `\`) || filepath.VolumeName(clean) != "" {
+		return "", &os.PathError{Op: "extract", Path: name, Err: ErrUnsafePath}
+	}
+	target := filepath.Join(destDir, filepath.FromSlash(clean))
+	if rel, err := filepath.Rel(destDir, target); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &os.PathError{Op: "extract", Path: name, Err: ErrUnsafePath}
+	}
+	return target, nil
+}
+
+// An extraction is an archive entry and the local path to extract it to.
+type extraction struct {
+	f      *zip.File
+	target string
+	link   string // the target of a symbolic link
+}
+
+// underLink reports whether the cleaned name lies beneath one of links, the
+// cleaned names of the symbolic links of an archive, so that extracting it
+// would write through the link.
+func underLink(name string, links map[string]bool) bool {
+	for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if links[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// linkNames returns the cleaned names, relative to the root of z, of the
+// symbolic links of z.
+func (z FS) linkNames() map[string]bool {
+	links := make(map[string]bool)
+	for _, f := range z.archive().File {
+		if name, ok := z.rel(f); ok && isSymlink(f) {
+			links[strings.TrimSuffix(cleanName(name), "/")] = true
+		}
+	}
+	return links
+}
+
+// Extract writes the contents of the archive beneath the local directory
+// destDir, creating it if necessary, and preserving the modification times of
+// the entries.  Extract checks the names of all the entries before writing
+// anything, and fails with an error wrapping ErrUnsafePath if extracting any of
+// them would write outside destDir (the "Zip Slip" vulnerability).
+//
+// Directories are created first, one at a time, and then files are extracted
+// concurrently, as set by ExtractParallelism.  The first error stops the
+// extraction of the remaining files and is returned.  Entries for special
+// files, such as named pipes and devices, are skipped, and logged, rather than
+// written as regular files; see ExtractSpecialFiles.  Symbolic links are
+// created after the files, and Extract fails with an error wrapping
+// ErrUnsafePath, before writing anything, if the target of any of them is
+// absolute or refers outside destDir, or if any entry lies beneath one of them.
+func (z FS) Extract(ctx context.Context, destDir string, opts ...ExtractOption) error {
+	var o extractOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.parallelism <= 0 {
+		o.parallelism = runtime.GOMAXPROCS(0)
+	}
+
+	for _, f := range z.archive().File {
+		if !safeName(f.Name) {
+			return &os.PathError{Op: "extract", Path: f.Name, Err: ErrUnsafePath}
+		}
+	}
+	links := z.linkNames()
+	var dirs, files, specials, symlinks []extraction
+	for _, f := range z.index().entries {
+		name, ok := z.rel(f)
+		if !ok {
+			continue
+		}
+		target, err := extractTarget(destDir, name)
+		if err != nil {
+			return err
+		} else if underLink(strings.TrimSuffix(cleanName(name), "/"), links) {
+			return &os.PathError{Op: "extract", Path: name, Err: ErrUnsafePath}
+		}
+		switch {
+		case isSpecial(f):
+			if o.special && entryMode(f)&os.ModeType == os.ModeNamedPipe && canMkfifo {
+				specials = append(specials, extraction{f: f, target: target})
+			} else {
+				z.logf("not extracting special file %q (%v)", name, entryMode(f).Type())
+			}
+		case isSymlink(f):
+			link, err := z.readlink(f)
+			if err != nil {
+				return &os.PathError{Op: "readlink", Path: name, Err: err}
+			} else if !safeLink(name, link) {
+				return &os.PathError{Op: "extract", Path: name, Err: ErrUnsafePath}
+			}
+			symlinks = append(symlinks, extraction{f: f, target: target, link: link})
+		case f.FileInfo().IsDir():
+			dirs = append(dirs, extraction{f: f, target: target})
+		default:
+			files = append(files, extraction{f: f, target: target})
+		}
+	}
+
+	// Create all the directories first, so that files can be written to them
+	// independently.
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return err
+	}
+	for _, d := range dirs {
+		if err := os.MkdirAll(d.target, 0755); err != nil {
+			return err
+		}
+	}
+	for _, f := range append(append(files, specials...), symlinks...) {
+		if err := os.MkdirAll(filepath.Dir(f.target), 0755); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu       sync.Mutex
+		firstErr error
+		done     int
+		wg       sync.WaitGroup
+		work     = make(chan extraction)
+		total    = len(dirs) + len(files) + len(specials) + len(symlinks)
+	)
+	fail := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+			cancel() // stop the remaining work
+		}
+	}
+	report := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			fail(err)
+		}
+		done++
+		if o.progress != nil {
+			o.progress(done, total)
+		}
+	}
+	for _, s := range specials {
+		if err := extractFifo(s, o); err != nil {
+			return err
+		}
+		report(nil)
+	}
+	for i := 0; i < o.parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range work {
+				if ctx.Err() != nil {
+					continue // drain the work already queued
+				}
+				report(z.extractFile(ctx, e, o))
+			}
+		}()
+	}
+feed:
+	for _, f := range files {
+		select {
+		case work <- f:
+		case <-ctx.Done():
+			mu.Lock()
+			fail(ctx.Err())
+			mu.Unlock()
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+	if firstErr == nil {
+		firstErr = ctx.Err() // some files may have been skipped
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	for _, l := range symlinks {
+		if err := extractSymlink(l); err != nil {
+			return err
+		}
+		report(nil)
+	}
+
+	// Directory permissions and times are set last, since writing their
+	// contents requires the one and changes the other.  Children come after
+	// their parents in the archive, so set them in reverse order.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d := dirs[i]
+		if o.perms {
+			if err := os.Chmod(d.target, d.f.Mode().Perm()); err != nil {
+				return err
+			}
+		}
+		if err := os.Chtimes(d.target, entryTime(d.f), entryTime(d.f)); err != nil {
+			return err
+		}
+		report(nil)
+	}
+	return firstErr
+}
+
+// extractFile writes the contents of e.f to e.target, stopping early if ctx
+// ends.
+func (z FS) extractFile(ctx context.Context, e extraction, o extractOptions) error {
+	rc, err := z.openEntry(e.f)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	mode := os.FileMode(0644)
+	if o.perms {
+		mode = e.f.Mode().Perm()
+	}
+	out, err := os.OpenFile(e.target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := z.copyBuffer(out, ctxReader{ctx, rc}); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if o.perms {
+		// Apply the recorded permissions exactly, regardless of the umask.
+		if err := os.Chmod(e.target, mode); err != nil {
+			return err
+		}
+	}
+	return os.Chtimes(e.target, entryTime(e.f), entryTime(e.f))
+}
+
+// extractFifo creates the named pipe e.target for the entry e.f.
+func extractFifo(e extraction, o extractOptions) error {
+	mode := os.FileMode(0644)
+	if o.perms {
+		mode = entryMode(e.f).Perm()
+	}
+	if err := os.Remove(e.target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := mkfifo(e.target, uint32(mode)); err != nil {
+		return &os.PathError{Op: "mkfifo", Path: e.target, Err: err}
+	}
+	if o.perms {
+		// Apply the recorded permissions exactly, regardless of the umask.
+		if err := os.Chmod(e.target, mode); err != nil {
+			return err
+		}
+	}
+	return os.Chtimes(e.target, entryTime(e.f), entryTime(e.f))
+}
+
+// extractSymlink creates the symbolic link e.target for the entry e.f.  The
+// time of the link is not set, since os.Chtimes would set that of its target.
+func extractSymlink(e extraction) error {
+	if err := os.Remove(e.target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Symlink(filepath.FromSlash(e.link), e.target)
+}
+
+// ctxReader is a reader that fails with the error of its context once the
+// context ends.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// Read implements the io.Reader interface.
+func (c ctxReader) Read(buf []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(buf)
+}
+
+// A PlanEntry describes what Extract would do with one entry of an archive.
+type PlanEntry struct {
+	Name   string      // the name of the entry, relative to the root of the FS
+	Target string      // the local path to write, or "" if the name is unsafe
+	Type   os.FileMode // the type of the entry, such as os.ModeDir, or 0 for a regular file
+	Size   int64       // the uncompressed size recorded in the archive
+	Link   string      // the target of a symbolic link
+
+	// Unsafe reports that the entry would escape the destination directory,
+	// because its name does, or because it is a symbolic link whose target
+	// does.
+	Unsafe bool
+}
+
+// ExtractPlan returns a description of each entry that Extract would write
+// beneath destDir, in archive order, without writing anything, so that the
+// extraction of an untrusted archive can be reviewed first.  Entries with
+// unsafe names, which the FS otherwise ignores, are included and marked
+// Unsafe, as are symbolic links whose targets are absolute or refer outside
+// destDir, and entries beneath symbolic links; Extract refuses archives with
+// any such entry.  Special files, which Extract skips, are included with their
+// types.  Only the contents of symbolic links are read.
+func (z FS) ExtractPlan(destDir string) ([]PlanEntry, error) {
+	idx := z.index()
+	links := z.linkNames()
+	var plan []PlanEntry
+	for _, f := range z.archive().File {
+		name, ok := z.rel(f)
+		if !ok {
+			continue
+		}
+		safe := safeName(f.Name)
+		if safe && idx.files[z.key(strings.TrimSuffix(cleanName(f.Name), "/"))] != f {
+			continue // superseded by another entry with the same name
+		}
+		e := PlanEntry{
+			Name: name,
+			Type: entryMode(f).Type(),
+			Size: int64(f.UncompressedSize64),
+		}
+		if target, err := extractTarget(destDir, name); safe && err == nil {
+			e.Target = target
+		} else {
+			e.Unsafe = true
+		}
+		if underLink(strings.TrimSuffix(cleanName(name), "/"), links) {
+			e.Unsafe = true
+		}
+		if isSymlink(f) {
+			link, err := z.readlink(f)
+			if err != nil {
+				return nil, &os.PathError{Op: "readlink", Path: name, Err: err}
+			}
+			e.Link = link
+			if !safeLink(name, link) {
+				e.Unsafe = true
+			}
+		}
+		plan = append(plan, e)
+	}
+	return plan, nil
+}
+
+// safeLink reports whether a symbolic link with the given name and target
+// refers to a path within the root of the archive.
+func safeLink(name, target string) bool {
+	if path.IsAbs(target) || filepath.IsAbs(target) || filepath.VolumeName(target) != "" || strings.Contains(target, `\`) {
+		return false
+	}
+	_, err := cleanPath(path.Join(path.Dir(name), target))
+	return err == nil
+}
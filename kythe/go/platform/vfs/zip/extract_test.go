@@ -0,0 +1,349 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// tempDir returns a new temporary directory and a function to remove it.
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "ziptest")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestExtract(t *testing.T) {
+	ctx := context.Background()
+	modTime := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, e := range []struct {
+		name string
+		mode os.FileMode
+	}{
+		{"bin/", os.ModeDir | 0755},
+		{"bin/tool", 0755},
+		{"src/a/b.txt", 0644},
+		{"readonly.txt", 0444},
+	} {
+		fh := &zip.FileHeader{Name: e.name, Method: zip.Deflate, Modified: modTime}
+		fh.SetMode(e.mode)
+		f, err := w.CreateHeader(fh)
+		if err != nil {
+			t.Fatalf("CreateHeader %q: %v", e.name, err)
+		}
+		if !e.mode.IsDir() {
+			f.Write([]byte("contents of " + e.name))
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	z, err := Open(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	var calls, total int
+	if err := z.Extract(ctx, filepath.Join(dir, "out"),
+		ExtractParallelism(2),
+		PreservePermissions(),
+		ExtractProgress(func(d, n int) { calls, total = d, n }),
+	); err != nil {
+		t.Fatalf("Extract: unexpected error: %v", err)
+	}
+	if calls != 4 || total != 4 {
+		t.Errorf("Progress: got %d/%d entries, want 4/4", calls, total)
+	}
+
+	for name, mode := range map[string]os.FileMode{
+		"bin/tool":     0755,
+		"src/a/b.txt":  0644,
+		"readonly.txt": 0444,
+	} {
+		path := filepath.Join(dir, "out", filepath.FromSlash(name))
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Errorf("ReadFile %q: unexpected error: %v", name, err)
+		} else if got, want := string(data), "contents of "+name; got != want {
+			t.Errorf("ReadFile %q: got %q, want %q", name, got, want)
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Errorf("Stat %q: unexpected error: %v", name, err)
+			continue
+		}
+		if got := fi.Mode().Perm(); got != mode {
+			t.Errorf("Mode of %q: got %v, want %v", name, got, mode)
+		}
+		if !fi.ModTime().Equal(modTime) {
+			t.Errorf("ModTime of %q: got %v, want %v", name, fi.ModTime(), modTime)
+		}
+	}
+}
+
+func TestExtractUnsafe(t *testing.T) {
+	for _, name := range []string{"../evil.txt", "a/../../evil.txt"} {
+		z := openArchive(t, "good.txt", name)
+		dir, cleanup := tempDir(t)
+		defer cleanup()
+
+		out := filepath.Join(dir, "out")
+		if err := z.Extract(context.Background(), out); !errors.Is(err, ErrUnsafePath) {
+			t.Errorf("Extract with %q: got error %v, want %v", name, err, ErrUnsafePath)
+		}
+		if _, err := os.Stat(out); !os.IsNotExist(err) {
+			t.Errorf("Extract with %q: destination was created (%v)", name, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "evil.txt")); !os.IsNotExist(err) {
+			t.Errorf("Extract with %q: wrote outside the destination (%v)", name, err)
+		}
+	}
+}
+
+func TestExtractFirstError(t *testing.T) {
+	ctx := context.Background()
+	z := openArchive(t, "a.txt", "b.txt", "c.txt", "d.txt")
+
+	// A directory where the first file belongs makes its extraction fail.
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	if err := os.MkdirAll(filepath.Join(dir, "a.txt"), 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	err := z.Extract(ctx, dir, ExtractParallelism(1))
+	if err == nil || errors.Is(err, context.Canceled) {
+		t.Fatalf("Extract: got error %v, want the error for %q", err, "a.txt")
+	}
+	for _, name := range []string{"b.txt", "c.txt", "d.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("Stat %q: got error %v, want not exist after the first error", name, err)
+		}
+	}
+
+	// By default, files are extracted by several workers.
+	out, cleanup := tempDir(t)
+	defer cleanup()
+	if err := z.Extract(ctx, out); err != nil {
+		t.Fatalf("Extract: unexpected error: %v", err)
+	}
+	for _, name := range []string{"a.txt", "b.txt", "c.txt", "d.txt"} {
+		if data, err := ioutil.ReadFile(filepath.Join(out, name)); err != nil || string(data) != "contents of "+name {
+			t.Errorf("ReadFile %q: got %q, %v; want %q", name, data, err, "contents of "+name)
+		}
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := z.Extract(cctx, out); err != context.Canceled {
+		t.Errorf("Extract with cancelled context: got error %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestExtractPlan(t *testing.T) {
+	data := newSymlinkArchive(t, []string{"a/b.txt", "../evil.txt"}, map[string]string{
+		"a/ok":     "b.txt",
+		"a/parent": "../a/b.txt",
+		"a/up":     "../../outside",
+		"abs":      "/etc/passwd",
+	})
+	z, err := Open(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	plan, err := z.ExtractPlan(dir)
+	if err != nil {
+		t.Fatalf("ExtractPlan: unexpected error: %v", err)
+	}
+
+	want := map[string]PlanEntry{
+		"a/b.txt":     {Target: filepath.Join(dir, "a", "b.txt"), Size: int64(len("contents of a/b.txt"))},
+		"../evil.txt": {Size: int64(len("contents of ../evil.txt")), Unsafe: true},
+		"a/ok":        {Target: filepath.Join(dir, "a", "ok"), Type: os.ModeSymlink, Link: "b.txt"},
+		"a/parent":    {Target: filepath.Join(dir, "a", "parent"), Type: os.ModeSymlink, Link: "../a/b.txt"},
+		"a/up":        {Target: filepath.Join(dir, "a", "up"), Type: os.ModeSymlink, Link: "../../outside", Unsafe: true},
+		"abs":         {Target: filepath.Join(dir, "abs"), Type: os.ModeSymlink, Link: "/etc/passwd", Unsafe: true},
+	}
+	if len(plan) != len(want) {
+		t.Errorf("ExtractPlan: got %d entries, want %d", len(plan), len(want))
+	}
+	for _, got := range plan {
+		w, ok := want[got.Name]
+		if !ok {
+			t.Errorf("ExtractPlan: unexpected entry %+v", got)
+			continue
+		}
+		w.Name = got.Name
+		if w.Link != "" {
+			w.Size = int64(len(w.Link))
+		}
+		if got != w {
+			t.Errorf("ExtractPlan %q: got %+v, want %+v", got.Name, got, w)
+		}
+	}
+
+	if entries, err := ioutil.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Errorf("ExtractPlan wrote %d entries, %v; want none", len(entries), err)
+	}
+}
+
+func TestExtractSymlinks(t *testing.T) {
+	ctx := context.Background()
+	z, err := Open(bytes.NewReader(newSymlinkArchive(t, []string{"a/b.txt"}, map[string]string{
+		"a/ok":     "b.txt",
+		"a/parent": "../a/b.txt",
+	})))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	plan, err := z.ExtractPlan(dir)
+	if err != nil {
+		t.Fatalf("ExtractPlan: unexpected error: %v", err)
+	}
+	if err := z.Extract(ctx, dir); err != nil {
+		t.Fatalf("Extract: unexpected error: %v", err)
+	}
+
+	// The plan describes the tree that was written.
+	for _, e := range plan {
+		if e.Unsafe {
+			t.Errorf("ExtractPlan %q: unexpectedly unsafe", e.Name)
+			continue
+		}
+		fi, err := os.Lstat(e.Target)
+		if err != nil {
+			t.Errorf("Lstat %q: unexpected error: %v", e.Target, err)
+			continue
+		}
+		if got := fi.Mode().Type(); got != e.Type {
+			t.Errorf("Extract %q: got type %v, want %v as planned", e.Name, got, e.Type)
+		}
+		if e.Type == os.ModeSymlink {
+			if link, err := os.Readlink(e.Target); err != nil || link != filepath.FromSlash(e.Link) {
+				t.Errorf("Readlink %q: got %q, %v; want %q as planned", e.Target, link, err, e.Link)
+			}
+		}
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "a", "parent")); err != nil || string(data) != "contents of a/b.txt" {
+		t.Errorf("ReadFile through link: got %q, %v; want %q", data, err, "contents of a/b.txt")
+	}
+
+	// Links that escape destDir, and entries beneath links, are refused
+	// before anything is written.
+	for _, links := range []map[string]string{
+		{"a/up": "../../outside"},
+		{"abs": "/etc/passwd"},
+		{"a/l": "..", "a/l/m": ".."},
+	} {
+		z, err := Open(bytes.NewReader(newSymlinkArchive(t, []string{"a/b.txt"}, links)))
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		out := filepath.Join(dir, "unsafe")
+		if err := z.Extract(ctx, out); !errors.Is(err, ErrUnsafePath) {
+			t.Errorf("Extract with links %v: got error %v, want %v", links, err, ErrUnsafePath)
+		}
+		if _, err := os.Lstat(out); !os.IsNotExist(err) {
+			t.Errorf("Extract with links %v: wrote %q", links, out)
+		}
+	}
+}
+
+func TestExtractSpecialFiles(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, e := range []struct {
+		name string
+		mode os.FileMode
+	}{
+		{"pipe", os.ModeNamedPipe | 0640},
+		{"dev/tty", os.ModeDevice | os.ModeCharDevice | 0620},
+		{"sock", os.ModeSocket | 0755},
+		{"regular.txt", 0644},
+	} {
+		fh := &zip.FileHeader{Name: e.name}
+		fh.SetMode(e.mode)
+		if _, err := w.CreateHeader(fh); err != nil {
+			t.Fatalf("CreateHeader %q: %v", e.name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	z, err := OpenBytes(buf.Bytes())
+	if err != nil {
+		t.Fatalf("OpenBytes: %v", err)
+	}
+	for name, want := range map[string]os.FileMode{
+		"pipe":        os.ModeNamedPipe,
+		"dev/tty":     os.ModeDevice | os.ModeCharDevice,
+		"sock":        os.ModeSocket,
+		"regular.txt": 0,
+	} {
+		if fi, err := z.Stat(ctx, name); err != nil || fi.Mode().Type() != want {
+			t.Errorf("Stat %q: got %v, %v; want type %v", name, fi, err, want)
+		}
+	}
+
+	for _, special := range []bool{false, true} {
+		dir, cleanup := tempDir(t)
+		defer cleanup()
+		var opts []ExtractOption
+		if special {
+			opts = append(opts, ExtractSpecialFiles())
+		}
+		if err := z.Extract(ctx, dir, opts...); err != nil {
+			t.Fatalf("Extract (special %v): unexpected error: %v", special, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "regular.txt")); err != nil {
+			t.Errorf("Extract (special %v): %v", special, err)
+		}
+		for _, name := range []string{"dev/tty", "sock"} {
+			if _, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(name))); !os.IsNotExist(err) {
+				t.Errorf("Extract (special %v) %q: got error %v, want not exist", special, name, err)
+			}
+		}
+		fi, err := os.Lstat(filepath.Join(dir, "pipe"))
+		if special && canMkfifo {
+			if err != nil || fi.Mode().Type() != os.ModeNamedPipe {
+				t.Errorf("Extract (special %v) %q: got %v, %v; want a named pipe", special, "pipe", fi, err)
+			}
+		} else if !os.IsNotExist(err) {
+			t.Errorf("Extract (special %v) %q: got error %v, want not exist", special, "pipe", err)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"strings"
+	"unicode"
+)
+
+// CaseInsensitive returns an Option that makes the FS match names regardless
+// of case, using Unicode simple case folding, so that Stat, Open, and the
+// other methods taking a path find "README.md" by the name "readme.MD", and
+// Glob matches patterns against names in the same way.  Names are still
+// reported as stored.  Entries whose names differ only in case are then
+// duplicates, which are logged and resolved as set by OnDuplicate, and
+// reported by Validate.
+func CaseInsensitive() Option {
+	return func(z *FS) error {
+		z.foldCase = true
+		return nil
+	}
+}
+
+// key returns the key by which the entry or directory with the given name is
+// indexed: the name itself, or its case folding if z is CaseInsensitive.
+func (z FS) key(name string) string {
+	if !z.foldCase {
+		return name
+	}
+	return foldCase(name)
+}
+
+// foldCase maps each rune of s to the least rune that is equivalent to it
+// under simple case folding, so that strings differing only in case map to
+// the same string.
+func foldCase(s string) string {
+	return strings.Map(func(r rune) rune {
+		min := r
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			if f < min {
+				min = f
+			}
+		}
+		return min
+	}, s)
+}
@@ -16,17 +16,30 @@
 
 // Package zip defines a VFS implementation that understands a zip archive as an
 // isolated, read-only file system.
+//
+// The Stat, ReadDir, and Open methods of an FS also look inside archives that
+// are themselves entries of the archive: a path such as
+// "vendor/lib.zip!/src/main.go" names the entry "src/main.go" of the archive
+// stored as "vendor/lib.zip".  Nested archives are read into memory when first
+// used, and at most 8 levels of nesting are allowed.
+//
+// An archive may be preceded or followed by other data, as in a self-extracting
+// executable or an archive concatenated with another file.
 package zip
 
 import (
 	"archive/zip"
+	"bytes"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"os"
-	"path/filepath"
+	"path"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"kythe.io/kythe/go/platform/vfs"
 
@@ -36,27 +49,377 @@ import (
 var _ vfs.Reader = FS{}
 
 // Open returns a read-only virtual file system (vfs.Reader), using the contents
-// a zip archive read with r.
-func Open(r io.ReadSeeker) (FS, error) {
+// a zip archive read with r.  If r also implements io.ReaderAt, as *os.File and
+// *bytes.Reader do, entries are read with its ReadAt method, which the
+// io.ReaderAt contract makes safe for parallel use; otherwise, reads from r
+// are serialized, since each must seek before reading.
+func Open(r io.ReadSeeker, opts ...Option) (FS, error) {
 	const fromEnd = 2
 	size, err := r.Seek(0, fromEnd)
 	if err != nil {
 		return FS{}, err
 	}
+	if ra, ok := r.(io.ReaderAt); ok {
+		return newFS(ra, size, r, opts)
+	}
+	return newFS(&readerAt{rs: r}, size, r, opts)
+}
 
-	rc, err := zip.NewReader(&readerAt{rs: r}, size)
+// OpenFile returns a read-only virtual file system (vfs.Reader), using the
+// contents of the zip archive stored in the named file.  The caller must close
+// the returned io.Closer to release the file once the FS is no longer needed;
+// it is safe to call it more than once.  Closing the FS has the same effect.
+func OpenFile(path string, opts ...Option) (FS, io.Closer, error) {
+	f, err := os.Open(path)
 	if err != nil {
+		return FS{}, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return FS{}, nil, err
+	}
+
+	var z FS
+	if fi.Mode().IsRegular() {
+		// A regular file supports concurrent positioned reads on its own, so
+		// there is no need to serialize access through a readerAt.
+		z, err = OpenAt(f, fi.Size(), opts...)
+	} else {
+		z, err = Open(f, opts...)
+	}
+	if err != nil {
+		f.Close()
+		return FS{}, nil, err
+	}
+	return z, z.closer, nil
+}
+
+// OpenAt returns a read-only virtual file system (vfs.Reader), using the
+// contents of the zip archive of the given size read with r.  Unlike Open, the
+// reads are not serialized, so r must be safe for concurrent use, as an
+// *os.File or *bytes.Reader is.
+func OpenAt(r io.ReaderAt, size int64, opts ...Option) (FS, error) {
+	return newFS(r, size, r, opts)
+}
+
+// OpenBytes returns a read-only virtual file system (vfs.Reader) for the zip
+// archive held in data, which must not be modified while the FS is in use.
+// Reads are not serialized, so entries may be read concurrently.
+func OpenBytes(data []byte, opts ...Option) (FS, error) {
+	return OpenAt(bytes.NewReader(data), int64(len(data)), opts...)
+}
+
+// newFS constructs an FS over the archive of the given size read with r.  If
+// src implements io.Closer, closing the FS closes src.
+func newFS(r io.ReaderAt, size int64, src interface{}, opts []Option) (FS, error) {
+	z := FS{idx: new(index)}
+	for _, opt := range opts {
+		if err := opt(&z); err != nil {
+			return FS{}, err
+		}
+	}
+	if err := z.load(r, size); err != nil {
 		return FS{}, err
 	}
-	if len(rc.File) == 0 {
-		return FS{}, errors.New("archive has no root directory")
+	if c, ok := src.(io.Closer); ok {
+		z.closer = &onceCloser{src: &sharedCloser{c: c, refs: 1}}
+	}
+	return z, nil
+}
+
+// load reads the central directory of the archive of the given size from r,
+// and checks it against the settings of z.
+func (z *FS) load(r io.ReaderAt, size int64) error {
+	z.lazy = nil
+	if z.lazyDir && !z.strict && z.duplicates != RejectDuplicates && !z.requireMethods {
+		if l, n, ok := newLazyDirectory(r, size); ok {
+			if z.maxEntries > 0 && n > z.maxEntries {
+				return fmt.Errorf("archive has %d entries, more than %d: %w", n, z.maxEntries, ErrTooLarge)
+			}
+			z.Archive, z.src, z.size, z.lazy = nil, r, size, l
+			z.decoded = make(map[*zip.File]bool) // filled in when the directory is read
+			return nil
+		}
+	}
+	rc, err := zip.NewReader(r, size)
+	if err == zip.ErrFormat {
+		rc, err = openEmbedded(r, size)
+	}
+	if err != nil {
+		return err
+	}
+	if !z.rawNames {
+		z.decoded = decodeNames(rc)
+	}
+	if z.maxEntries > 0 && len(rc.File) > z.maxEntries {
+		return fmt.Errorf("archive has %d entries, more than %d: %w", len(rc.File), z.maxEntries, ErrTooLarge)
+	}
+	if z.duplicates == RejectDuplicates {
+		seen := make(map[string]bool, len(rc.File))
+		for _, f := range rc.File {
+			name := z.key(strings.TrimSuffix(f.Name, "/"))
+			if seen[name] {
+				return &os.PathError{Op: "open", Path: f.Name, Err: ErrDuplicateEntry}
+			}
+			seen[name] = true
+		}
+	}
+	if z.strict {
+		for _, f := range rc.File {
+			if !safeName(f.Name) {
+				return &os.PathError{Op: "open", Path: f.Name, Err: ErrUnsafePath}
+			}
+		}
+	}
+	if z.requireMethods {
+		if err := z.checkMethods(rc); err != nil {
+			return err
+		}
+	}
+	z.register(rc)
+	z.Archive, z.src, z.size = rc, r, size
+	return nil
+}
+
+// onceCloser is an io.Closer that releases its reference to src only the first
+// time it is called.
+type onceCloser struct {
+	once sync.Once
+	src  *sharedCloser
+	err  error
+}
+
+// Close implements the io.Closer interface.
+func (o *onceCloser) Close() error {
+	o.once.Do(func() { o.err = o.src.release() })
+	return o.err
+}
+
+// A sharedCloser closes c once each of the references to it, taken by opening
+// an archive and by cloning the resulting FS, has been released.
+type sharedCloser struct {
+	mu   sync.Mutex
+	refs int
+	c    io.Closer
+}
+
+// acquire takes another reference to s, and reports whether s was still open.
+func (s *sharedCloser) acquire() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.refs == 0 {
+		return false
+	}
+	s.refs++
+	return true
+}
+
+// release drops a reference to s, closing c if it was the last.
+func (s *sharedCloser) release() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.refs--; s.refs > 0 {
+		return nil
+	}
+	return s.c.Close()
+}
+
+// FS implements the vfs.Reader interface for zip archives.  If the archive has
+// several entries with the same name, only the last of them is visible, unless
+// another policy is chosen with OnDuplicate.
+type FS struct {
+	Archive *zip.Reader
+
+	src  io.ReaderAt // the source of Archive; nil if not constructed by Open
+	size int64       // the size of src
+
+	prefix string // if non-empty, the directory (ending in "/") that is the root
+	idx    *index // shared by all copies; nil if not constructed by Open
+
+	closer *onceCloser // closes the source of the archive, if non-nil
+	logger Logger      // receives diagnostics, if non-nil
+	verify bool        // verify checksums when reading entries
+
+	maxBytes   int64 // if positive, the most bytes that may be read from an entry
+	maxEntries int   // if positive, the most entries the archive may have
+	strict     bool  // reject archives having entries with unsafe names
+	follow     bool  // follow symbolic links in Open
+	rawNames   bool  // do not decode names from code page 437
+	lazyDir    bool  // defer reading the central directory
+	zstd       bool  // decompress Zstandard entries
+	foldCase   bool  // match names regardless of case
+
+	requireMethods bool // reject archives having entries that cannot be decompressed
+
+	decoded map[*zip.File]bool // the entries whose names were decoded from code page 437
+	lazy    *lazyDirectory     // if non-nil, reads the central directory when needed
+
+	duplicates DuplicatePolicy // which of several entries with the same name is used
+
+	passphrase func(name string) ([]byte, error) // if non-nil, the password for each encrypted entry
+
+	depth int // the number of archives within which this one is nested
+
+	shareMax int64 // if positive, the largest entry whose contents Open shares
+
+	bufSize int // the size of the buffers used to copy data
+
+	globWorkers int                   // the number of goroutines matching entries in Glob
+	progress    func(done, total int) // if non-nil, receives the progress of long scans
+}
+
+// Close releases the source of the archive, if the reader originally passed to
+// Open or OpenAt implements io.Closer; otherwise it does nothing.  Copies of an
+// FS, including those returned by Sub, share the same source, and closing any
+// of them closes all of them; an FS returned by Clone is closed separately.
+// It is safe to call Close more than once.
+func (z FS) Close() error {
+	if z.closer == nil {
+		return nil
+	}
+	return z.closer.Close()
+}
+
+// Clone returns a copy of z that shares its archive, index, and source, but is
+// closed independently: the source is closed only once z and every clone of it
+// have been closed.  This lets several users of one archive, such as the
+// handlers of concurrent requests, each close their FS when done without
+// reading the central directory again.  Sharing is safe because the archive
+// and its index are not modified once built, and the source is only read.
+// If the source has already been closed, closing the clone does nothing.
+func (z FS) Clone() FS {
+	if z.closer != nil {
+		if src := z.closer.src; src.acquire() {
+			z.closer = &onceCloser{src: src}
+		} else {
+			z.closer = nil
+		}
+	}
+	return z
+}
+
+// Exclude returns a view of z in which the entries for whose names pred
+// returns true are absent, so that Stat, Open, Glob, Entries, and the other
+// methods behave as if the archive did not have them.  Names are as for
+// CopyFilter; entries outside the root of z are kept.  Directories remain as
+// long as anything beneath them does, even if their own entries are excluded.
+// The view is built from the index of z without reading the archive again, and
+// shares its source, as the result of Sub does.
+func (z FS) Exclude(pred func(name string) bool) FS {
+	old := z.index()
+	idx := &index{
+		files: make(map[string]*zip.File, len(old.files)),
+		dirs:  make(map[string]time.Time),
+	}
+	idx.once.Do(func() {}) // built here
+	for _, f := range old.entries {
+		if name, ok := z.relClean(f); ok && pred(strings.TrimSuffix(name, "/")) {
+			continue
+		}
+		idx.add(z.key(strings.TrimSuffix(cleanName(f.Name), "/")), f)
 	}
+	for name := range old.mixed {
+		if idx.files[name] != nil {
+			if idx.mixed == nil {
+				idx.mixed = make(map[string]bool)
+			}
+			idx.mixed[name] = true
+		}
+	}
+	// The view has its own index, so it must not look up entries lazily.
+	z.Archive, z.lazy, z.idx = z.archive(), nil, idx
+	return z
+}
+
+// An index maps the cleaned names of archive entries, without any trailing
+// "/", to the entries themselves.  It is built on first use.
+type index struct {
+	once    sync.Once
+	entries []*zip.File          // entries visible in the FS, in archive order
+	files   map[string]*zip.File // the entry used for each name
+	dirs    map[string]time.Time // every directory, explicit or not
+	mixed   map[string]bool      // names having both file and directory entries
 
-	return FS{rc}, err
+	mu      sync.Mutex
+	nested  map[*zip.File]*nestedArchive    // nested archives opened so far
+	shares  map[*zip.File]*share            // entries being read by ShareDecompression
+	digests map[*zip.File][sha256.Size]byte // SHA-256 digests computed by FindBySHA256
 }
 
-// FS implements the vfs.Reader interface for zip archives.
-type FS struct{ Archive *zip.Reader }
+// build populates the maps of idx from the entries of z.  The time recorded
+// for each directory is the latest modification time of anything beneath it,
+// as a best effort for directories without entries of their own.
+func (idx *index) build(z FS) {
+	rc := z.archive()
+	idx.files = make(map[string]*zip.File, len(rc.File))
+	idx.dirs = make(map[string]time.Time)
+	var safe []*zip.File
+	for _, f := range rc.File {
+		if !safeName(f.Name) {
+			z.logf("ignoring entry with unsafe name %q", f.Name)
+			continue
+		}
+		name := z.key(strings.TrimSuffix(cleanName(f.Name), "/"))
+		if old, ok := idx.files[name]; ok {
+			if z.foldCase && strings.TrimSuffix(cleanName(old.Name), "/") != strings.TrimSuffix(cleanName(f.Name), "/") {
+				z.logf("entries %q and %q differ only in case", old.Name, f.Name)
+			}
+			if strings.HasSuffix(old.Name, "/") != strings.HasSuffix(f.Name, "/") {
+				if idx.mixed == nil {
+					idx.mixed = make(map[string]bool)
+				}
+				idx.mixed[name] = true
+			}
+			if z.duplicates == FirstEntryWins {
+				z.logf("ignoring duplicate entry %q", f.Name)
+				continue
+			}
+			z.logf("ignoring duplicate entry %q", old.Name)
+		}
+		idx.files[name] = f
+		safe = append(safe, f)
+	}
+
+	for _, f := range safe {
+		name := z.key(strings.TrimSuffix(cleanName(f.Name), "/"))
+		if idx.files[name] != f {
+			continue // superseded by a later entry
+		}
+		idx.add(name, f)
+	}
+}
+
+// add records f as the visible entry with the given key, and the directories
+// containing it.
+func (idx *index) add(name string, f *zip.File) {
+	idx.entries = append(idx.entries, f)
+	idx.files[name] = f
+	if strings.HasSuffix(f.Name, "/") {
+		idx.addDir(name, entryTime(f))
+	}
+	for i := strings.LastIndex(name, "/"); i > 0; i = strings.LastIndex(name, "/") {
+		name = name[:i]
+		idx.addDir(name, entryTime(f))
+	}
+}
+
+func (idx *index) addDir(name string, t time.Time) {
+	if old, ok := idx.dirs[name]; !ok || t.After(old) {
+		idx.dirs[name] = t
+	}
+}
+
+// index returns the name index for z, building it if necessary.
+func (z FS) index() *index {
+	if z.idx == nil {
+		idx := new(index)
+		idx.build(z)
+		return idx
+	}
+	z.idx.once.Do(func() { z.idx.build(z) })
+	return z.idx
+}
 
 type readerAt struct {
 	sync.Mutex
@@ -75,48 +438,652 @@ func (r *readerAt) ReadAt(buf []byte, pos int64) (int, error) {
 	return r.rs.Read(buf)
 }
 
+// Sub returns a view of z rooted at the directory prefix, as fs.Sub.  Paths
+// passed to the methods of the result are interpreted relative to prefix, and
+// entries outside prefix are invisible.  It is an error if prefix does not
+// exist or names a file rather than a directory.
+func (z FS) Sub(prefix string) (FS, error) {
+	dir, err := cleanPath(prefix)
+	if err != nil {
+		return FS{}, &os.PathError{Op: "sub", Path: prefix, Err: err}
+	} else if isRoot(dir) {
+		return z, nil
+	}
+	fi, err := z.Stat(context.Background(), prefix)
+	if err != nil {
+		return FS{}, &os.PathError{Op: "sub", Path: prefix, Err: os.ErrNotExist}
+	} else if !fi.IsDir() {
+		return FS{}, &os.PathError{Op: "sub", Path: prefix, Err: ErrNotDir}
+	}
+	z.prefix += dir + "/"
+	return z, nil
+}
+
+// isRoot reports whether the cleaned path names the root directory of an FS.
+func isRoot(path string) bool { return path == "." }
+
+// errEscapesRoot is reported for paths that refer outside the archive.
+var errEscapesRoot = fmt.Errorf("path escapes the archive root: %w", os.ErrInvalid)
+
+// cleanPath returns the shortest slash-separated path equivalent to name, as
+// path.Clean, so that "./a//b/" becomes "a/b".  The root is cleaned to ".".  It
+// is an error if name refers outside the root by means of "..".
+func cleanPath(name string) (string, error) {
+	name = path.Clean(name)
+	if name == ".." || strings.HasPrefix(name, "../") {
+		return "", errEscapesRoot
+	}
+	return name, nil
+}
+
+// cleanName returns the archive entry name cleaned as by cleanPath, but with
+// the trailing "/" of a directory entry kept, so that "./a//b/" becomes "a/b/".
+// Entries are indexed by their cleaned names, so that they can be found
+// whatever quirks of naming their producer had.
+func cleanName(name string) string {
+	clean := path.Clean(name)
+	if strings.HasSuffix(name, "/") && clean != "." {
+		clean += "/"
+	}
+	return clean
+}
+
+// ErrUnsafePath is reported for archive entries whose names refer outside the
+// root of the archive, such as "../../etc/passwd".
+var ErrUnsafePath = fmt.Errorf("unsafe entry name: %w", os.ErrInvalid)
+
+// safeName reports whether the archive entry name lies within the root of the
+// archive.  Entries with unsafe names are ignored, so that they can be neither
+// found nor listed.
+func safeName(name string) bool {
+	if strings.HasPrefix(name, "/") {
+		return false
+	}
+	_, err := cleanPath(name)
+	return err == nil
+}
+
+// find returns the archive entry for the cleaned path, or nil if there is
+// none.  A directory entry matches its name with or without the trailing "/".
 func (z FS) find(path string) *zip.File {
-	dirPath := path + string(filepath.Separator)
-	for _, f := range z.Archive.File {
-		switch f.Name {
-		case path, dirPath:
-			return f
-		}
+	// Archive names always use forward slashes regardless of the host OS
+	// (APPNOTE.TXT section 4.4.17), so the index is keyed without a "/".
+	if e, ok := z.lazyFind(z.key(z.prefix + path)); ok {
+		return e.f
 	}
-	return nil
+	return z.index().files[z.key(z.prefix+path)]
+}
+
+// lookup returns the archive entry for path after cleaning it, or an error
+// satisfying os.IsNotExist if there is none.  Errors are labelled with op.
+func (z FS) lookup(op, path string) (*zip.File, error) {
+	name, err := cleanPath(path)
+	if err != nil {
+		return nil, &os.PathError{Op: op, Path: path, Err: err}
+	}
+	if f := z.find(name); f != nil {
+		return f, nil
+	}
+	return nil, &os.PathError{Op: op, Path: path, Err: os.ErrNotExist}
 }
 
 // Stat implements part of vfs.Reader using the file metadata stored in the
-// zip archive.  The path must match one of the archive paths.
-func (z FS) Stat(_ context.Context, path string) (os.FileInfo, error) {
-	f := z.find(path)
+// zip archive.  The path must match one of the archive paths, or be a directory
+// containing one of them; otherwise, the error satisfies os.IsNotExist.  Since
+// many archives do not have entries for their directories, Stat synthesizes
+// information for such directories, with the latest modification time of their
+// contents.  The mode of each entry is as described for Mode.  For paths that
+// have an entry of their own, the Sys method of the result returns a copy of
+// its *zip.FileHeader, as does Header.
+func (z FS) Stat(ctx context.Context, path string) (os.FileInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	z, inner, err := z.resolve(path)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: path, Err: err}
+	}
+	name, err := cleanPath(inner)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: path, Err: err}
+	} else if isRoot(name) {
+		return dirInfo{name: "."}, nil
+	}
+	if f := z.find(name); f != nil {
+		return fileInfo(f), nil
+	}
+	if e, ok := z.lazyFind(z.key(z.prefix + name)); ok && !e.dir {
+		return nil, &os.PathError{Op: "stat", Path: path, Err: os.ErrNotExist}
+	}
+	if t, ok := z.index().dirs[z.key(z.prefix+name)]; ok {
+		return dirInfo{name: name[strings.LastIndex(name, "/")+1:], modTime: t}, nil
+	}
+	return nil, &os.PathError{Op: "stat", Path: path, Err: os.ErrNotExist}
+}
+
+// StatBatch returns the results of Stat for each of paths, in the same order.
+// Since lookups use the index of the archive, the cost is proportional to the
+// number of paths rather than to their number times the number of entries, so
+// checking that a large set of inputs is present is cheap.  If ctx ends while
+// the paths are being resolved, the remaining errors are ctx.Err().
+func (z FS) StatBatch(ctx context.Context, paths []string) ([]os.FileInfo, []error) {
+	infos, errs := make([]os.FileInfo, len(paths)), make([]error, len(paths))
+	for i, path := range paths {
+		infos[i], errs[i] = z.Stat(ctx, path)
+	}
+	return infos, errs
+}
+
+// Exists reports whether path names a file or directory of the archive,
+// including directories that have no entry of their own.  Any error from Stat,
+// not only one satisfying os.IsNotExist, is reported as false.
+func (z FS) Exists(ctx context.Context, path string) bool {
+	_, err := z.Stat(ctx, path)
+	return err == nil
+}
+
+// IsDir reports whether path names a directory of the archive.  If path does
+// not exist, IsDir returns false and an error satisfying os.IsNotExist.
+func (z FS) IsDir(ctx context.Context, path string) (bool, error) {
+	fi, err := z.Stat(ctx, path)
+	if err != nil {
+		return false, err
+	}
+	return fi.IsDir(), nil
+}
+
+// A Kind says what a path names in an archive.
+type Kind int
+
+const (
+	// KindNone is the kind of a path that names nothing in the archive.
+	KindNone Kind = iota
+
+	// KindFile is the kind of a path that names only a file.
+	KindFile
+
+	// KindDir is the kind of a path that names only a directory, whether it
+	// has an entry of its own or is implied by the names of other entries.
+	KindDir
+
+	// KindBoth is the kind of a path that names both a file and a directory,
+	// as when the archive has entries "a" and "a/", or "a" and "a/b".
+	KindBoth
+)
+
+// String returns the name of the kind, such as "KindFile".
+func (k Kind) String() string {
+	switch k {
+	case KindNone:
+		return "KindNone"
+	case KindFile:
+		return "KindFile"
+	case KindDir:
+		return "KindDir"
+	case KindBoth:
+		return "KindBoth"
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Kind reports whether path names a file, a directory, both, or nothing in
+// the archive.  Stat and Open choose one of the entries when a path names
+// both, as given by OnDuplicate, so Kind lets callers detect the collision and
+// handle it explicitly.  Paths may name entries of nested archives.  The
+// error is non-nil only for invalid paths and cancelled contexts; a path that
+// names nothing has KindNone.
+func (z FS) Kind(ctx context.Context, path string) (Kind, error) {
+	if err := ctx.Err(); err != nil {
+		return KindNone, err
+	}
+	z, inner, err := z.resolve(path)
+	if os.IsNotExist(err) {
+		return KindNone, nil
+	} else if err != nil {
+		return KindNone, &os.PathError{Op: "kind", Path: path, Err: err}
+	}
+	name, err := cleanPath(inner)
+	if err != nil {
+		return KindNone, &os.PathError{Op: "kind", Path: path, Err: err}
+	} else if isRoot(name) {
+		return KindDir, nil
+	}
+	idx := z.index()
+	key := z.key(z.prefix + name)
+	if idx.mixed[key] {
+		return KindBoth, nil
+	}
+	f := z.find(name)
+	isFile := f != nil && !strings.HasSuffix(f.Name, "/")
+	_, isDir := idx.dirs[key]
+	switch {
+	case isFile && isDir:
+		return KindBoth, nil
+	case isFile:
+		return KindFile, nil
+	case isDir:
+		return KindDir, nil
+	}
+	return KindNone, nil
+}
+
+// HasDirEntry reports whether the archive has an entry of its own for the
+// directory named by path, such as "a/" for "a", rather than only entries
+// beneath it.  Many archives have no such entries, which WalkDir and ReadDir
+// then synthesize.  Paths are as for Kind; the root has no entry.
+func (z FS) HasDirEntry(path string) bool {
+	_, dir := z.entryForms(path)
+	return dir
+}
+
+// HasFileEntry reports whether the archive has an entry for path that is not
+// a directory entry.  Stat and Open of a path treat a directory entry for it
+// as they would the file form, so this tells the two apart.  Paths are as for
+// Kind.
+func (z FS) HasFileEntry(path string) bool {
+	file, _ := z.entryForms(path)
+	return file
+}
+
+// entryForms reports whether the archive has a file entry and a directory
+// entry for path, whether or not the entry is hidden by another of the same
+// name.
+func (z FS) entryForms(path string) (file, dir bool) {
+	z, inner, err := z.resolve(path)
+	if err != nil {
+		return false, false
+	}
+	name, err := cleanPath(inner)
+	if err != nil || isRoot(name) {
+		return false, false
+	}
+	if z.index().mixed[z.key(z.prefix+name)] {
+		return true, true
+	}
+	f := z.find(name)
 	if f == nil {
-		return nil, fmt.Errorf("path %q does not exist", path)
+		return false, false
+	}
+	isDir := strings.HasSuffix(f.Name, "/")
+	return !isDir, isDir
+}
+
+// ErrNotDir is returned by ReadDir when the requested path names a file
+// rather than a directory.
+var ErrNotDir = errors.New("not a directory")
+
+// ReadDir returns file information for the immediate children of dir, sorted
+// by name.  Directories are included even if the archive has no explicit entry
+// for them.  The root of the archive is named by "." or "".  If dir does not
+// exist the error satisfies os.IsNotExist; if it names a file the error wraps
+// ErrNotDir.
+func (z FS) ReadDir(ctx context.Context, dir string) ([]os.FileInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
-	return f.FileInfo(), nil
+	z, inner, err := z.resolve(dir)
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: dir, Err: err}
+	}
+	name, err := cleanPath(inner)
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: dir, Err: err}
+	}
+	prefix := z.prefix
+	if !isRoot(name) {
+		if fi, err := z.Stat(ctx, name); err != nil {
+			return nil, &os.PathError{Op: "readdir", Path: dir, Err: os.ErrNotExist}
+		} else if !fi.IsDir() {
+			return nil, &os.PathError{Op: "readdir", Path: dir, Err: ErrNotDir}
+		}
+		prefix += name + "/"
+	}
+
+	idx := z.index()
+	children := make(map[string]os.FileInfo) // by key
+	for _, f := range idx.entries {
+		rest, ok := z.cutPrefix(cleanName(f.Name), prefix)
+		if !ok || rest == "" {
+			continue // outside the directory, or the directory's own entry
+		}
+		i := strings.Index(rest, "/")
+		if i < 0 {
+			children[z.key(rest)] = fileInfo(f)
+		} else if name := rest[:i]; i == len(rest)-1 {
+			children[z.key(name)] = fileInfo(f) // an explicit directory entry
+		} else if _, ok := children[z.key(name)]; !ok {
+			children[z.key(name)] = dirInfo{name: name, modTime: idx.dirs[z.key(prefix+name)]}
+		}
+	}
+
+	infos := make([]os.FileInfo, 0, len(children))
+	for _, fi := range children {
+		infos = append(infos, fi)
+	}
+	sort.Sort(byName(infos))
+	return infos, nil
 }
 
+// Roots returns the distinct first components of the names of the entries
+// beneath the root of z, sorted, whether they name files or directories.  The
+// entries of a kzip, for example, all lie beneath a single root directory.
+func (z FS) Roots() []string {
+	seen := make(map[string]bool) // by key
+	var roots []string
+	for _, f := range z.index().entries {
+		name, ok := z.relClean(f)
+		if !ok {
+			continue
+		}
+		if i := strings.Index(name, "/"); i >= 0 {
+			name = name[:i]
+		}
+		if !seen[z.key(name)] {
+			seen[z.key(name)] = true
+			roots = append(roots, name)
+		}
+	}
+	sort.Strings(roots)
+	return roots
+}
+
+type byName []os.FileInfo
+
+func (b byName) Len() int           { return len(b) }
+func (b byName) Less(i, j int) bool { return b[i].Name() < b[j].Name() }
+func (b byName) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
 // Open implements part of vfs.Reader, returning a io.ReadCloser owned by
-// the underlying zip archive. It is safe to open multiple files concurrently,
-// as documented by the zip package.
-func (z FS) Open(_ context.Context, path string) (io.ReadCloser, error) {
-	f := z.find(path)
+// the underlying zip archive.  Like the other methods of FS, Open cleans the
+// path before looking it up, and rejects paths that refer outside the root.
+// It is safe to open multiple files concurrently, as documented by the zip
+// package; the ShareDecompression option lets concurrent opens of the same
+// file share the work of decompressing it.
+func (z FS) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	z, f, err := z.entry(path)
+	if err != nil {
+		return nil, err
+	}
+	if z.shareable(f) {
+		return z.openShared(ctx, f)
+	}
+	return z.openEntry(f)
+}
+
+// entry returns the archive entry that Open reads for path, and the archive
+// that holds it, resolving nested archives and, if enabled, symbolic links.
+func (z FS) entry(path string) (FS, *zip.File, error) {
+	z, inner, err := z.resolve(path)
+	if err != nil {
+		return z, nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	name, err := cleanPath(inner)
+	if err != nil {
+		return z, nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	f := z.find(name)
 	if f == nil {
-		return nil, os.ErrNotExist
+		return z, nil, os.ErrNotExist
+	}
+	if z.follow {
+		if f, err = z.followSymlinks(name, f); err == os.ErrNotExist {
+			return z, nil, err
+		} else if err != nil {
+			return z, nil, &os.PathError{Op: "open", Path: path, Err: err}
+		}
 	}
-	return f.Open()
+	return z, f, nil
 }
 
-// Glob implements part of vfs.Reader using filepath.Match to compare the
-// glob pattern to each archive path.
-func (z FS) Glob(_ context.Context, glob string) ([]string, error) {
+// maxPresize bounds the buffer that ReadFile allocates before reading, since
+// the size recorded in the archive cannot be trusted.
+const maxPresize = 16 << 20
+
+// ReadFile returns the contents of the file at path, which is resolved as for
+// Open.  The buffer is sized from the uncompressed size recorded in the
+// archive, up to a bound, and the read is subject to the limit set by
+// MaxUncompressedBytes.  The CRC-32 of the contents is checked as Open checks
+// it, including for entries with no recorded checksum if VerifyChecksums is
+// set.
+func (z FS) ReadFile(ctx context.Context, path string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	z, f, err := z.entry(path)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := z.openEntry(f)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	size := f.UncompressedSize64
+	if size > maxPresize {
+		size = maxPresize
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, int(size)+bytes.MinRead))
+	if _, err := buf.ReadFrom(rc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// CopyFile writes the contents of the file at path, which is resolved as for
+// Open, to dst, and returns the number of bytes written.  Unlike ReadFile, it
+// does not hold the contents in memory, so it suits large entries bound for a
+// network connection or another process.  The copy stops with the error of
+// ctx once ctx ends, after the current block of at most 32 KiB.  The CRC-32
+// and size of the contents are checked as Open checks them.
+func (z FS) CopyFile(ctx context.Context, dst io.Writer, path string) (int64, error) {
+	rc, err := z.Open(ctx, path)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	return z.copyBuffer(dst, ctxReader{ctx, rc})
+}
+
+// openEntry returns a reader for the decompressed contents of f, honoring the
+// options of z.
+func (z FS) openEntry(f *zip.File) (io.ReadCloser, error) {
+	if z.maxBytes > 0 && f.UncompressedSize64 > uint64(z.maxBytes) {
+		return nil, tooLargeError(f.Name, z.maxBytes)
+	}
+	encrypted := isEncrypted(f)
+	var rc io.ReadCloser
+	var err error
+	if encrypted {
+		rc, err = z.openEncrypted(f)
+	} else {
+		rc, err = f.Open()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if z.maxBytes > 0 {
+		rc = &limitedReader{rc: rc, name: f.Name, max: z.maxBytes, left: z.maxBytes}
+	}
+	if z.verify && !encrypted { // decrypted entries are always verified
+		rc = newVerifyingReader(rc, f)
+	}
+	return rc, nil
+}
+
+// ErrTooLarge is reported for archives and entries that exceed the limits set
+// by the MaxUncompressedBytes and MaxEntries options.
+var ErrTooLarge = errors.New("archive exceeds limit")
+
+func tooLargeError(name string, max int64) error {
+	return fmt.Errorf("entry %q is larger than %d bytes: %w", name, max, ErrTooLarge)
+}
+
+// limitedReader fails once more than max bytes have been read from rc.  It
+// differs from io.LimitedReader in reporting an error, rather than EOF.
+type limitedReader struct {
+	rc        io.ReadCloser
+	name      string
+	max, left int64
+}
+
+// Read implements the io.Reader interface.
+func (l *limitedReader) Read(buf []byte) (int, error) {
+	if int64(len(buf)) > l.left+1 {
+		buf = buf[:l.left+1] // read one byte more, to detect overflow
+	}
+	n, err := l.rc.Read(buf)
+	if int64(n) > l.left {
+		n, l.left = int(l.left), 0
+		return n, tooLargeError(l.name, l.max)
+	}
+	l.left -= int64(n)
+	return n, err
+}
+
+// Close implements the io.Closer interface.
+func (l *limitedReader) Close() error { return l.rc.Close() }
+
+// rel returns the name of f relative to the root of z, and reports whether f
+// is visible in z at all.  The entry for the root directory itself is not.
+func (z FS) rel(f *zip.File) (string, bool) {
+	rest, ok := z.cutPrefix(f.Name, z.prefix)
+	return rest, ok && rest != ""
+}
+
+// relClean is as rel, but for the cleaned name of f, as given by cleanName.
+func (z FS) relClean(f *zip.File) (string, bool) {
+	name := cleanName(f.Name)
+	rest, ok := z.cutPrefix(name, z.prefix)
+	return rest, ok && rest != "" && name != "."
+}
+
+// cutPrefix returns name without the directory prefix, which is empty or ends
+// in "/", and reports whether name begins with prefix.  Names are compared by
+// their keys.
+func (z FS) cutPrefix(name, prefix string) (string, bool) {
+	if !z.foldCase {
+		if !strings.HasPrefix(name, prefix) {
+			return "", false
+		}
+		return name[len(prefix):], true
+	}
+	// Folding may change the length of a name, so cut it after as many
+	// components as the prefix has.
+	i := 0
+	for n := strings.Count(prefix, "/"); n > 0; n-- {
+		j := strings.Index(name[i:], "/")
+		if j < 0 {
+			return "", false
+		}
+		i += j + 1
+	}
+	if z.key(name[:i]) != z.key(prefix) {
+		return "", false
+	}
+	return name[i:], true
+}
+
+// checkInterval is the number of entries scanned between checks for
+// cancellation of the context.
+const checkInterval = 1024
+
+// Glob implements part of vfs.Reader using path.Match to compare the glob
+// pattern to each archive path.  Since archive paths always use forward slashes,
+// so do patterns, regardless of the host OS.  In addition, a pattern segment of
+// "**" matches zero or more path components, so "kythe/**/*.go" matches every
+// .go file beneath the kythe directory.  A malformed pattern is reported as
+// path.ErrBadPattern.  The matches are returned in sorted order, regardless of
+// the order of the entries in the archive.  The GlobParallelism option spreads
+// the scan of large archives over several goroutines.  Names are matched
+// exactly as stored; GlobClean matches their cleaned forms.  Braces are
+// literal; GlobBraces expands groups of alternatives.
+func (z FS) Glob(ctx context.Context, glob string) ([]string, error) {
+	if err := vfs.CheckPattern(glob); err != nil {
+		return nil, err
+	}
+	return z.scan(ctx, z.rel, z.globMatcher(glob))
+}
+
+// GlobClean is as Glob, but matches glob against the cleaned names of the
+// entries, as for lookups, and returns those names.  The results are thus
+// independent of the naming quirks of the producer of the archive: an entry
+// named "./src//a.go" is matched, and returned, as "src/a.go", which is also
+// the path by which Open finds it.  Glob matches and returns the names exactly
+// as stored.
+func (z FS) GlobClean(ctx context.Context, glob string) ([]string, error) {
+	if err := vfs.CheckPattern(glob); err != nil {
+		return nil, err
+	}
+	return z.scan(ctx, z.relClean, z.globMatcher(glob))
+}
+
+// GlobBraces is as Glob, but first expands any groups of alternatives in glob,
+// such as "{cmd,internal}/**/*.go", as vfs.ExpandBraces does, and returns
+// the union of the matches of the resulting patterns.
+func (z FS) GlobBraces(ctx context.Context, glob string) ([]string, error) {
+	return vfs.GlobBraces(ctx, z, glob)
+}
+
+// A matcher reports whether to select the entry f, whose name is as given.
+type matcher func(f *zip.File, name string) (bool, error)
+
+// globMatcher returns a matcher for the names that match glob, which must be
+// a valid pattern.  If z ignores case, the keys of the names are matched
+// against the key of glob.
+func (z FS) globMatcher(glob string) matcher {
+	if z.foldCase {
+		glob = z.key(glob)
+		return func(_ *zip.File, name string) (bool, error) { return vfs.Match(glob, z.key(name)) }
+	}
+	return func(_ *zip.File, name string) (bool, error) { return vfs.Match(glob, name) }
+}
+
+// scan returns the names given by rel of the entries of z selected by m, in
+// sorted order.  Large archives are scanned in parallel if GlobParallelism
+// allows it, and the progress of the scan is reported if Progress asks for it.
+func (z FS) scan(ctx context.Context, rel func(*zip.File) (string, bool), m matcher) ([]string, error) {
+	entries := z.index().entries
+	p := newProgress(z.progress, len(entries))
+	var names []string
+	var err error
+	if z.globWorkers > 1 && len(entries) >= 2*minShard {
+		names, err = z.scanParallel(ctx, entries, rel, m, p)
+	} else {
+		names, err = scan(ctx, entries, rel, m, p)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// scan returns the names given by rel of those of entries selected by m, in
+// the order of entries, adding the entries scanned to p.
+func scan(ctx context.Context, entries []*zip.File, rel func(*zip.File) (string, bool), m matcher, p *progress) ([]string, error) {
 	var names []string
-	for _, f := range z.Archive.File {
-		if ok, err := filepath.Match(glob, f.Name); err != nil {
-			log.Panicf("Invalid glob pattern %q: %v", glob, err)
+	for i, f := range entries {
+		if i%checkInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if i > 0 {
+				p.add(checkInterval)
+			}
+		}
+		name, ok := rel(f)
+		if !ok {
+			continue
+		}
+		if ok, err := m(f, name); err != nil {
+			return nil, err
 		} else if ok {
-			names = append(names, f.Name)
+			names = append(names, name)
 		}
 	}
+	p.add(len(entries) - (len(entries)-1)/checkInterval*checkInterval)
 	return names, nil
 }
@@ -0,0 +1,3780 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"kythe.io/kythe/go/platform/vfs"
+
+	"golang.org/x/net/context"
+)
+
+// An entry is the name and contents of a test archive entry.
+type entry struct{ name, data string }
+
+// newArchive returns the bytes of a zip archive containing the given entries,
+// in order.  Names ending in "/" are written as directory entries; each file
+// contains "contents of " followed by its name.
+func newArchive(t *testing.T, names ...string) []byte {
+	var entries []entry
+	for _, name := range names {
+		e := entry{name: name}
+		if name[len(name)-1] != '/' {
+			e.data = "contents of " + name
+		}
+		entries = append(entries, e)
+	}
+	return newArchiveEntries(t, entries...)
+}
+
+// newArchiveEntries returns the bytes of a zip archive containing the given
+// entries, in order.
+func newArchiveEntries(t *testing.T, entries ...entry) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, e := range entries {
+		f, err := w.Create(e.name)
+		if err != nil {
+			t.Fatalf("Create %q: %v", e.name, err)
+		}
+		if _, err := f.Write([]byte(e.data)); err != nil {
+			t.Fatalf("Write %q: %v", e.name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// openArchive returns an FS over a new archive containing the given entries.
+func openArchive(t *testing.T, entries ...string) FS {
+	z, err := Open(bytes.NewReader(newArchive(t, entries...)))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	return z
+}
+
+func TestStdFS(t *testing.T) {
+	fsys := openArchive(t, "a/", "a/b.txt").StdFS()
+
+	data, err := fs.ReadFile(fsys, "a/b.txt")
+	if err != nil {
+		t.Errorf("ReadFile %q: unexpected error: %v", "a/b.txt", err)
+	} else if got, want := string(data), "contents of a/b.txt"; got != want {
+		t.Errorf("ReadFile %q: got %q, want %q", "a/b.txt", got, want)
+	}
+
+	if fi, err := fs.Stat(fsys, "a"); err != nil {
+		t.Errorf("Stat %q: unexpected error: %v", "a", err)
+	} else if !fi.IsDir() {
+		t.Errorf("Stat %q: got mode %v, want a directory", "a", fi.Mode())
+	}
+
+	for _, name := range []string{"missing", "a/missing.txt"} {
+		if _, err := fsys.Open(name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Open %q: got error %v, want %v", name, err, fs.ErrNotExist)
+		}
+	}
+	for _, name := range []string{"/a/b.txt", "./a/b.txt", "a/../a/b.txt"} {
+		if _, err := fsys.Open(name); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("Open %q: got error %v, want %v", name, err, fs.ErrInvalid)
+		}
+	}
+
+	// Nested archives are opened as by FS.Open.
+	z, err := OpenBytes(newArchiveEntries(t, entry{"lib.zip", string(newArchive(t, "src/main.go"))}))
+	if err != nil {
+		t.Fatalf("OpenBytes: %v", err)
+	}
+	nested := z.StdFS()
+	if data, err := fs.ReadFile(nested, "lib.zip!/src/main.go"); err != nil || string(data) != "contents of src/main.go" {
+		t.Errorf("ReadFile %q: got %q, %v; want %q", "lib.zip!/src/main.go", data, err, "contents of src/main.go")
+	}
+	if _, err := nested.Open("lib.zip!/missing.go"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open %q: got error %v, want %v", "lib.zip!/missing.go", err, fs.ErrNotExist)
+	}
+}
+
+func TestStdFSConformance(t *testing.T) {
+	z := openArchive(t, "top.txt", "a/", "a/b.txt", "a/c/d.txt", "e/f/g.txt", "e/h.txt")
+	if err := fstest.TestFS(z.StdFS(), "top.txt", "a/b.txt", "a/c/d.txt", "e/f/g.txt", "e/h.txt"); err != nil {
+		t.Error(err)
+	}
+	sub, err := z.Sub("e")
+	if err != nil {
+		t.Fatalf("Sub: %v", err)
+	}
+	if err := fstest.TestFS(sub.StdFS(), "f/g.txt", "h.txt"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReadDir(t *testing.T) {
+	ctx := context.Background()
+	z := openArchive(t, "top.txt", "a/", "a/b.txt", "a/c/d.txt", "e/f.txt")
+
+	tests := []struct {
+		dir  string
+		want []string
+	}{
+		{".", []string{"a", "e", "top.txt"}},
+		{"", []string{"a", "e", "top.txt"}},
+		{"a", []string{"b.txt", "c"}},
+		{"a/c", []string{"d.txt"}},
+		{"e/", []string{"f.txt"}},
+	}
+	for _, test := range tests {
+		infos, err := z.ReadDir(ctx, test.dir)
+		if err != nil {
+			t.Errorf("ReadDir %q: unexpected error: %v", test.dir, err)
+			continue
+		}
+		var got []string
+		for _, fi := range infos {
+			got = append(got, fi.Name())
+		}
+		if !equalStrings(got, test.want) {
+			t.Errorf("ReadDir %q: got %q, want %q", test.dir, got, test.want)
+		}
+	}
+
+	if _, err := z.ReadDir(ctx, "missing"); !os.IsNotExist(err) {
+		t.Errorf("ReadDir %q: got error %v, want not-exist", "missing", err)
+	}
+	if _, err := z.ReadDir(ctx, "top.txt"); !errors.Is(err, ErrNotDir) {
+		t.Errorf("ReadDir %q: got error %v, want %v", "top.txt", err, ErrNotDir)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, s := range a {
+		if b[i] != s {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSub(t *testing.T) {
+	ctx := context.Background()
+	z := openArchive(t, "root/", "root/files/a.txt", "root/files/b/c.txt", "root/units/u", "other.txt")
+
+	sub, err := z.Sub("root/files")
+	if err != nil {
+		t.Fatalf("Sub: unexpected error: %v", err)
+	}
+	if fi, err := sub.Stat(ctx, "."); err != nil || !fi.IsDir() {
+		t.Errorf("Stat %q: got (%v, %v), want a directory", ".", fi, err)
+	}
+	if _, err := sub.Stat(ctx, "a.txt"); err != nil {
+		t.Errorf("Stat %q: unexpected error: %v", "a.txt", err)
+	}
+	for _, path := range []string{"other.txt", "root/files/a.txt", "../units/u"} {
+		if _, err := sub.Open(ctx, path); err == nil {
+			t.Errorf("Open %q: got nil error, want failure", path)
+		}
+	}
+	if got, err := sub.Glob(ctx, "*"); err != nil {
+		t.Errorf("Glob: unexpected error: %v", err)
+	} else if want := []string{"a.txt"}; !equalStrings(got, want) {
+		t.Errorf("Glob: got %q, want %q", got, want)
+	}
+	if got, err := sub.Glob(ctx, "b/*"); err != nil {
+		t.Errorf("Glob: unexpected error: %v", err)
+	} else if want := []string{"b/c.txt"}; !equalStrings(got, want) {
+		t.Errorf("Glob: got %q, want %q", got, want)
+	}
+
+	if _, err := z.Sub("other.txt"); !errors.Is(err, ErrNotDir) {
+		t.Errorf("Sub %q: got error %v, want %v", "other.txt", err, ErrNotDir)
+	}
+	if _, err := z.Sub("missing"); !os.IsNotExist(err) {
+		t.Errorf("Sub %q: got error %v, want not-exist", "missing", err)
+	}
+}
+
+func TestUnion(t *testing.T) {
+	ctx := context.Background()
+	second, err := Open(bytes.NewReader(newArchiveEntries(t,
+		entry{"shared.txt", "second"},
+		entry{"src/b.txt", "b"},
+	)))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	u := Union(openArchive(t, "shared.txt", "lib/a.txt"), second)
+
+	for _, path := range []string{"shared.txt", "lib/a.txt", "src/b.txt"} {
+		if _, err := u.Stat(ctx, path); err != nil {
+			t.Errorf("Stat %q: unexpected error: %v", path, err)
+		}
+	}
+	if _, err := u.Stat(ctx, "missing"); err == nil {
+		t.Errorf("Stat %q: got nil error, want failure", "missing")
+	}
+	if _, err := u.Open(ctx, "missing"); err != os.ErrNotExist {
+		t.Errorf("Open %q: got error %v, want %v", "missing", err, os.ErrNotExist)
+	}
+
+	// The reader for a shared path should come from the first archive.
+	rc, err := u.Open(ctx, "shared.txt")
+	if err != nil {
+		t.Fatalf("Open %q: unexpected error: %v", "shared.txt", err)
+	}
+	defer rc.Close()
+	if data, err := ioutil.ReadAll(rc); err != nil {
+		t.Errorf("Read %q: unexpected error: %v", "shared.txt", err)
+	} else if got, want := string(data), "contents of shared.txt"; got != want {
+		t.Errorf("Read %q: got %q, want %q", "shared.txt", got, want)
+	}
+
+	got, err := u.Glob(ctx, "*.txt")
+	if err != nil {
+		t.Fatalf("Glob: unexpected error: %v", err)
+	}
+	if want := []string{"shared.txt"}; !equalStrings(got, want) {
+		t.Errorf("Glob: got %q, want %q", got, want)
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "ziptest")
+	if err != nil {
+		t.Fatalf("TempFile: %v", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(newArchive(t, "a.txt")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	z, c, err := OpenFile(f.Name())
+	if err != nil {
+		t.Fatalf("OpenFile %q: unexpected error: %v", f.Name(), err)
+	}
+	if _, err := z.Stat(context.Background(), "a.txt"); err != nil {
+		t.Errorf("Stat %q: unexpected error: %v", "a.txt", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("Close: unexpected error: %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("Close again: unexpected error: %v", err)
+	}
+
+	if _, _, err := OpenFile(f.Name() + ".missing"); !os.IsNotExist(err) {
+		t.Errorf("OpenFile: got error %v, want not-exist", err)
+	}
+}
+
+func TestOpenAt(t *testing.T) {
+	data := newArchive(t, "a.txt")
+	z, err := OpenAt(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("OpenAt: unexpected error: %v", err)
+	}
+	if _, err := z.Stat(context.Background(), "a.txt"); err != nil {
+		t.Errorf("Stat %q: unexpected error: %v", "a.txt", err)
+	}
+	if _, err := OpenAt(bytes.NewReader(data), int64(len(data)-1)); err == nil {
+		t.Error("OpenAt with a truncated size: got nil error, want failure")
+	}
+}
+
+func TestStatDirectory(t *testing.T) {
+	// Directory names are stored with a trailing "/" on every platform; this
+	// guards against using the host separator, e.g., when GOOS=windows.
+	z := openArchive(t, "dir/", "dir/sub/", "dir/sub/file.txt")
+	for _, path := range []string{"dir", "dir/sub"} {
+		fi, err := z.Stat(context.Background(), path)
+		if err != nil {
+			t.Errorf("Stat %q: unexpected error: %v", path, err)
+		} else if !fi.IsDir() {
+			t.Errorf("Stat %q: got mode %v, want a directory", path, fi.Mode())
+		}
+	}
+}
+
+func TestGlobSeparators(t *testing.T) {
+	ctx := context.Background()
+	z := openArchive(t, "src/a.go", "src/b.txt", "src/sub/c.go")
+
+	tests := []struct {
+		glob string
+		want []string
+	}{
+		{"src/*.go", []string{"src/a.go"}},
+		{"src/*/*.go", []string{"src/sub/c.go"}},
+		{"*/*.go", []string{"src/a.go"}},
+
+		// A backslash is an escape character, not a separator, on every OS.
+		{`src\*.go`, nil},
+		{`src\sub\c.go`, nil},
+	}
+	for _, test := range tests {
+		got, err := z.Glob(ctx, test.glob)
+		if err != nil {
+			t.Errorf("Glob %q: unexpected error: %v", test.glob, err)
+		} else if !equalStrings(got, test.want) {
+			t.Errorf("Glob %q: got %q, want %q", test.glob, got, test.want)
+		}
+	}
+}
+
+func BenchmarkStat(b *testing.B) {
+	const numFiles = 10000
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	names := make([]string, numFiles)
+	for i := range names {
+		names[i] = fmt.Sprintf("root/files/%05d", i)
+		if _, err := w.Create(names[i]); err != nil {
+			b.Fatalf("Create %q: %v", names[i], err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		b.Fatalf("Close: %v", err)
+	}
+	z, err := Open(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		b.Fatalf("Open: %v", err)
+	}
+
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := z.Stat(ctx, names[i%numFiles]); err != nil {
+			b.Fatalf("Stat: %v", err)
+		}
+	}
+}
+
+func TestGlobDoubleStar(t *testing.T) {
+	ctx := context.Background()
+	z := openArchive(t, "a.go", "kythe/b.go", "kythe/go/c.go", "kythe/go/d/e.go", "kythe/go/d/f.txt", "x/kythe/g.go")
+
+	tests := []struct {
+		glob string
+		want []string
+	}{
+		{"kythe/**/*.go", []string{"kythe/b.go", "kythe/go/c.go", "kythe/go/d/e.go"}},
+		{"**/*.go", []string{"a.go", "kythe/b.go", "kythe/go/c.go", "kythe/go/d/e.go", "x/kythe/g.go"}},
+		{"**/kythe/*.go", []string{"kythe/b.go", "x/kythe/g.go"}},
+		{"kythe/go/**", []string{"kythe/go/c.go", "kythe/go/d/e.go", "kythe/go/d/f.txt"}},
+		{"kythe/**/**/e.go", []string{"kythe/go/d/e.go"}},
+		{"kythe/**/d/*", []string{"kythe/go/d/e.go", "kythe/go/d/f.txt"}},
+		{"**/missing", nil},
+	}
+	for _, test := range tests {
+		got, err := z.Glob(ctx, test.glob)
+		if err != nil {
+			t.Errorf("Glob %q: unexpected error: %v", test.glob, err)
+		} else if !equalStrings(got, test.want) {
+			t.Errorf("Glob %q: got %q, want %q", test.glob, got, test.want)
+		}
+	}
+}
+
+type countingCloser struct {
+	*bytes.Reader
+	closed int
+}
+
+func (c *countingCloser) Close() error { c.closed++; return nil }
+
+func TestClose(t *testing.T) {
+	data := newArchive(t, "a.txt")
+	src := &countingCloser{Reader: bytes.NewReader(data)}
+	z, err := OpenAt(src, int64(len(data)))
+	if err != nil {
+		t.Fatalf("OpenAt: unexpected error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := z.Close(); err != nil {
+			t.Errorf("Close: unexpected error: %v", err)
+		}
+	}
+	if src.closed != 1 {
+		t.Errorf("Source closed %d times, want 1", src.closed)
+	}
+
+	// An archive whose source is not an io.Closer closes trivially.
+	if err := openArchive(t, "a.txt").Close(); err != nil {
+		t.Errorf("Close: unexpected error: %v", err)
+	}
+}
+
+func TestClone(t *testing.T) {
+	ctx := context.Background()
+	data := newArchive(t, "a.txt")
+	src := &countingCloser{Reader: bytes.NewReader(data)}
+	z, err := OpenAt(src, int64(len(data)))
+	if err != nil {
+		t.Fatalf("OpenAt: unexpected error: %v", err)
+	}
+	c1, c2 := z.Clone(), z.Clone()
+	for _, fs := range []FS{z, c1, c1} {
+		if err := fs.Close(); err != nil {
+			t.Errorf("Close: unexpected error: %v", err)
+		}
+	}
+	if src.closed != 0 {
+		t.Errorf("Source closed %d times with a clone open, want 0", src.closed)
+	}
+	if got, err := c2.ReadFile(ctx, "a.txt"); err != nil || string(got) != "contents of a.txt" {
+		t.Errorf("ReadFile from clone: got %q, %v; want %q", got, err, "contents of a.txt")
+	}
+	if err := c2.Close(); err != nil {
+		t.Errorf("Close: unexpected error: %v", err)
+	}
+	if src.closed != 1 {
+		t.Errorf("Source closed %d times, want 1", src.closed)
+	}
+
+	// Cloning after the source is closed does not close it again.
+	if err := z.Clone().Close(); err != nil {
+		t.Errorf("Close of late clone: unexpected error: %v", err)
+	}
+	if src.closed != 1 {
+		t.Errorf("Source closed %d times, want 1", src.closed)
+	}
+}
+
+func TestCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	z := openArchive(t, "a.txt", "b/c.txt")
+	if _, err := z.Stat(ctx, "a.txt"); err != context.Canceled {
+		t.Errorf("Stat: got error %v, want %v", err, context.Canceled)
+	}
+	if _, err := z.Open(ctx, "a.txt"); err != context.Canceled {
+		t.Errorf("Open: got error %v, want %v", err, context.Canceled)
+	}
+	if _, err := z.Glob(ctx, "*"); err != context.Canceled {
+		t.Errorf("Glob: got error %v, want %v", err, context.Canceled)
+	}
+	if _, err := z.ReadDir(ctx, "b"); err != context.Canceled {
+		t.Errorf("ReadDir: got error %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestNotExist(t *testing.T) {
+	ctx := context.Background()
+	z := openArchive(t, "a.txt")
+	if _, err := z.Stat(ctx, "missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat %q: got error %v, want %v", "missing", err, os.ErrNotExist)
+	}
+	if _, err := z.Open(ctx, "missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Open %q: got error %v, want %v", "missing", err, os.ErrNotExist)
+	}
+}
+
+func TestImplicitDirectories(t *testing.T) {
+	ctx := context.Background()
+	z := openArchive(t, "a/b/c.txt", "a/d.txt")
+	for _, path := range []string{"a", "a/", "a/b"} {
+		fi, err := z.Stat(ctx, path)
+		if err != nil {
+			t.Errorf("Stat %q: unexpected error: %v", path, err)
+			continue
+		}
+		if !fi.IsDir() || fi.Mode() != os.ModeDir|0555 || fi.Size() != 0 {
+			t.Errorf("Stat %q: got mode %v size %d, want a directory", path, fi.Mode(), fi.Size())
+		}
+		if fi.ModTime().IsZero() {
+			t.Errorf("Stat %q: missing modification time", path)
+		}
+	}
+	if fi, err := z.Stat(ctx, "a/b"); err == nil && fi.Name() != "b" {
+		t.Errorf("Stat %q: got name %q, want %q", "a/b", fi.Name(), "b")
+	}
+	if _, err := z.Stat(ctx, "a/b/c"); !os.IsNotExist(err) {
+		t.Errorf("Stat %q: got error %v, want not-exist", "a/b/c", err)
+	}
+
+	var walked []string
+	if err := fs.WalkDir(z.StdFS(), ".", func(path string, _ fs.DirEntry, err error) error {
+		walked = append(walked, path)
+		return err
+	}); err != nil {
+		t.Errorf("WalkDir: unexpected error: %v", err)
+	}
+	if want := []string{".", "a", "a/b", "a/b/c.txt", "a/d.txt"}; !equalStrings(walked, want) {
+		t.Errorf("WalkDir: got %q, want %q", walked, want)
+	}
+}
+
+func TestCleanPaths(t *testing.T) {
+	ctx := context.Background()
+	z := openArchive(t, "a/", "a/b.txt")
+
+	for _, path := range []string{"a/b.txt", "./a/b.txt", "a//b.txt", "a/./b.txt", "a/b.txt/", "a/../a/b.txt"} {
+		rc, err := z.Open(ctx, path)
+		if err != nil {
+			t.Errorf("Open %q: unexpected error: %v", path, err)
+			continue
+		}
+		rc.Close()
+		if fi, err := z.Stat(ctx, path); err != nil {
+			t.Errorf("Stat %q: unexpected error: %v", path, err)
+		} else if fi.Name() != "b.txt" {
+			t.Errorf("Stat %q: got name %q, want %q", path, fi.Name(), "b.txt")
+		}
+	}
+	for _, path := range []string{"./a", "a//", "./a/"} {
+		if fi, err := z.Stat(ctx, path); err != nil || !fi.IsDir() {
+			t.Errorf("Stat %q: got (%v, %v), want a directory", path, fi, err)
+		}
+	}
+	for _, path := range []string{"..", "../a/b.txt", "a/../../a/b.txt"} {
+		if _, err := z.Open(ctx, path); !errors.Is(err, os.ErrInvalid) {
+			t.Errorf("Open %q: got error %v, want %v", path, err, os.ErrInvalid)
+		}
+		if _, err := z.Stat(ctx, path); !errors.Is(err, os.ErrInvalid) {
+			t.Errorf("Stat %q: got error %v, want %v", path, err, os.ErrInvalid)
+		}
+	}
+}
+
+func TestGlobBadPattern(t *testing.T) {
+	ctx := context.Background()
+	for _, z := range []FS{openArchive(t, "a.txt", "b/c.txt"), openArchive(t, "b/")} {
+		for _, glob := range []string{"[", "a[", "b/[-]", "**/["} {
+			if got, err := z.Glob(ctx, glob); err != path.ErrBadPattern {
+				t.Errorf("Glob %q: got (%q, %v), want error %v", glob, got, err, path.ErrBadPattern)
+			}
+		}
+	}
+}
+
+type testLogger []string
+
+func (t *testLogger) Printf(format string, args ...interface{}) {
+	*t = append(*t, fmt.Sprintf(format, args...))
+}
+
+func TestLogTo(t *testing.T) {
+	var log testLogger
+	data := newArchive(t, "dup.txt", "dup.txt", "other.txt")
+	z, err := Open(bytes.NewReader(data), LogTo(&log))
+	if err != nil {
+		t.Fatalf("Open: unexpected error: %v", err)
+	}
+	if _, err := z.Stat(context.Background(), "dup.txt"); err != nil {
+		t.Errorf("Stat %q: unexpected error: %v", "dup.txt", err)
+	}
+	if want := []string{`zip: ignoring duplicate entry "dup.txt"`}; !equalStrings(log, want) {
+		t.Errorf("Log messages: got %q, want %q", log, want)
+	}
+
+	if _, err := Open(bytes.NewReader(data), LogTo(nil)); err == nil {
+		t.Error("Open with a nil Logger: got nil error, want failure")
+	}
+}
+
+func TestComments(t *testing.T) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	if _, err := w.CreateHeader(&zip.FileHeader{Name: "a.txt", Comment: "entry comment"}); err != nil {
+		t.Fatalf("CreateHeader: %v", err)
+	}
+	if err := w.SetComment("archive comment"); err != nil {
+		t.Fatalf("SetComment: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	z, err := Open(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	if got, want := z.Comment(), "archive comment"; got != want {
+		t.Errorf("Comment: got %q, want %q", got, want)
+	}
+	if got, err := z.EntryComment("a.txt"); err != nil {
+		t.Errorf("EntryComment %q: unexpected error: %v", "a.txt", err)
+	} else if want := "entry comment"; got != want {
+		t.Errorf("EntryComment %q: got %q, want %q", "a.txt", got, want)
+	}
+	if _, err := z.EntryComment("missing"); !os.IsNotExist(err) {
+		t.Errorf("EntryComment %q: got error %v, want not-exist", "missing", err)
+	}
+}
+
+// newBadChecksumArchive returns an archive of uncompressed entries, two of which
+// have incorrect checksums.  One of those is zero, which the archive/zip
+// package itself does not check.
+func newBadChecksumArchive(t *testing.T) []byte {
+	const contents = "some file contents"
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, fh := range []*zip.FileHeader{
+		{Name: "zero.txt", CRC32: 0},
+		{Name: "wrong.txt", CRC32: 12345},
+		{Name: "right.txt", CRC32: crc32.ChecksumIEEE([]byte(contents))},
+	} {
+		fh.Method = zip.Store
+		fh.CompressedSize64 = uint64(len(contents))
+		fh.UncompressedSize64 = uint64(len(contents))
+		f, err := w.CreateRaw(fh)
+		if err != nil {
+			t.Fatalf("CreateRaw %q: %v", fh.Name, err)
+		}
+		if _, err := f.Write([]byte(contents)); err != nil {
+			t.Fatalf("Write %q: %v", fh.Name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestVerifyChecksums(t *testing.T) {
+	ctx := context.Background()
+	data := newBadChecksumArchive(t)
+
+	readAll := func(z FS, path string) error {
+		rc, err := z.Open(ctx, path)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		_, err = ioutil.ReadAll(rc)
+		return err
+	}
+
+	plain, err := Open(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := readAll(plain, "zero.txt"); err != nil {
+		t.Errorf("Read %q without verification: unexpected error: %v", "zero.txt", err)
+	}
+
+	z, err := Open(bytes.NewReader(data), VerifyChecksums())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for _, path := range []string{"zero.txt", "wrong.txt"} {
+		err := readAll(z, path)
+		if !errors.Is(err, zip.ErrChecksum) {
+			t.Errorf("Read %q: got error %v, want %v", path, err, zip.ErrChecksum)
+		} else if !strings.Contains(err.Error(), path) {
+			t.Errorf("Read %q: error %q does not name the entry", path, err)
+		}
+	}
+	if err := readAll(z, "right.txt"); err != nil {
+		t.Errorf("Read %q: unexpected error: %v", "right.txt", err)
+	}
+}
+
+func TestValidateAll(t *testing.T) {
+	ctx := context.Background()
+	if err := openArchive(t, "a.txt", "b/", "b/c.txt").ValidateAll(ctx, 0); err != nil {
+		t.Errorf("ValidateAll: unexpected error: %v", err)
+	}
+
+	z, err := Open(bytes.NewReader(newBadChecksumArchive(t)))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	err = z.ValidateAll(ctx, 2)
+	verr, ok := err.(ValidationError)
+	if !ok {
+		t.Fatalf("ValidateAll: got error %v, want a ValidationError", err)
+	}
+	var failed []string
+	for _, e := range verr {
+		failed = append(failed, e.Name)
+		if !errors.Is(e, zip.ErrChecksum) {
+			t.Errorf("ValidateAll %q: got error %v, want %v", e.Name, e.Err, zip.ErrChecksum)
+		}
+	}
+	if want := []string{"wrong.txt", "zero.txt"}; !equalStrings(failed, want) {
+		t.Errorf("ValidateAll: got failures %q, want %q", failed, want)
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := z.ValidateAll(cctx, 1); err != context.Canceled {
+		t.Errorf("ValidateAll: got error %v, want %v", err, context.Canceled)
+	}
+}
+
+// cancelingReaderAt cancels a context once reads are counted and more than
+// after of them have been made.
+type cancelingReaderAt struct {
+	r      io.ReaderAt
+	cancel func()
+	mu     sync.Mutex
+	count  bool
+	after  int
+	reads  int
+}
+
+func (c *cancelingReaderAt) ReadAt(buf []byte, off int64) (int, error) {
+	c.mu.Lock()
+	if c.count {
+		if c.reads++; c.reads > c.after {
+			c.cancel()
+		}
+	}
+	c.mu.Unlock()
+	return c.r.ReadAt(buf, off)
+}
+
+func TestValidateAllCancelMidEntry(t *testing.T) {
+	data := make([]byte, 4<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	archive := newArchiveEntries(t, entry{"big.bin", string(data)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src := &cancelingReaderAt{r: bytes.NewReader(archive), cancel: cancel, after: 2}
+	z, err := OpenAt(src, int64(len(archive)))
+	if err != nil {
+		t.Fatalf("OpenAt: %v", err)
+	}
+	src.count = true
+	if err := z.ValidateAll(ctx, 1); err != context.Canceled {
+		t.Errorf("ValidateAll: got error %v, want %v", err, context.Canceled)
+	}
+	// The entry is not read to its end once the context is cancelled.
+	if src.reads > 10 {
+		t.Errorf("ValidateAll: made %d reads after cancellation, want few", src.reads-src.after)
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "ziptest")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "patched.txt"), []byte("patched"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	lower, err := Open(bytes.NewReader(newArchiveEntries(t,
+		entry{"patched.txt", "original"},
+		entry{"plain.txt", "plain"},
+	)))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	// Root the local files at dir, so that paths in both layers agree.
+	o := Overlay(prefixReader{dir}, lower)
+	for path, want := range map[string]string{"patched.txt": "patched", "plain.txt": "plain"} {
+		rc, err := o.Open(ctx, path)
+		if err != nil {
+			t.Errorf("Open %q: unexpected error: %v", path, err)
+			continue
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Errorf("Read %q: unexpected error: %v", path, err)
+		} else if string(data) != want {
+			t.Errorf("Read %q: got %q, want %q", path, data, want)
+		}
+	}
+	if _, err := o.Stat(ctx, "missing"); !os.IsNotExist(err) {
+		t.Errorf("Stat %q: got error %v, want not-exist", "missing", err)
+	}
+	if got, err := o.Glob(ctx, "*.txt"); err != nil {
+		t.Errorf("Glob: unexpected error: %v", err)
+	} else if want := []string{"patched.txt", "plain.txt"}; !equalStrings(got, want) {
+		t.Errorf("Glob: got %q, want %q", got, want)
+	}
+}
+
+// prefixReader is a vfs.Reader for the local files beneath dir.
+type prefixReader struct{ dir string }
+
+func (p prefixReader) Stat(ctx context.Context, path string) (os.FileInfo, error) {
+	return vfs.LocalFS{}.Stat(ctx, filepath.Join(p.dir, path))
+}
+
+func (p prefixReader) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	return vfs.LocalFS{}.Open(ctx, filepath.Join(p.dir, path))
+}
+
+func (p prefixReader) Glob(ctx context.Context, glob string) ([]string, error) {
+	matches, err := vfs.LocalFS{}.Glob(ctx, filepath.Join(p.dir, glob))
+	for i, m := range matches {
+		matches[i], _ = filepath.Rel(p.dir, m)
+	}
+	return matches, err
+}
+
+func TestOpenRaw(t *testing.T) {
+	src := openArchive(t, "a.txt", "b/c.txt")
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, path := range []string{"a.txt", "b/c.txt"} {
+		r, fh, err := src.OpenRaw(path)
+		if err != nil {
+			t.Fatalf("OpenRaw %q: unexpected error: %v", path, err)
+		}
+		if fh.Method != zip.Deflate {
+			t.Errorf("OpenRaw %q: got method %d, want %d", path, fh.Method, zip.Deflate)
+		}
+		fw, err := w.CreateRaw(fh)
+		if err != nil {
+			t.Fatalf("CreateRaw %q: %v", path, err)
+		}
+		if _, err := io.Copy(fw, r); err != nil {
+			t.Fatalf("Copy %q: %v", path, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	dst, err := Open(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := dst.ValidateAll(context.Background(), 1); err != nil {
+		t.Errorf("ValidateAll: unexpected error: %v", err)
+	}
+	if _, _, err := src.OpenRaw("missing"); !os.IsNotExist(err) {
+		t.Errorf("OpenRaw %q: got error %v, want not-exist", "missing", err)
+	}
+
+	// Entries of nested archives are read from the nested archive.
+	z, err := OpenBytes(newArchiveEntries(t, entry{"lib.zip", string(newArchive(t, "src/main.go"))}))
+	if err != nil {
+		t.Fatalf("OpenBytes: %v", err)
+	}
+	r, fh, err := z.OpenRaw("lib.zip!/src/main.go")
+	if err != nil {
+		t.Fatalf("OpenRaw %q: unexpected error: %v", "lib.zip!/src/main.go", err)
+	}
+	raw, err := ioutil.ReadAll(r)
+	if err != nil || fh.Name != "src/main.go" || uint64(len(raw)) != fh.CompressedSize64 {
+		t.Errorf("OpenRaw %q: got %d bytes of %q, %v; want %d bytes of %q", "lib.zip!/src/main.go", len(raw), fh.Name, err, fh.CompressedSize64, "src/main.go")
+	}
+	if _, err := z.DataOffset("lib.zip!/src/main.go"); !errors.Is(err, ErrNested) {
+		t.Errorf("DataOffset %q: got error %v, want %v", "lib.zip!/src/main.go", err, ErrNested)
+	}
+}
+
+func TestMaxUncompressedBytes(t *testing.T) {
+	ctx := context.Background()
+	data := newArchiveEntries(t,
+		entry{"small.txt", "small"},
+		entry{"large.txt", strings.Repeat("x", 1000)},
+	)
+	z, err := Open(bytes.NewReader(data), MaxUncompressedBytes(100))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, err := z.Open(ctx, "large.txt"); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Open %q: got error %v, want %v", "large.txt", err, ErrTooLarge)
+	}
+	rc, err := z.Open(ctx, "small.txt")
+	if err != nil {
+		t.Fatalf("Open %q: unexpected error: %v", "small.txt", err)
+	}
+	rc.Close()
+
+	// The limit applies to the bytes actually read, whatever the header says.
+	lr := &limitedReader{rc: ioutil.NopCloser(strings.NewReader(strings.Repeat("x", 1000))), max: 100, left: 100}
+	if n, err := io.Copy(ioutil.Discard, lr); !errors.Is(err, ErrTooLarge) || n != 100 {
+		t.Errorf("Read past the limit: got (%d, %v), want (100, %v)", n, err, ErrTooLarge)
+	}
+	lr = &limitedReader{rc: ioutil.NopCloser(strings.NewReader(strings.Repeat("x", 100))), max: 100, left: 100}
+	if n, err := io.Copy(ioutil.Discard, lr); err != nil || n != 100 {
+		t.Errorf("Read up to the limit: got (%d, %v), want (100, nil)", n, err)
+	}
+
+	if _, err := Open(bytes.NewReader(data), MaxEntries(1)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Open with MaxEntries(1): got error %v, want %v", err, ErrTooLarge)
+	}
+}
+
+func TestUnsafeNames(t *testing.T) {
+	ctx := context.Background()
+	data := newArchive(t, "good.txt", "../evil.txt", "a/../../evil.txt", "/etc/passwd")
+	z, err := Open(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if got, err := z.Glob(ctx, "**"); err != nil {
+		t.Errorf("Glob: unexpected error: %v", err)
+	} else if want := []string{"good.txt"}; !equalStrings(got, want) {
+		t.Errorf("Glob: got %q, want %q", got, want)
+	}
+	if _, err := z.Stat(ctx, "etc/passwd"); !os.IsNotExist(err) {
+		t.Errorf("Stat %q: got error %v, want not-exist", "etc/passwd", err)
+	}
+	if _, err := z.Open(ctx, "/etc/passwd"); err == nil {
+		t.Errorf("Open %q: got nil error, want failure", "/etc/passwd")
+	}
+
+	if _, err := Open(bytes.NewReader(data), RejectUnsafeNames()); !errors.Is(err, ErrUnsafePath) {
+		t.Errorf("Open with RejectUnsafeNames: got error %v, want %v", err, ErrUnsafePath)
+	}
+}
+
+func TestNestedArchives(t *testing.T) {
+	ctx := context.Background()
+	inner := newArchive(t, "src/main.go", "README")
+	z, err := Open(bytes.NewReader(newArchiveEntries(t,
+		entry{"vendor/lib.zip", string(inner)},
+		entry{"odd!/name", "not nested"},
+	)))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	const path = "vendor/lib.zip!/src/main.go"
+	for i := 0; i < 2; i++ {
+		rc, err := z.Open(ctx, path)
+		if err != nil {
+			t.Fatalf("Open %q: unexpected error: %v", path, err)
+		}
+		got, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Errorf("Read %q: unexpected error: %v", path, err)
+		} else if want := "contents of src/main.go"; string(got) != want {
+			t.Errorf("Read %q: got %q, want %q", path, got, want)
+		}
+	}
+	if n := len(z.idx.nested); n != 1 {
+		t.Errorf("Got %d cached nested archives, want 1", n)
+	}
+
+	if fi, err := z.Stat(ctx, "vendor/lib.zip!/src"); err != nil {
+		t.Errorf("Stat: unexpected error: %v", err)
+	} else if !fi.IsDir() {
+		t.Errorf("Stat: got mode %v, want a directory", fi.Mode())
+	}
+	if infos, err := z.ReadDir(ctx, "vendor/lib.zip!/"); err != nil {
+		t.Errorf("ReadDir: unexpected error: %v", err)
+	} else if n := len(infos); n != 2 {
+		t.Errorf("ReadDir: got %d entries, want 2", n)
+	}
+	if _, err := z.Open(ctx, "vendor/lib.zip!/missing"); err != os.ErrNotExist {
+		t.Errorf("Open missing: got error %v, want %v", err, os.ErrNotExist)
+	}
+	if _, err := z.Stat(ctx, "odd!/name"); err != nil {
+		t.Errorf("Stat %q: unexpected error: %v", "odd!/name", err)
+	}
+
+	// Concurrent lookups read a nested archive once, and its size is limited
+	// as that of any other entry.
+	z, err = Open(bytes.NewReader(newArchiveEntries(t, entry{"vendor/lib.zip", string(inner)})))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := z.Stat(ctx, path); err != nil {
+				t.Errorf("Stat %q: unexpected error: %v", path, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := len(z.idx.nested); n != 1 {
+		t.Errorf("Got %d cached nested archives, want 1", n)
+	}
+	z, err = Open(bytes.NewReader(newArchiveEntries(t, entry{"vendor/lib.zip", string(inner)})), MaxUncompressedBytes(int64(len(inner)-1)))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, err := z.Stat(ctx, path); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Stat %q with MaxUncompressedBytes: got error %v, want %v", path, err, ErrTooLarge)
+	}
+}
+
+func TestNestingDepth(t *testing.T) {
+	ctx := context.Background()
+	data := newArchive(t, "leaf.txt")
+	path := "leaf.txt"
+	for i := 0; i < maxNesting; i++ {
+		data = newArchiveEntries(t, entry{"nest.zip", string(data)})
+		path = "nest.zip!/" + path
+	}
+	z, err := Open(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, err := z.Stat(ctx, path); err != nil {
+		t.Errorf("Stat at depth %d: unexpected error: %v", maxNesting, err)
+	}
+
+	data = newArchiveEntries(t, entry{"nest.zip", string(data)})
+	path = "nest.zip!/" + path
+	if z, err = Open(bytes.NewReader(data)); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, err := z.Stat(ctx, path); !errors.Is(err, ErrNestingDepth) {
+		t.Errorf("Stat at depth %d: got error %v, want %v", maxNesting+1, err, ErrNestingDepth)
+	}
+}
+
+func TestOpenReaderAt(t *testing.T) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, fh := range []*zip.FileHeader{
+		{Name: "stored.bin", Method: zip.Store},
+		{Name: "deflated.bin", Method: zip.Deflate},
+	} {
+		f, err := w.CreateHeader(fh)
+		if err != nil {
+			t.Fatalf("CreateHeader %q: %v", fh.Name, err)
+		}
+		if _, err := io.WriteString(f, "0123456789abcdef"); err != nil {
+			t.Fatalf("Write %q: %v", fh.Name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	z, err := Open(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	for _, name := range []string{"stored.bin", "deflated.bin"} {
+		r, size, err := z.OpenReaderAt(name)
+		if err != nil {
+			t.Errorf("OpenReaderAt %q: unexpected error: %v", name, err)
+			continue
+		}
+		if size != 16 {
+			t.Errorf("OpenReaderAt %q: got size %d, want 16", name, size)
+		}
+		got := make([]byte, 4)
+		if _, err := r.ReadAt(got, 10); err != nil {
+			t.Errorf("ReadAt %q: unexpected error: %v", name, err)
+		} else if want := "abcd"; string(got) != want {
+			t.Errorf("ReadAt %q: got %q, want %q", name, got, want)
+		}
+		_, direct := r.(*io.SectionReader)
+		if want := name == "stored.bin"; direct != want {
+			t.Errorf("OpenReaderAt %q: got direct %v, want %v", name, direct, want)
+		}
+	}
+	if _, _, err := z.OpenReaderAt("missing.bin"); !os.IsNotExist(err) {
+		t.Errorf("OpenReaderAt missing: got error %v, want not-exist", err)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	ctx := context.Background()
+	z := openArchive(t, "b/z.txt", "a/skip/x.txt", "a/y.txt", "a/b/c.txt", "top.txt", "b/")
+
+	var got []string
+	err := z.Walk(ctx, ".", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			t.Errorf("Walk %q: unexpected error: %v", path, err)
+			return err
+		}
+		if info.IsDir() {
+			got = append(got, path+"/")
+		} else {
+			got = append(got, path)
+		}
+		if path == "a/skip" {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Walk: unexpected error: %v", err)
+	}
+	want := []string{"./", "a/", "a/b/", "a/b/c.txt", "a/skip/", "a/y.txt", "b/", "b/z.txt", "top.txt"}
+	if !equalStrings(got, want) {
+		t.Errorf("Walk: got %q, want %q", got, want)
+	}
+
+	// Skipping a file skips the rest of its directory.
+	got = nil
+	z.Walk(ctx, "a", func(path string, info os.FileInfo, err error) error {
+		got = append(got, path)
+		if path == "a/b/c.txt" {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if want := []string{"a", "a/b", "a/b/c.txt", "a/skip", "a/skip/x.txt", "a/y.txt"}; !equalStrings(got, want) {
+		t.Errorf("Walk a: got %q, want %q", got, want)
+	}
+
+	var missing error
+	z.Walk(ctx, "nonesuch", func(path string, info os.FileInfo, err error) error {
+		missing = err
+		return nil
+	})
+	if !os.IsNotExist(missing) {
+		t.Errorf("Walk nonesuch: got error %v, want not-exist", missing)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := z.Walk(cancelled, ".", func(string, os.FileInfo, error) error { return nil }); err != context.Canceled {
+		t.Errorf("Walk cancelled: got error %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestGlobSorted(t *testing.T) {
+	ctx := context.Background()
+	names := []string{"b/2.txt", "a.txt", "c/1.txt", "b/1.txt", "B.txt"}
+	want := []string{"B.txt", "a.txt", "b/1.txt", "b/2.txt", "c/1.txt"}
+	for i := range names {
+		// Rotate the order in which the entries are written.
+		order := append(append([]string(nil), names[i:]...), names[:i]...)
+		z := openArchive(t, order...)
+		if got, err := z.Glob(ctx, "**"); err != nil {
+			t.Errorf("Glob %q: unexpected error: %v", order, err)
+		} else if !equalStrings(got, want) {
+			t.Errorf("Glob %q: got %q, want %q", order, got, want)
+		}
+	}
+}
+
+func TestEntries(t *testing.T) {
+	z := openArchive(t, "a/", "a/b.txt", "c.txt")
+	sub, err := z.Sub("a")
+	if err != nil {
+		t.Fatalf("Sub: %v", err)
+	}
+	for _, test := range []struct {
+		z    FS
+		want []string
+	}{
+		{z, []string{"a/", "a/b.txt", "c.txt"}},
+		{sub, []string{"b.txt"}},
+	} {
+		var got []string
+		for _, e := range test.z.Entries() {
+			got = append(got, e.Name)
+			if f := test.z.find(strings.TrimSuffix(e.Name, "/")); f == nil {
+				t.Errorf("Entries: got unknown entry %q", e.Name)
+			} else if e.Size != int64(f.UncompressedSize64) || e.CRC32 != f.CRC32 || e.Method != f.Method {
+				t.Errorf("Entries: got %+v, which does not match the header of %q", e, f.Name)
+			}
+		}
+		if !equalStrings(got, test.want) {
+			t.Errorf("Entries: got %q, want %q", got, test.want)
+		}
+	}
+}
+
+func TestWriteManifest(t *testing.T) {
+	modified := time.Date(2015, 6, 1, 12, 0, 0, 0, time.FixedZone("EST", -5*3600))
+	manifest := func(names ...string) string {
+		var buf bytes.Buffer
+		w := zip.NewWriter(&buf)
+		for _, name := range names {
+			f, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+			if err != nil {
+				t.Fatalf("CreateHeader %q: %v", name, err)
+			}
+			io.WriteString(f, "contents of "+name)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		z, err := Open(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		var out bytes.Buffer
+		if err := z.WriteManifest(&out); err != nil {
+			t.Fatalf("WriteManifest: %v", err)
+		}
+		return out.String()
+	}
+
+	got := manifest("b.txt", "a.txt")
+	if other := manifest("a.txt", "b.txt"); got != other {
+		t.Errorf("WriteManifest depends on entry order:\n%s\nvs.\n%s", got, other)
+	}
+	var records []struct {
+		Name     string `json:"name"`
+		Modified string `json:"modified"`
+	}
+	if err := json.Unmarshal([]byte(got), &records); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if len(records) != 2 || records[0].Name != "a.txt" || records[1].Name != "b.txt" {
+		t.Errorf("WriteManifest: got records %+v, want a.txt and b.txt", records)
+	}
+	for _, r := range records {
+		if want := "2015-06-01T17:00:00Z"; r.Modified != want {
+			t.Errorf("WriteManifest %q: got modified %q, want %q", r.Name, r.Modified, want)
+		}
+	}
+}
+
+func TestCopyTo(t *testing.T) {
+	ctx := context.Background()
+	src := openArchive(t, "a/", "a/b.txt", "a/c.go", "d/e/f.txt", "g.go")
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	err := CopyTo(ctx, src, w,
+		CopyFilter(func(name string) bool { return !strings.HasSuffix(name, ".go") }),
+		CopyRename(func(name string) string { return "copy/" + name }))
+	if err != nil {
+		t.Fatalf("CopyTo: unexpected error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	dst, err := Open(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	got, err := dst.Glob(ctx, "**")
+	if err != nil {
+		t.Fatalf("Glob: %v", err)
+	}
+	want := []string{"copy/", "copy/a/", "copy/a/b.txt", "copy/d/", "copy/d/e/", "copy/d/e/f.txt"}
+	if !equalStrings(got, want) {
+		t.Errorf("CopyTo: got entries %q, want %q", got, want)
+	}
+	rc, err := dst.Open(ctx, "copy/d/e/f.txt")
+	if err != nil {
+		t.Fatalf("Open: unexpected error: %v", err)
+	}
+	defer rc.Close()
+	if data, err := ioutil.ReadAll(rc); err != nil {
+		t.Errorf("Read: unexpected error: %v", err)
+	} else if want := "contents of d/e/f.txt"; string(data) != want {
+		t.Errorf("Read: got %q, want %q", data, want)
+	}
+}
+
+// newSymlinkArchive returns the bytes of an archive containing the given
+// regular files and the symbolic links described by links, which maps each
+// link to its target.
+func newSymlinkArchive(t *testing.T, files []string, links map[string]string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	add := func(name, data string, mode os.FileMode) {
+		fh := &zip.FileHeader{Name: name, Method: zip.Store}
+		fh.SetMode(mode)
+		f, err := w.CreateHeader(fh)
+		if err != nil {
+			t.Fatalf("CreateHeader %q: %v", name, err)
+		}
+		io.WriteString(f, data)
+	}
+	for _, name := range files {
+		add(name, "contents of "+name, 0644)
+	}
+	for link, target := range links {
+		add(link, target, os.ModeSymlink|0777)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestSymlinks(t *testing.T) {
+	ctx := context.Background()
+	data := newSymlinkArchive(t, []string{"dir/real.txt"}, map[string]string{
+		"dir/link":   "real.txt",
+		"top":        "dir/link",
+		"escape":     "../outside",
+		"absolute":   "/etc/passwd",
+		"loop":       "loop",
+		"dangling":   "nonesuch",
+		"dir/up.txt": "../dir/real.txt",
+	})
+
+	z, err := Open(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if fi, err := z.Stat(ctx, "top"); err != nil {
+		t.Errorf("Stat: unexpected error: %v", err)
+	} else if fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("Stat: got mode %v, want a symbolic link", fi.Mode())
+	}
+	if got, err := z.Readlink(ctx, "top"); err != nil || got != "dir/link" {
+		t.Errorf("Readlink: got %q, %v; want %q", got, err, "dir/link")
+	}
+	if _, err := z.Readlink(ctx, "dir/real.txt"); !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("Readlink of a file: got error %v, want %v", err, os.ErrInvalid)
+	}
+	if rc, err := z.Open(ctx, "top"); err != nil {
+		t.Errorf("Open without following: unexpected error: %v", err)
+	} else if got, _ := ioutil.ReadAll(rc); string(got) != "dir/link" {
+		t.Errorf("Open without following: got %q, want the link target", got)
+	}
+
+	z, err = Open(bytes.NewReader(data), FollowSymlinks())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for _, name := range []string{"top", "dir/link", "dir/up.txt"} {
+		rc, err := z.Open(ctx, name)
+		if err != nil {
+			t.Errorf("Open %q: unexpected error: %v", name, err)
+			continue
+		}
+		got, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		if want := "contents of dir/real.txt"; string(got) != want {
+			t.Errorf("Open %q: got %q, want %q", name, got, want)
+		}
+	}
+	for name, want := range map[string]error{
+		"escape":   os.ErrInvalid,
+		"absolute": os.ErrInvalid,
+		"loop":     errSymlinkLoop,
+		"dangling": os.ErrNotExist,
+	} {
+		if _, err := z.Open(ctx, name); !errors.Is(err, want) {
+			t.Errorf("Open %q: got error %v, want %v", name, err, want)
+		}
+	}
+}
+
+func TestModes(t *testing.T) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, mode := range map[string]os.FileMode{
+		"bin/":          os.ModeDir | 0750,
+		"bin/tool":      0755,
+		"bin/data.txt":  0640,
+		"dos/plain.txt": 0, // written without a Unix mode
+	} {
+		fh := &zip.FileHeader{Name: name, Method: zip.Deflate}
+		if mode != 0 {
+			fh.SetMode(mode) // records the mode as a Unix system does
+		}
+		if _, err := w.CreateHeader(fh); err != nil {
+			t.Fatalf("CreateHeader %q: %v", name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	z, err := Open(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	for name, want := range map[string]os.FileMode{
+		"bin":           os.ModeDir | 0750,
+		"bin/tool":      0755,
+		"bin/data.txt":  0640,
+		"dos":           os.ModeDir | 0555,
+		"dos/plain.txt": 0444,
+	} {
+		if got, err := z.Mode(name); err != nil {
+			t.Errorf("Mode %q: unexpected error: %v", name, err)
+		} else if got != want {
+			t.Errorf("Mode %q: got %v, want %v", name, got, want)
+		}
+	}
+	if fi, err := z.Stat(context.Background(), "bin/tool"); err != nil {
+		t.Errorf("Stat: unexpected error: %v", err)
+	} else if fi.Mode()&0111 == 0 {
+		t.Errorf("Stat: got mode %v, want executable", fi.Mode())
+	}
+	if _, err := z.Mode("nonesuch"); !os.IsNotExist(err) {
+		t.Errorf("Mode nonesuch: got error %v, want not-exist", err)
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	ctx := context.Background()
+	data := newArchiveEntries(t,
+		entry{"dup.txt", "first"},
+		entry{"other.txt", "other"},
+		entry{"dup.txt", "last"},
+	)
+	for _, test := range []struct {
+		opts []Option
+		want string
+	}{
+		{nil, "last"},
+		{[]Option{OnDuplicate(LastEntryWins)}, "last"},
+		{[]Option{OnDuplicate(FirstEntryWins)}, "first"},
+	} {
+		z, err := Open(bytes.NewReader(data), test.opts...)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		rc, err := z.Open(ctx, "dup.txt")
+		if err != nil {
+			t.Fatalf("Open: unexpected error: %v", err)
+		}
+		got, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil || string(got) != test.want {
+			t.Errorf("Read: got %q, %v; want %q", got, err, test.want)
+		}
+		if names, err := z.Glob(ctx, "*"); err != nil {
+			t.Errorf("Glob: unexpected error: %v", err)
+		} else if want := []string{"dup.txt", "other.txt"}; !equalStrings(names, want) {
+			t.Errorf("Glob: got %q, want %q", names, want)
+		}
+	}
+
+	if _, err := Open(bytes.NewReader(data), OnDuplicate(RejectDuplicates)); !errors.Is(err, ErrDuplicateEntry) {
+		t.Errorf("Open with RejectDuplicates: got error %v, want %v", err, ErrDuplicateEntry)
+	}
+	if _, err := Open(bytes.NewReader(data), OnDuplicate(RejectDuplicates+1)); err == nil {
+		t.Error("Open with an invalid policy: got nil error, want failure")
+	}
+}
+
+func TestCP437Names(t *testing.T) {
+	if n := len(cp437); n != 128 {
+		t.Fatalf("Code page 437 has %d high runes, want 128", n)
+	}
+	ctx := context.Background()
+	const raw = "caf\x82/na\xa4o.txt" // "café/naño.txt" in code page 437
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, fh := range []*zip.FileHeader{
+		{Name: raw, NonUTF8: true},
+		{Name: "utf8/naïve.txt"},
+	} {
+		f, err := w.CreateHeader(fh)
+		if err != nil {
+			t.Fatalf("CreateHeader %q: %v", fh.Name, err)
+		}
+		io.WriteString(f, "some text")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	z, err := Open(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for _, name := range []string{"café/naño.txt", "café", "utf8/naïve.txt"} {
+		if _, err := z.Stat(ctx, name); err != nil {
+			t.Errorf("Stat %q: unexpected error: %v", name, err)
+		}
+	}
+	if got, err := z.Glob(ctx, "caf?/*"); err != nil {
+		t.Errorf("Glob: unexpected error: %v", err)
+	} else if want := []string{"café/naño.txt"}; !equalStrings(got, want) {
+		t.Errorf("Glob: got %q, want %q", got, want)
+	}
+
+	z, err = Open(bytes.NewReader(buf.Bytes()), RawNames())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, err := z.Stat(ctx, raw); err != nil {
+		t.Errorf("Stat %q with RawNames: unexpected error: %v", raw, err)
+	}
+}
+
+// seekOnly hides every method of its reader but Read and Seek.
+type seekOnly struct{ io.ReadSeeker }
+
+// newManyEntryArchive returns the bytes of an archive with n entries, named by
+// their indices, each holding size pseudo-random bytes.  Since the data do not
+// compress, reading them entails many reads from the archive.
+func newManyEntryArchive(tb testing.TB, n, size int) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+	for i := 0; i < n; i++ {
+		f, err := w.Create(fmt.Sprintf("%03d", i))
+		if err != nil {
+			tb.Fatalf("Create: %v", err)
+		}
+		f.Write(data)
+	}
+	if err := w.Close(); err != nil {
+		tb.Fatalf("Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// readConcurrently reads every entry of z, each in its own goroutine, and
+// returns the total number of bytes read.
+func readConcurrently(z FS, n int) (int64, error) {
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	sizes := make([]int64, n)
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rc, err := z.Open(ctx, fmt.Sprintf("%03d", i))
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer rc.Close()
+			sizes[i], errs[i] = io.Copy(ioutil.Discard, rc)
+		}(i)
+	}
+	wg.Wait()
+	var total int64
+	for i, err := range errs {
+		if err != nil {
+			return 0, err
+		}
+		total += sizes[i]
+	}
+	return total, nil
+}
+
+func TestConcurrentReads(t *testing.T) {
+	const n, size = 100, 4096
+	data := newManyEntryArchive(t, n, size)
+	for _, test := range []struct {
+		r          io.ReadSeeker
+		serialized bool
+	}{
+		{bytes.NewReader(data), false},
+		{seekOnly{bytes.NewReader(data)}, true},
+	} {
+		z, err := Open(test.r)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		if _, got := z.src.(*readerAt); got != test.serialized {
+			t.Errorf("Open %T: got serialized reads %v, want %v", test.r, got, test.serialized)
+		}
+		if total, err := readConcurrently(z, n); err != nil {
+			t.Errorf("Reading %T: unexpected error: %v", test.r, err)
+		} else if want := int64(n * size); total != want {
+			t.Errorf("Reading %T: got %d bytes, want %d", test.r, total, want)
+		}
+	}
+}
+
+func BenchmarkConcurrentReads(b *testing.B) {
+	const n, size = 100, 64 << 10
+	data := newManyEntryArchive(b, n, size)
+	for _, bench := range []struct {
+		name string
+		r    func() io.ReadSeeker
+	}{
+		{"ReaderAt", func() io.ReadSeeker { return bytes.NewReader(data) }},
+		{"Serialized", func() io.ReadSeeker { return seekOnly{bytes.NewReader(data)} }},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			z, err := Open(bench.r())
+			if err != nil {
+				b.Fatalf("Open: %v", err)
+			}
+			b.SetBytes(n * size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := readConcurrently(z, n); err != nil {
+					b.Fatalf("Reading: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkCopyEntries(b *testing.B) {
+	ctx := context.Background()
+	const n, size = 100, 64 << 10
+	z, err := OpenBytes(newManyEntryArchive(b, n, size))
+	if err != nil {
+		b.Fatalf("OpenBytes: %v", err)
+	}
+	names, err := z.Glob(ctx, "*")
+	if err != nil {
+		b.Fatalf("Glob: %v", err)
+	}
+	for _, bench := range []struct {
+		name string
+		run  func() error
+	}{
+		{"CopyFile", func() error {
+			for _, name := range names {
+				if _, err := z.CopyFile(ctx, ioutil.Discard, name); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+		{"ValidateAll", func() error { return z.ValidateAll(ctx, 1) }},
+		{"Extract", func() error {
+			dir, err := ioutil.TempDir("", "zipbench")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(dir)
+			return z.Extract(ctx, dir, ExtractParallelism(1))
+		}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.SetBytes(n * size)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := bench.run(); err != nil {
+					b.Fatalf("%s: %v", bench.name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestEmbeddedArchive(t *testing.T) {
+	ctx := context.Background()
+	data := newArchive(t, "a/b.txt", "c.txt")
+	junk := func(n int) []byte { return bytes.Repeat([]byte("#!junk\n"), n/7+1)[:n] }
+
+	for _, test := range []struct {
+		desc      string
+		pre, post int
+	}{
+		{"prefix", 1000, 0},
+		{"short suffix", 0, 100},
+		{"long suffix", 0, 3 * scanChunk},
+		{"prefix and long suffix", 5000, scanChunk + 1},
+	} {
+		buf := append(append(junk(test.pre), data...), junk(test.post)...)
+		z, err := Open(bytes.NewReader(buf))
+		if err != nil {
+			t.Errorf("Open (%s): unexpected error: %v", test.desc, err)
+			continue
+		}
+		rc, err := z.Open(ctx, "a/b.txt")
+		if err != nil {
+			t.Errorf("Open (%s): unexpected error: %v", test.desc, err)
+			continue
+		}
+		got, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if want := "contents of a/b.txt"; err != nil || string(got) != want {
+			t.Errorf("Read (%s): got %q, %v; want %q", test.desc, got, err, want)
+		}
+	}
+
+	if _, err := Open(bytes.NewReader(junk(2 * scanChunk))); err != zip.ErrFormat {
+		t.Errorf("Open of junk: got error %v, want %v", err, zip.ErrFormat)
+	}
+
+	// Records whose central directory would not precede them are not tried,
+	// and only so many of the others are.
+	record := func(dirOffset uint32) []byte {
+		rec := make([]byte, eocdLen)
+		copy(rec, eocdSignature)
+		binary.LittleEndian.PutUint16(rec[8:], 1)
+		binary.LittleEndian.PutUint16(rec[10:], 1)
+		binary.LittleEndian.PutUint32(rec[12:], 46)
+		binary.LittleEndian.PutUint32(rec[16:], dirOffset)
+		return rec
+	}
+	for _, test := range []struct {
+		desc      string
+		dirOffset uint32
+		n         int
+		ok        bool
+	}{
+		{"few plausible records", 0, maxEmbeddedCandidates - 1, true},
+		{"many implausible records", 1 << 30, 10 * maxEmbeddedCandidates, true},
+		{"many plausible records", 0, maxEmbeddedCandidates, false},
+	} {
+		buf := append(junk(100), data...)
+		for i := 0; i < test.n; i++ {
+			buf = append(append(buf, junk(10)...), record(test.dirOffset)...)
+		}
+		if _, err := Open(bytes.NewReader(buf)); test.ok && err != nil {
+			t.Errorf("Open (%s): unexpected error: %v", test.desc, err)
+		} else if !test.ok && err != zip.ErrFormat {
+			t.Errorf("Open (%s): got error %v, want %v", test.desc, err, zip.ErrFormat)
+		}
+	}
+}
+
+func TestEmptyArchive(t *testing.T) {
+	ctx := context.Background()
+	z, err := Open(bytes.NewReader(newArchive(t)))
+	if err != nil {
+		t.Fatalf("Open of empty archive: unexpected error: %v", err)
+	}
+	if fi, err := z.Stat(ctx, "."); err != nil || !fi.IsDir() {
+		t.Errorf("Stat %q: got %v, %v; want a directory", ".", fi, err)
+	}
+	if got, err := z.Glob(ctx, "**"); err != nil || len(got) != 0 {
+		t.Errorf("Glob %q: got %q, %v; want no matches", "**", got, err)
+	}
+	if _, err := z.Stat(ctx, "a.txt"); !os.IsNotExist(err) {
+		t.Errorf("Stat %q: got error %v, want not exist", "a.txt", err)
+	}
+	if _, err := z.Open(ctx, "a.txt"); !os.IsNotExist(err) {
+		t.Errorf("Open %q: got error %v, want not exist", "a.txt", err)
+	}
+
+	if _, err := Open(bytes.NewReader([]byte("not an archive"))); err != zip.ErrFormat {
+		t.Errorf("Open of invalid archive: got error %v, want %v", err, zip.ErrFormat)
+	}
+}
+
+func TestHeader(t *testing.T) {
+	ctx := context.Background()
+	inner := newArchive(t, "src/main.go")
+	z, err := Open(bytes.NewReader(newArchiveEntries(t,
+		entry{"a/b.txt", "hello"},
+		entry{"lib.zip", string(inner)},
+	)))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	for _, path := range []string{"a/b.txt", "./a/b.txt", "lib.zip!/src/main.go"} {
+		fh, err := z.Header(path)
+		if err != nil {
+			t.Errorf("Header %q: unexpected error: %v", path, err)
+			continue
+		}
+		fi, err := z.Stat(ctx, path)
+		if err != nil {
+			t.Errorf("Stat %q: unexpected error: %v", path, err)
+			continue
+		}
+		sys, ok := fi.Sys().(*zip.FileHeader)
+		if !ok {
+			t.Errorf("Stat %q: Sys returned %T, want *zip.FileHeader", path, fi.Sys())
+			continue
+		}
+		if sys.Name != fh.Name || sys.CRC32 != fh.CRC32 || sys.Method != fh.Method {
+			t.Errorf("Stat %q: Sys got %+v, want %+v", path, sys, fh)
+		}
+		fh.Name = "changed"
+		if again, err := z.Header(path); err != nil || again.Name == "changed" {
+			t.Errorf("Header %q: got %v, %v after modifying an earlier result", path, again, err)
+		}
+	}
+
+	for _, path := range []string{"a", "missing.txt", "lib.zip!/src"} {
+		if fh, err := z.Header(path); !os.IsNotExist(err) {
+			t.Errorf("Header %q: got %v, %v; want not exist", path, fh, err)
+		}
+	}
+	if _, err := z.Header("../x"); err == nil {
+		t.Errorf("Header %q: got nil error, want an error", "../x")
+	}
+}
+
+func TestStatBatch(t *testing.T) {
+	z, err := Open(bytes.NewReader(newArchive(t, "a/b.txt", "c.txt")))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	paths := []string{"c.txt", "missing", "a", "a/b.txt", "../x"}
+	infos, errs := z.StatBatch(context.Background(), paths)
+	if len(infos) != len(paths) || len(errs) != len(paths) {
+		t.Fatalf("StatBatch: got %d infos and %d errors, want %d of each", len(infos), len(errs), len(paths))
+	}
+	for i, path := range paths {
+		want, wantErr := z.Stat(context.Background(), path)
+		if (errs[i] == nil) != (wantErr == nil) {
+			t.Errorf("StatBatch %q: got error %v, want %v", path, errs[i], wantErr)
+		} else if want != nil && (infos[i].Name() != want.Name() || infos[i].IsDir() != want.IsDir()) {
+			t.Errorf("StatBatch %q: got %v, want %v", path, infos[i], want)
+		}
+	}
+	if !os.IsNotExist(errs[1]) {
+		t.Errorf("StatBatch %q: got error %v, want not exist", paths[1], errs[1])
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, errs := z.StatBatch(ctx, paths); errs[0] != context.Canceled {
+		t.Errorf("StatBatch with cancelled context: got error %v, want %v", errs[0], context.Canceled)
+	}
+}
+
+func TestExistsIsDir(t *testing.T) {
+	ctx := context.Background()
+	z, err := Open(bytes.NewReader(newArchive(t, "a/b/c.txt", "d/", "e.txt")))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for _, test := range []struct {
+		path          string
+		exists, isDir bool
+	}{
+		{".", true, true},
+		{"a", true, true},
+		{"a/b", true, true},
+		{"a/b/c.txt", true, false},
+		{"d", true, true},
+		{"e.txt", true, false},
+		{"missing", false, false},
+		{"../e.txt", false, false},
+	} {
+		if got := z.Exists(ctx, test.path); got != test.exists {
+			t.Errorf("Exists %q: got %v, want %v", test.path, got, test.exists)
+		}
+		got, err := z.IsDir(ctx, test.path)
+		if got != test.isDir {
+			t.Errorf("IsDir %q: got %v, want %v", test.path, got, test.isDir)
+		}
+		if test.exists && err != nil {
+			t.Errorf("IsDir %q: unexpected error: %v", test.path, err)
+		} else if !test.exists && err == nil {
+			t.Errorf("IsDir %q: got nil error, want an error", test.path)
+		}
+	}
+	if _, err := z.IsDir(ctx, "missing"); !os.IsNotExist(err) {
+		t.Errorf("IsDir %q: got error %v, want not exist", "missing", err)
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	ctx := context.Background()
+	inner := newArchive(t, "src/main.go")
+	data := newArchiveEntries(t,
+		entry{"a.txt", "hello"},
+		entry{"empty.txt", ""},
+		entry{"large.txt", strings.Repeat("x", 1000)},
+		entry{"lib.zip", string(inner)},
+	)
+	z, err := Open(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for path, want := range map[string]string{
+		"a.txt":                "hello",
+		"./a.txt":              "hello",
+		"empty.txt":            "",
+		"large.txt":            strings.Repeat("x", 1000),
+		"lib.zip!/src/main.go": "contents of src/main.go",
+	} {
+		if got, err := z.ReadFile(ctx, path); err != nil || string(got) != want {
+			t.Errorf("ReadFile %q: got %q, %v; want %q", path, got, err, want)
+		}
+	}
+	if _, err := z.ReadFile(ctx, "missing"); !os.IsNotExist(err) {
+		t.Errorf("ReadFile %q: got error %v, want not exist", "missing", err)
+	}
+
+	z, err = Open(bytes.NewReader(data), MaxUncompressedBytes(100))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, err := z.ReadFile(ctx, "large.txt"); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("ReadFile %q: got error %v, want %v", "large.txt", err, ErrTooLarge)
+	}
+}
+
+func TestGlobParallel(t *testing.T) {
+	ctx := context.Background()
+	data := newManyEntryArchive(t, 3*minShard+17, 0)
+	serial, err := Open(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	parallel, err := Open(bytes.NewReader(data), GlobParallelism(4))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for _, glob := range []string{"*", "1*", "*7", "**", "nothing"} {
+		want, err := serial.Glob(ctx, glob)
+		if err != nil {
+			t.Fatalf("Glob %q: unexpected error: %v", glob, err)
+		}
+		got, err := parallel.Glob(ctx, glob)
+		if err != nil {
+			t.Errorf("Glob %q: unexpected error: %v", glob, err)
+		} else if !equalStrings(got, want) {
+			t.Errorf("Glob %q: got %d matches, want %d matching the serial scan", glob, len(got), len(want))
+		}
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := parallel.Glob(cctx, "*"); err != context.Canceled {
+		t.Errorf("Glob with cancelled context: got error %v, want %v", err, context.Canceled)
+	}
+	if _, err := Open(bytes.NewReader(data), GlobParallelism(-1)); err == nil {
+		t.Error("Open with GlobParallelism(-1): got nil error, want an error")
+	}
+}
+
+func BenchmarkGlob(b *testing.B) {
+	data := newManyEntryArchive(b, 200000, 0)
+	for _, bench := range []struct {
+		name    string
+		workers int
+	}{
+		{"Serial", 1},
+		{"Parallel", 0},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			z, err := Open(bytes.NewReader(data), GlobParallelism(bench.workers))
+			if err != nil {
+				b.Fatalf("Open: %v", err)
+			}
+			ctx := context.Background()
+			if _, err := z.Glob(ctx, "*"); err != nil { // build the index
+				b.Fatalf("Glob: %v", err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := z.Glob(ctx, "1*5*"); err != nil {
+					b.Fatalf("Glob: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestStats(t *testing.T) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, fh := range []*zip.FileHeader{
+		{Name: "a/stored.txt", Method: zip.Store},
+		{Name: "a/b/deflated.txt", Method: zip.Deflate},
+		{Name: "c/", Method: zip.Store},
+		{Name: "deflated.txt", Method: zip.Deflate},
+	} {
+		f, err := w.CreateHeader(fh)
+		if err != nil {
+			t.Fatalf("CreateHeader %q: %v", fh.Name, err)
+		}
+		if !strings.HasSuffix(fh.Name, "/") {
+			io.WriteString(f, strings.Repeat("x", 1000))
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	z, err := Open(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	s := z.Stats()
+	if s.NumFiles != 3 || s.NumDirs != 3 {
+		t.Errorf("Stats: got %d files and %d dirs, want 3 and 3", s.NumFiles, s.NumDirs)
+	}
+	if s.TotalUncompressed != 3000 {
+		t.Errorf("Stats: got TotalUncompressed %d, want 3000", s.TotalUncompressed)
+	}
+	if want := s.Stored.Compressed + s.Deflated.Compressed; s.TotalCompressed != want {
+		t.Errorf("Stats: got TotalCompressed %d, want %d", s.TotalCompressed, want)
+	}
+	if want := float64(s.TotalUncompressed) / float64(s.TotalCompressed); s.CompressionRatio != want || want <= 1 {
+		t.Errorf("Stats: got CompressionRatio %v, want %v > 1", s.CompressionRatio, want)
+	}
+	if got, want := s.Stored, (MethodStats{1, 1000, 1000}); got != want {
+		t.Errorf("Stats: got Stored %+v, want %+v", got, want)
+	}
+	if s.Deflated.NumFiles != 2 || s.Deflated.Uncompressed != 2000 || s.Deflated.Compressed >= 2000 {
+		t.Errorf("Stats: got Deflated %+v, want 2 files compressing 2000 bytes", s.Deflated)
+	}
+	if s.Other != (MethodStats{}) {
+		t.Errorf("Stats: got Other %+v, want none", s.Other)
+	}
+
+	sub, err := z.Sub("a")
+	if err != nil {
+		t.Fatalf("Sub: %v", err)
+	}
+	if s := sub.Stats(); s.NumFiles != 2 || s.NumDirs != 1 || s.TotalUncompressed != 2000 {
+		t.Errorf("Stats of %q: got %+v, want 2 files and 1 dir totalling 2000 bytes", "a", s)
+	}
+}
+
+func TestMmap(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "archive.zip")
+	if err := ioutil.WriteFile(path, newArchive(t, "a/b.txt", "c.txt"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	r, size, release, err := Mmap(path)
+	if err != nil {
+		t.Fatalf("Mmap: %v", err)
+	}
+	z, err := OpenAt(r, size)
+	if err != nil {
+		t.Fatalf("OpenAt: %v", err)
+	}
+	for _, name := range []string{"a/b.txt", "c.txt"} {
+		rc, err := z.Open(ctx, name)
+		if err != nil {
+			t.Fatalf("Open %q: %v", name, err)
+		}
+		got, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if want := "contents of " + name; err != nil || string(got) != want {
+			t.Errorf("Read %q: got %q, %v; want %q", name, got, err, want)
+		}
+	}
+	if err := release(); err != nil {
+		t.Errorf("release: unexpected error: %v", err)
+	}
+	if err := release(); err != nil {
+		t.Errorf("release again: unexpected error: %v", err)
+	}
+
+	// An empty file cannot be mapped, but can still be read.
+	empty := filepath.Join(dir, "empty")
+	if err := ioutil.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	r, size, release, err = Mmap(empty)
+	if err != nil {
+		t.Fatalf("Mmap of an empty file: %v", err)
+	}
+	if n, err := r.ReadAt(make([]byte, 1), 0); size != 0 || n != 0 || err != io.EOF {
+		t.Errorf("ReadAt of an empty file: got size %d, (%d, %v); want 0, (0, EOF)", size, n, err)
+	}
+	// The file is not mapped, and the function closes it only once.
+	if err := release(); err != nil {
+		t.Errorf("release of an empty file: unexpected error: %v", err)
+	}
+	if err := release(); err != nil {
+		t.Errorf("release of an empty file again: unexpected error: %v", err)
+	}
+
+	if _, _, _, err := Mmap(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Errorf("Mmap of a missing file: got error %v, want not exist", err)
+	}
+}
+
+func BenchmarkRandomAccess(b *testing.B) {
+	const n, size = 1000, 4 << 10
+	path := filepath.Join(b.TempDir(), "archive.zip")
+	if err := ioutil.WriteFile(path, newManyEntryArchive(b, n, size), 0644); err != nil {
+		b.Fatalf("WriteFile: %v", err)
+	}
+	for _, bench := range []struct {
+		name string
+		open func() (FS, func() error, error)
+	}{
+		{"File", func() (FS, func() error, error) {
+			z, c, err := OpenFile(path)
+			if err != nil {
+				return FS{}, nil, err
+			}
+			return z, c.Close, nil
+		}},
+		{"Mmap", func() (FS, func() error, error) {
+			r, n, release, err := Mmap(path)
+			if err != nil {
+				return FS{}, nil, err
+			}
+			z, err := OpenAt(r, n)
+			return z, release, err
+		}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			z, done, err := bench.open()
+			if err != nil {
+				b.Fatalf("Open: %v", err)
+			}
+			defer done()
+			ctx := context.Background()
+			order := rand.New(rand.NewSource(1)).Perm(n)
+			b.SetBytes(n * size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for _, j := range order {
+					rc, err := z.Open(ctx, fmt.Sprintf("%03d", j))
+					if err != nil {
+						b.Fatalf("Open: %v", err)
+					}
+					io.Copy(ioutil.Discard, rc)
+					rc.Close()
+				}
+			}
+		})
+	}
+}
+
+func TestAsKzip(t *testing.T) {
+	ctx := context.Background()
+	z, err := Open(bytes.NewReader(newArchiveEntries(t,
+		entry{"root/", ""},
+		entry{"root/units/", ""},
+		entry{"root/units/bbb", "unit b"},
+		entry{"root/units/aaa", "unit a"},
+		entry{"root/files/ccc", "file c"},
+	)))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	k, err := z.AsKzip()
+	if err != nil {
+		t.Fatalf("AsKzip: unexpected error: %v", err)
+	}
+	if k.Root() != "root" {
+		t.Errorf("Root: got %q, want %q", k.Root(), "root")
+	}
+	if got, want := k.Units(), []string{"aaa", "bbb"}; !equalStrings(got, want) {
+		t.Errorf("Units: got %q, want %q", got, want)
+	}
+	read := func(rc io.ReadCloser, err error) string {
+		if err != nil {
+			return "error: " + err.Error()
+		}
+		defer rc.Close()
+		data, err := ioutil.ReadAll(rc)
+		if err != nil {
+			return "error: " + err.Error()
+		}
+		return string(data)
+	}
+	if got := read(k.Unit(ctx, "aaa")); got != "unit a" {
+		t.Errorf("Unit %q: got %q, want %q", "aaa", got, "unit a")
+	}
+	if got := read(k.File(ctx, "ccc")); got != "file c" {
+		t.Errorf("File %q: got %q, want %q", "ccc", got, "file c")
+	}
+	for _, digest := range []string{"missing", "aaa", "../units/aaa", ""} {
+		if _, err := k.File(ctx, digest); !os.IsNotExist(err) {
+			t.Errorf("File %q: got error %v, want not exist", digest, err)
+		}
+	}
+
+	for _, test := range []struct {
+		desc    string
+		entries []entry
+	}{
+		{"empty", nil},
+		{"top-level file", []entry{{"root/units/aaa", ""}, {"README", ""}}},
+		{"two roots", []entry{{"a/units/aaa", ""}, {"b/units/bbb", ""}}},
+		{"no units", []entry{{"root/files/ccc", ""}}},
+		{"files not a directory", []entry{{"root/units/aaa", ""}, {"root/files", ""}}},
+	} {
+		z, err := Open(bytes.NewReader(newArchiveEntries(t, test.entries...)))
+		if err != nil {
+			t.Fatalf("Open (%s): %v", test.desc, err)
+		}
+		if _, err := z.AsKzip(); !errors.Is(err, ErrNotKzip) {
+			t.Errorf("AsKzip (%s): got error %v, want %v", test.desc, err, ErrNotKzip)
+		}
+	}
+}
+
+func TestDataDescriptors(t *testing.T) {
+	ctx := context.Background()
+	const contents = "streamed contents, streamed contents"
+
+	// A zip.Writer writes entries in streaming mode, so that each is followed
+	// by a data descriptor holding its CRC-32 and sizes.
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, fh := range []*zip.FileHeader{
+		{Name: "stored.txt", Method: zip.Store},
+		{Name: "deflated.txt", Method: zip.Deflate},
+	} {
+		f, err := w.CreateHeader(fh)
+		if err != nil {
+			t.Fatalf("CreateHeader %q: %v", fh.Name, err)
+		}
+		io.WriteString(f, contents)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	z, err := Open(bytes.NewReader(buf.Bytes()), VerifyChecksums())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	for _, name := range []string{"stored.txt", "deflated.txt"} {
+		fh, err := z.Header(name)
+		if err != nil {
+			t.Fatalf("Header %q: %v", name, err)
+		} else if fh.Flags&flagDataDescriptor == 0 {
+			t.Fatalf("Header %q: flags %#x lack the data descriptor bit", name, fh.Flags)
+		}
+		if got, err := z.ReadFile(ctx, name); err != nil || string(got) != contents {
+			t.Errorf("ReadFile %q: got %q, %v; want %q", name, got, err, contents)
+		}
+
+		r, size, err := z.OpenReaderAt(name)
+		if err != nil {
+			t.Fatalf("OpenReaderAt %q: %v", name, err)
+		}
+		got := make([]byte, size+4)
+		n, err := r.ReadAt(got, 0)
+		if err != io.EOF || string(got[:n]) != contents {
+			t.Errorf("OpenReaderAt %q: read %q, %v; want %q and io.EOF", name, got[:n], err, contents)
+		}
+
+		raw, _, err := z.OpenRaw(name)
+		if err != nil {
+			t.Fatalf("OpenRaw %q: %v", name, err)
+		}
+		data, err := ioutil.ReadAll(raw)
+		if err != nil || uint64(len(data)) != fh.CompressedSize64 {
+			t.Errorf("OpenRaw %q: read %d bytes, %v; want %d", name, len(data), err, fh.CompressedSize64)
+		}
+		if bytes.Contains(data, []byte("PK\x07\x08")) {
+			t.Errorf("OpenRaw %q: data include the data descriptor", name)
+		}
+	}
+}
+
+func TestCopySubset(t *testing.T) {
+	ctx := context.Background()
+	z := openArchive(t, "a/", "a/b/c.txt", "a/d.txt", "e/f.txt", "g.txt")
+	var buf bytes.Buffer
+	keep := func(name string) bool { return name != "a/d.txt" && name != "g.txt" }
+	if err := z.CopySubset(ctx, &buf, keep); err != nil {
+		t.Fatalf("CopySubset: unexpected error: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Reading the copy: %v", err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if want := []string{"a/", "a/b/", "a/b/c.txt", "e/", "e/f.txt"}; !equalStrings(names, want) {
+		t.Errorf("CopySubset: got entries %q, want %q", names, want)
+	}
+
+	// The entries are copied without being recompressed.
+	for _, f := range zr.File {
+		if strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		got, _, err := z.OpenRaw(f.Name)
+		if err != nil {
+			t.Fatalf("OpenRaw %q: %v", f.Name, err)
+		}
+		want, err := f.OpenRaw()
+		if err != nil {
+			t.Fatalf("OpenRaw %q in the copy: %v", f.Name, err)
+		}
+		g, _ := ioutil.ReadAll(got)
+		w, _ := ioutil.ReadAll(want)
+		if !bytes.Equal(g, w) {
+			t.Errorf("Entry %q: the copy's stored data differ from the original's", f.Name)
+		}
+	}
+
+	cz, err := Open(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Open of the copy: %v", err)
+	}
+	if got, err := cz.ReadFile(ctx, "e/f.txt"); err != nil || string(got) != "contents of e/f.txt" {
+		t.Errorf("ReadFile %q: got %q, %v; want %q", "e/f.txt", got, err, "contents of e/f.txt")
+	}
+
+	sub, err := z.Sub("a")
+	if err != nil {
+		t.Fatalf("Sub: %v", err)
+	}
+	buf.Reset()
+	if err := sub.CopySubset(ctx, &buf, func(string) bool { return true }); err != nil {
+		t.Fatalf("CopySubset of %q: unexpected error: %v", "a", err)
+	}
+	zr, err = zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Reading the copy: %v", err)
+	}
+	names = names[:0]
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if want := []string{"b/", "b/c.txt", "d.txt"}; !equalStrings(names, want) {
+		t.Errorf("CopySubset of %q: got entries %q, want %q", "a", names, want)
+	}
+}
+
+func TestModTimes(t *testing.T) {
+	ctx := context.Background()
+	est := time.FixedZone("EST", -5*3600)
+	when := time.Date(2015, 6, 1, 12, 30, 0, 0, est)
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	// An extended timestamp field records the exact time.
+	if _, err := w.CreateHeader(&zip.FileHeader{Name: "ext.txt", Modified: when}); err != nil {
+		t.Fatalf("CreateHeader: %v", err)
+	}
+	// Without one, the MS-DOS date and time are taken to be in UTC.
+	dos := &zip.FileHeader{Name: "dos.txt"}
+	dos.ModifiedDate = uint16((2015-1980)<<9 | 6<<5 | 1)
+	dos.ModifiedTime = uint16(12<<11 | 30<<5)
+	if _, err := w.CreateHeader(dos); err != nil {
+		t.Fatalf("CreateHeader: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	z, err := Open(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	for path, want := range map[string]time.Time{
+		"ext.txt": when.UTC(),
+		"dos.txt": time.Date(2015, 6, 1, 12, 30, 0, 0, time.UTC),
+	} {
+		got, err := z.ModTime(path)
+		if err != nil || !got.Equal(want) || got.Location() != time.UTC {
+			t.Errorf("ModTime %q: got %v, %v; want %v", path, got, err, want)
+		}
+		if fi, err := z.Stat(ctx, path); err != nil || !fi.ModTime().Equal(want) {
+			t.Errorf("Stat %q: got %v, %v; want modification time %v", path, fi, err, want)
+		}
+	}
+	if got, err := z.ModTime("."); err != nil || !got.IsZero() {
+		t.Errorf("ModTime %q: got %v, %v; want the zero time", ".", got, err)
+	}
+
+	// CopyTo preserves the times, both in archives and on disk.
+	buf.Reset()
+	cw := NewWriter(&buf)
+	if err := CopyTo(ctx, z, cw); err != nil {
+		t.Fatalf("CopyTo: %v", err)
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	copied, err := Open(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Open of the copy: %v", err)
+	}
+	if got, err := copied.ModTime("ext.txt"); err != nil || !got.Equal(when) {
+		t.Errorf("ModTime %q in the copy: got %v, %v; want %v", "ext.txt", got, err, when)
+	}
+
+	dir := t.TempDir()
+	if err := CopyTo(ctx, z, vfs.LocalFS{}, CopyRename(func(name string) string { return filepath.Join(dir, name) })); err != nil {
+		t.Fatalf("CopyTo: %v", err)
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "ext.txt")); err != nil || !fi.ModTime().Equal(when) {
+		t.Errorf("Stat of the extracted file: got %v, %v; want modification time %v", fi, err, when)
+	}
+}
+
+func TestGlobClean(t *testing.T) {
+	ctx := context.Background()
+	z := openArchive(t, "./src/a.go", "./src//b.go", "src/c/", "./README", "src/d.txt")
+	for _, test := range []struct {
+		glob      string
+		raw, want []string
+	}{
+		{"src/*.go", nil, []string{"src/a.go", "src/b.go"}},
+		{"./src/*.go", []string{"./src/a.go"}, nil},
+		{"*", nil, []string{"README"}},
+		{"**", []string{"./README", "./src//b.go", "./src/a.go", "src/c/", "src/d.txt"},
+			[]string{"README", "src/a.go", "src/b.go", "src/c/", "src/d.txt"}},
+	} {
+		if got, err := z.Glob(ctx, test.glob); err != nil || !equalStrings(got, test.raw) {
+			t.Errorf("Glob %q: got %q, %v; want %q", test.glob, got, err, test.raw)
+		}
+		if got, err := z.GlobClean(ctx, test.glob); err != nil || !equalStrings(got, test.want) {
+			t.Errorf("GlobClean %q: got %q, %v; want %q", test.glob, got, err, test.want)
+		}
+	}
+
+	// The cleaned names are those by which the entries are found.
+	names, err := z.GlobClean(ctx, "src/*.go")
+	if err != nil {
+		t.Fatalf("GlobClean: %v", err)
+	}
+	for _, name := range names {
+		if _, err := z.ReadFile(ctx, name); err != nil {
+			t.Errorf("ReadFile %q: unexpected error: %v", name, err)
+		}
+	}
+	if got, err := z.ReadDir(ctx, "src"); err != nil || len(got) != 4 {
+		t.Errorf("ReadDir %q: got %d entries, %v; want 4", "src", len(got), err)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	ctx := context.Background()
+	z := openArchive(t, "a.go", "src/b.go", "src/testdata/c.go", "src/d.txt", "testdata/e.go", "src/f/")
+	for _, test := range []struct {
+		re   string
+		want []string
+	}{
+		{`^(?:[^/]+/)*[^/]+\.go$`, []string{"a.go", "src/b.go", "src/testdata/c.go", "testdata/e.go"}},
+		{`\.txt$`, []string{"src/d.txt"}},
+		{`/$`, []string{"src/f/"}},
+		{`testdata/`, []string{"src/testdata/c.go", "testdata/e.go"}},
+		{`nothing`, nil},
+	} {
+		got, err := z.Match(ctx, regexp.MustCompile(test.re))
+		if err != nil || !equalStrings(got, test.want) {
+			t.Errorf("Match %q: got %q, %v; want %q", test.re, got, err, test.want)
+		}
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := z.Match(cctx, regexp.MustCompile(".")); err != context.Canceled {
+		t.Errorf("Match with cancelled context: got error %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, e := range []struct {
+		name   string
+		method uint16
+		size   int
+	}{
+		{"big.bin", zip.Store, 2000},
+		{"big.txt", zip.Deflate, 2000},
+		{"small.bin", zip.Store, 10},
+		{"dir/", zip.Store, 0},
+	} {
+		f, err := w.CreateHeader(&zip.FileHeader{Name: e.name, Method: e.method})
+		if err != nil {
+			t.Fatalf("CreateHeader %q: %v", e.name, err)
+		}
+		f.Write(bytes.Repeat([]byte("x"), e.size))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	z, err := Open(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	for _, test := range []struct {
+		desc string
+		pred func(EntryInfo) bool
+		want []string
+	}{
+		{"large stored", func(e EntryInfo) bool { return e.Method == zip.Store && e.Size > 1000 }, []string{"big.bin"}},
+		{"compressed", func(e EntryInfo) bool { return e.CompressedSize < e.Size }, []string{"big.txt"}},
+		{"directories", func(e EntryInfo) bool { return strings.HasSuffix(e.Name, "/") }, []string{"dir/"}},
+		{"none", func(EntryInfo) bool { return false }, nil},
+	} {
+		if got, err := z.Select(ctx, test.pred); err != nil || !equalStrings(got, test.want) {
+			t.Errorf("Select (%s): got %q, %v; want %q", test.desc, got, err, test.want)
+		}
+	}
+}
+
+func TestMethodRatio(t *testing.T) {
+	random := make([]byte, 1000)
+	rand.New(rand.NewSource(1)).Read(random)
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, e := range []struct {
+		name   string
+		method uint16
+		data   []byte
+	}{
+		{"stored.txt", zip.Store, bytes.Repeat([]byte("x"), 1000)},
+		{"text.txt", zip.Deflate, bytes.Repeat([]byte("x"), 1000)},
+		{"random.bin", zip.Deflate, random},
+		{"empty.txt", zip.Deflate, nil},
+	} {
+		f, err := w.CreateHeader(&zip.FileHeader{Name: e.name, Method: e.method})
+		if err != nil {
+			t.Fatalf("CreateHeader %q: %v", e.name, err)
+		}
+		f.Write(e.data)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	z, err := Open(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	for _, test := range []struct {
+		path     string
+		method   uint16
+		min, max float64
+	}{
+		{"stored.txt", zip.Store, 1, 1},
+		{"text.txt", zip.Deflate, 0, 0.1},
+		{"random.bin", zip.Deflate, 1, 1.1},
+		{"empty.txt", zip.Deflate, 1, 1},
+	} {
+		if got, err := z.Method(test.path); err != nil || got != test.method {
+			t.Errorf("Method %q: got %d, %v; want %d", test.path, got, err, test.method)
+		}
+		if got, err := z.Ratio(test.path); err != nil || got < test.min || got > test.max {
+			t.Errorf("Ratio %q: got %v, %v; want between %v and %v", test.path, got, err, test.min, test.max)
+		}
+	}
+	if _, err := z.Method("missing"); !os.IsNotExist(err) {
+		t.Errorf("Method %q: got error %v, want not exist", "missing", err)
+	}
+	if _, err := z.Ratio("missing"); !os.IsNotExist(err) {
+		t.Errorf("Ratio %q: got error %v, want not exist", "missing", err)
+	}
+}
+
+func TestOpenBytes(t *testing.T) {
+	ctx := context.Background()
+	z, err := OpenBytes(newArchive(t, "a/b.txt", "c.txt"))
+	if err != nil {
+		t.Fatalf("OpenBytes: unexpected error: %v", err)
+	}
+	if got, err := z.ReadFile(ctx, "a/b.txt"); err != nil || string(got) != "contents of a/b.txt" {
+		t.Errorf("ReadFile %q: got %q, %v; want %q", "a/b.txt", got, err, "contents of a/b.txt")
+	}
+	if _, ok := z.src.(*bytes.Reader); !ok {
+		t.Errorf("OpenBytes: source is %T, want *bytes.Reader", z.src)
+	}
+	if _, err := OpenBytes([]byte("not an archive")); err != zip.ErrFormat {
+		t.Errorf("OpenBytes of invalid data: got error %v, want %v", err, zip.ErrFormat)
+	}
+}
+
+// splitArchive cuts the archive in data into n volumes, and rewrites its
+// central directory to record offsets relative to the volumes, as "zip -s"
+// does.  The central directory is kept whole in the last volume.
+func splitArchive(t *testing.T, data []byte, n int) [][]byte {
+	end := data[len(data)-eocdLen:]
+	cdSize := int(binary.LittleEndian.Uint32(end[12:]))
+	cdStart := int(binary.LittleEndian.Uint32(end[16:]))
+	var starts []int
+	for i := 0; i < n; i++ {
+		starts = append(starts, i*cdStart/n)
+	}
+	volume := func(off int) int { return sort.SearchInts(starts, off+1) - 1 }
+
+	dir := append([]byte(nil), data[cdStart:]...)
+	for rec := dir[:cdSize]; len(rec) > 0; {
+		off := int(binary.LittleEndian.Uint32(rec[42:]))
+		d := volume(off)
+		binary.LittleEndian.PutUint16(rec[34:], uint16(d))
+		binary.LittleEndian.PutUint32(rec[42:], uint32(off-starts[d]))
+		rec = rec[cdLen+int(binary.LittleEndian.Uint16(rec[28:]))+
+			int(binary.LittleEndian.Uint16(rec[30:]))+int(binary.LittleEndian.Uint16(rec[32:])):]
+	}
+	tail := dir[cdSize:]
+	binary.LittleEndian.PutUint16(tail[4:], uint16(n-1))
+	binary.LittleEndian.PutUint16(tail[6:], uint16(n-1))
+	binary.LittleEndian.PutUint32(tail[16:], uint32(cdStart-starts[n-1]))
+
+	var parts [][]byte
+	for i, start := range starts {
+		if i+1 < n {
+			parts = append(parts, data[start:starts[i+1]])
+		} else {
+			parts = append(parts, append(data[start:cdStart:cdStart], dir...))
+		}
+	}
+	return parts
+}
+
+func TestOpenVolumes(t *testing.T) {
+	ctx := context.Background()
+	names := []string{"a/b.txt", "a/c.txt", "d.txt", "e/f/g.txt"}
+	data := newArchive(t, names...)
+	open := func(parts [][]byte) (FS, error) {
+		var rs []io.ReaderAt
+		var sizes []int64
+		for _, p := range parts {
+			rs = append(rs, bytes.NewReader(p))
+			sizes = append(sizes, int64(len(p)))
+		}
+		return OpenVolumes(rs, sizes)
+	}
+	check := func(desc string, z FS) {
+		for _, name := range names {
+			if got, err := z.ReadFile(ctx, name); err != nil || string(got) != "contents of "+name {
+				t.Errorf("%s: ReadFile %q: got %q, %v; want %q", desc, name, got, err, "contents of "+name)
+			}
+		}
+	}
+
+	split := splitArchive(t, data, 3)
+	if z, err := open(split); err != nil {
+		t.Errorf("OpenVolumes of split archive: unexpected error: %v", err)
+	} else {
+		check("split archive", z)
+	}
+
+	// Volumes cut from an archive without rewriting it are read as is.
+	cut := [][]byte{data[:10], data[10:100], data[100:]}
+	if z, err := open(cut); err != nil {
+		t.Errorf("OpenVolumes of cut archive: unexpected error: %v", err)
+	} else {
+		check("cut archive", z)
+	}
+
+	bad := []struct {
+		desc  string
+		parts [][]byte
+	}{
+		{"missing last volume", split[:2]},
+		{"missing first volume", split[1:]},
+		{"volumes out of order", [][]byte{split[1], split[0], split[2]}},
+		{"cut volumes out of order", [][]byte{cut[1], cut[0], cut[2]}},
+	}
+	for _, test := range bad {
+		if _, err := open(test.parts); !errors.Is(err, zip.ErrFormat) {
+			t.Errorf("OpenVolumes with %s: got error %v, want %v", test.desc, err, zip.ErrFormat)
+		}
+	}
+	if _, err := OpenVolumes(nil, nil); !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("OpenVolumes with no volumes: got error %v, want %v", err, os.ErrInvalid)
+	}
+}
+
+func TestDataOffset(t *testing.T) {
+	data := newArchive(t, "a/b.txt", "c.txt")
+	z, err := OpenBytes(data)
+	if err != nil {
+		t.Fatalf("OpenBytes: unexpected error: %v", err)
+	}
+	for _, name := range []string{"a/b.txt", "c.txt"} {
+		off, err := z.DataOffset(name)
+		if err != nil {
+			t.Errorf("DataOffset %q: unexpected error: %v", name, err)
+			continue
+		}
+		fh, err := z.Header(name)
+		if err != nil {
+			t.Fatalf("Header %q: unexpected error: %v", name, err)
+		}
+		raw, _, err := z.OpenRaw(name)
+		if err != nil {
+			t.Fatalf("OpenRaw %q: unexpected error: %v", name, err)
+		}
+		want, _ := ioutil.ReadAll(raw)
+		if got := data[off : off+int64(fh.CompressedSize64)]; !bytes.Equal(got, want) {
+			t.Errorf("Data at offset %d of %q: got %q, want %q", off, name, got, want)
+		}
+	}
+	if _, err := z.DataOffset("missing"); !os.IsNotExist(err) {
+		t.Errorf("DataOffset of missing entry: got error %v, want not-exist", err)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	ctx := context.Background()
+	n := 3*minShard + 17
+	data := newManyEntryArchive(t, n, 1)
+
+	// record returns a progress function that checks it is called with
+	// increasing counts, and a function returning its last arguments.
+	record := func(desc string) (func(done, total int), func() (int, int)) {
+		var last, lastTotal int
+		fn := func(done, total int) {
+			if done <= last || done > total {
+				t.Errorf("%s: progress %d of %d after %d", desc, done, total, last)
+			}
+			last, lastTotal = done, total
+		}
+		return fn, func() (int, int) { return last, lastTotal }
+	}
+	for _, workers := range []int{1, 4} {
+		fn, last := record("Glob")
+		z, err := Open(bytes.NewReader(data), GlobParallelism(workers), Progress(fn))
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		if _, err := z.Glob(ctx, "*"); err != nil {
+			t.Fatalf("Glob: unexpected error: %v", err)
+		}
+		if done, total := last(); done != n || total != n {
+			t.Errorf("Glob with %d workers: last progress %d of %d, want %d of %d", workers, done, total, n, n)
+		}
+	}
+
+	small := newArchive(t, "a/b.txt", "c.txt", "d/")
+	fn, last := record("ValidateAll")
+	z, err := Open(bytes.NewReader(small), Progress(fn))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := z.ValidateAll(ctx, 2); err != nil {
+		t.Fatalf("ValidateAll: unexpected error: %v", err)
+	}
+	if done, total := last(); done != 3 || total != 3 {
+		t.Errorf("ValidateAll: last progress %d of %d, want 3 of 3", done, total)
+	}
+
+	fn, last = record("CopyTo")
+	w := NewWriter(ioutil.Discard)
+	if err := CopyTo(ctx, z, w, CopyProgress(fn)); err != nil {
+		t.Fatalf("CopyTo: unexpected error: %v", err)
+	}
+	if done, total := last(); done != 2 || total != 2 {
+		t.Errorf("CopyTo: last progress %d of %d, want 2 of 2", done, total)
+	}
+
+	if _, err := Open(bytes.NewReader(small), Progress(nil)); err == nil {
+		t.Error("Open with Progress(nil): got nil error, want an error")
+	}
+}
+
+// extraRecord encodes an extra field record with the given ID and data.
+func extraRecord(id uint16, data ...byte) []byte {
+	rec := make([]byte, 4, 4+len(data))
+	binary.LittleEndian.PutUint16(rec, id)
+	binary.LittleEndian.PutUint16(rec[2:], uint16(len(data)))
+	return append(rec, data...)
+}
+
+func TestExtraFields(t *testing.T) {
+	ctx := context.Background()
+	mtime := time.Date(2017, 3, 14, 15, 9, 27, 0, time.UTC)
+	var times [8]byte
+	binary.LittleEndian.PutUint32(times[:], uint32(mtime.Unix()-3600))
+	binary.LittleEndian.PutUint32(times[4:], uint32(mtime.Unix()))
+	owner := extraRecord(ExtraUnixOwner, 1, 4, 0xe8, 3, 0, 0, 4, 0xe8, 3, 0, 0)
+	old := extraRecord(ExtraInfoZIPOld, times[:]...)
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, fh := range []*zip.FileHeader{
+		// Modes recorded by a tool that claims to be MS-DOS, but leaves the
+		// Info-ZIP owner field.
+		{Name: "owned.sh", Extra: owner, ExternalAttrs: 0100750 << 16},
+		{Name: "plain.txt", ExternalAttrs: 0100750 << 16},
+		{Name: "dated.txt", Extra: old},
+		{Name: "bad.txt", Extra: append(append([]byte(nil), owner...), 0x34, 0x12, 9)},
+	} {
+		if _, err := w.CreateHeader(fh); err != nil {
+			t.Fatalf("CreateHeader %q: %v", fh.Name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	z, err := OpenBytes(buf.Bytes())
+	if err != nil {
+		t.Fatalf("OpenBytes: %v", err)
+	}
+
+	fields, err := z.ExtraFields("owned.sh")
+	if err != nil {
+		t.Fatalf("ExtraFields: unexpected error: %v", err)
+	}
+	if len(fields) != 1 || fields[0].ID != ExtraUnixOwner || !bytes.Equal(fields[0].Data, owner[4:]) {
+		t.Errorf("ExtraFields %q: got %+v, want the owner field", "owned.sh", fields)
+	}
+	if fields, err := z.ExtraFields("bad.txt"); !errors.Is(err, zip.ErrFormat) || len(fields) != 1 {
+		t.Errorf("ExtraFields %q: got %d fields, %v; want 1 field, %v", "bad.txt", len(fields), err, zip.ErrFormat)
+	}
+	if fields, err := z.ExtraFields("plain.txt"); err != nil || len(fields) != 0 {
+		t.Errorf("ExtraFields %q: got %+v, %v; want none", "plain.txt", fields, err)
+	}
+	if _, err := z.ExtraFields("missing"); !os.IsNotExist(err) {
+		t.Errorf("ExtraFields of missing entry: got error %v, want not-exist", err)
+	}
+
+	for _, test := range []struct {
+		path string
+		mode os.FileMode
+	}{
+		{"owned.sh", 0750},
+		{"plain.txt", 0444},
+	} {
+		if got, err := z.Mode(test.path); err != nil || got != test.mode {
+			t.Errorf("Mode %q: got %v, %v; want %v", test.path, got, err, test.mode)
+		}
+	}
+	if got, err := z.ModTime("dated.txt"); err != nil || !got.Equal(mtime) {
+		t.Errorf("ModTime %q: got %v, %v; want %v", "dated.txt", got, err, mtime)
+	}
+	if fi, err := z.Stat(ctx, "dated.txt"); err != nil || !fi.ModTime().Equal(mtime) {
+		t.Errorf("Stat %q: got %v, %v; want time %v", "dated.txt", fi, err, mtime)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, fh := range []*zip.FileHeader{
+		{Name: "ok.txt"},
+		{Name: "caf\xe9.txt"},                                // code page 437, unmarked
+		{Name: "café.txt", NonUTF8: true},                    // UTF-8, unmarked
+		{Name: "bad\xff.txt", Flags: flagUTF8},               // marked, but not UTF-8
+		{Name: "./ok.txt"},                                   // a duplicate, once cleaned
+		{Name: "../escape.txt"},                              // unsafe
+		{Name: "dir/"}, {Name: "dir/", Comment: "duplicate"}, // a duplicate directory
+	} {
+		if _, err := w.CreateHeader(fh); err != nil {
+			t.Fatalf("CreateHeader %q: %v", fh.Name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	z, err := OpenBytes(buf.Bytes())
+	if err != nil {
+		t.Fatalf("OpenBytes: %v", err)
+	}
+
+	want := []struct {
+		name string
+		err  error
+	}{
+		{"café.txt", ErrNameEncoding},
+		{"bad\xff.txt", ErrNameEncoding},
+		{"./ok.txt", ErrDuplicateEntry},
+		{"../escape.txt", ErrUnsafePath},
+		{"dir/", ErrDuplicateEntry},
+	}
+	errs := z.Validate()
+	if len(errs) != len(want) {
+		t.Fatalf("Validate: got %d errors %v, want %d", len(errs), errs, len(want))
+	}
+	for i, err := range errs {
+		var ee *EntryError
+		if !errors.As(err, &ee) || ee.Name != want[i].name || !errors.Is(err, want[i].err) {
+			t.Errorf("Validate error %d: got %v, want %q: %v", i, err, want[i].name, want[i].err)
+		}
+	}
+
+	if errs := openArchive(t, "a/b.txt", "c.txt").Validate(); errs != nil {
+		t.Errorf("Validate of sound archive: got %v, want nil", errs)
+	}
+}
+
+// countingReaderAt counts the bytes read from an io.ReaderAt.
+type countingReaderAt struct {
+	r io.ReaderAt
+
+	mu sync.Mutex
+	n  int64
+}
+
+func (c *countingReaderAt) ReadAt(buf []byte, pos int64) (int, error) {
+	n, err := c.r.ReadAt(buf, pos)
+	c.mu.Lock()
+	c.n += int64(n)
+	c.mu.Unlock()
+	return n, err
+}
+
+func (c *countingReaderAt) count() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.n
+}
+
+func TestShareDecompression(t *testing.T) {
+	ctx := context.Background()
+	big := bytes.Repeat([]byte("shared contents "), 1000)
+	data := newArchiveEntries(t, entry{"big.txt", string(big)}, entry{"small.txt", "small"})
+	src := &countingReaderAt{r: bytes.NewReader(data)}
+	z, err := OpenAt(src, int64(len(data)), ShareDecompression(1<<20))
+	if err != nil {
+		t.Fatalf("OpenAt: %v", err)
+	}
+
+	// While the first reader is open, others share its contents without
+	// reading the archive again.
+	first, err := z.Open(ctx, "big.txt")
+	if err != nil {
+		t.Fatalf("Open: unexpected error: %v", err)
+	}
+	before := src.count()
+	var readers []io.ReadCloser
+	for i := 0; i < 3; i++ {
+		rc, err := z.Open(ctx, "big.txt")
+		if err != nil {
+			t.Fatalf("Open: unexpected error: %v", err)
+		}
+		readers = append(readers, rc)
+	}
+	if got := src.count(); got != before {
+		t.Errorf("Concurrent opens read %d more bytes of the archive, want 0", got-before)
+	}
+	for _, rc := range append(readers, first) {
+		if got, err := ioutil.ReadAll(rc); err != nil || !bytes.Equal(got, big) {
+			t.Errorf("Read shared reader: got %d bytes, %v; want %d", len(got), err, len(big))
+		}
+		rc.Close()
+		rc.Close()
+	}
+	if n := len(z.idx.shares); n != 0 {
+		t.Errorf("After closing every reader: got %d shares, want 0", n)
+	}
+
+	// Once the readers are closed, the next Open reads the archive again.
+	before = src.count()
+	if got, err := z.ReadFile(ctx, "big.txt"); err != nil || !bytes.Equal(got, big) {
+		t.Errorf("ReadFile: got %d bytes, %v; want %d", len(got), err, len(big))
+	}
+	if src.count() == before {
+		t.Error("ReadFile after closing the readers did not read the archive")
+	}
+
+	// Entries larger than the limit are not shared.
+	z, err = OpenAt(src, int64(len(data)), ShareDecompression(100))
+	if err != nil {
+		t.Fatalf("OpenAt: %v", err)
+	}
+	rc, err := z.Open(ctx, "big.txt")
+	if err != nil {
+		t.Fatalf("Open: unexpected error: %v", err)
+	}
+	defer rc.Close()
+	if _, ok := rc.(*sharedReader); ok {
+		t.Error("Open of large entry: got a shared reader")
+	}
+	if _, err := OpenAt(src, int64(len(data)), ShareDecompression(0)); err == nil {
+		t.Error("OpenAt with ShareDecompression(0): got nil error, want an error")
+	}
+}
+
+func TestCopyFile(t *testing.T) {
+	ctx := context.Background()
+	big := strings.Repeat("0123456789", 10000)
+	z, err := OpenBytes(newArchiveEntries(t, entry{"a/big.txt", big}, entry{"b.txt", "bee"}))
+	if err != nil {
+		t.Fatalf("OpenBytes: %v", err)
+	}
+	for _, want := range []entry{{"a/big.txt", big}, {"b.txt", "bee"}} {
+		var buf bytes.Buffer
+		n, err := z.CopyFile(ctx, &buf, want.name)
+		if err != nil || n != int64(len(want.data)) || buf.String() != want.data {
+			t.Errorf("CopyFile %q: got %d bytes, %v; want %d", want.name, n, err, len(want.data))
+		}
+	}
+	if _, err := z.CopyFile(ctx, ioutil.Discard, "missing"); !os.IsNotExist(err) {
+		t.Errorf("CopyFile of missing file: got error %v, want not-exist", err)
+	}
+
+	// Cancellation stops the copy part way.
+	cctx, cancel := context.WithCancel(ctx)
+	w := &cancelingWriter{cancel: cancel}
+	if n, err := z.CopyFile(cctx, w, "a/big.txt"); err != context.Canceled || n >= int64(len(big)) {
+		t.Errorf("CopyFile with cancellation: got %d bytes, %v; want fewer than %d, %v", n, err, len(big), context.Canceled)
+	}
+}
+
+// cancelingWriter cancels a context after its first write.
+type cancelingWriter struct{ cancel func() }
+
+func (w *cancelingWriter) Write(buf []byte) (int, error) {
+	w.cancel()
+	return len(buf), nil
+}
+
+func TestKind(t *testing.T) {
+	ctx := context.Background()
+	inner := newArchive(t, "x/y.txt")
+	z, err := OpenBytes(newArchiveEntries(t,
+		entry{"file.txt", "f"},
+		entry{"dir/", ""},
+		entry{"dir/a.txt", "a"},
+		entry{"both", "a file"},
+		entry{"both/", ""},
+		entry{"implied", "a file"},
+		entry{"implied/child.txt", "c"},
+		entry{"inner.zip", string(inner)},
+	))
+	if err != nil {
+		t.Fatalf("OpenBytes: %v", err)
+	}
+	for _, test := range []struct {
+		path string
+		want Kind
+	}{
+		{"", KindDir},
+		{"file.txt", KindFile},
+		{"dir", KindDir},
+		{"dir/", KindDir},
+		{"dir/a.txt", KindFile},
+		{"both", KindBoth},
+		{"both/", KindBoth},
+		{"implied", KindBoth},
+		{"missing", KindNone},
+		{"dir/missing", KindNone},
+		{"inner.zip!/x", KindDir},
+		{"inner.zip!/x/y.txt", KindFile},
+		{"inner.zip!/missing", KindNone},
+	} {
+		if got, err := z.Kind(ctx, test.path); err != nil || got != test.want {
+			t.Errorf("Kind %q: got %v, %v; want %v", test.path, got, err, test.want)
+		}
+	}
+	if _, err := z.Kind(ctx, "../escape"); !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("Kind of escaping path: got error %v, want %v", err, os.ErrInvalid)
+	}
+	if got := Kind(7).String(); got != "Kind(7)" {
+		t.Errorf("Kind(7).String(): got %q, want %q", got, "Kind(7)")
+	}
+}
+
+func TestFindByDigest(t *testing.T) {
+	ctx := context.Background()
+	z, err := OpenBytes(newArchiveEntries(t,
+		entry{"a.txt", "same"},
+		entry{"b/c.txt", "same"},
+		entry{"d.txt", "different"},
+		entry{"e/", ""},
+	))
+	if err != nil {
+		t.Fatalf("OpenBytes: %v", err)
+	}
+	if got, want := z.FindByCRC(crc32.ChecksumIEEE([]byte("same"))), []string{"a.txt", "b/c.txt"}; !equalStrings(got, want) {
+		t.Errorf("FindByCRC: got %q, want %q", got, want)
+	}
+	if got := z.FindByCRC(crc32.ChecksumIEEE([]byte("none"))); len(got) != 0 {
+		t.Errorf("FindByCRC of absent contents: got %q, want none", got)
+	}
+
+	sum := sha256.Sum256([]byte("different"))
+	for i := 0; i < 2; i++ { // the second search uses the cached digests
+		got, err := z.FindBySHA256(ctx, hex.EncodeToString(sum[:]))
+		if err != nil || !equalStrings(got, []string{"d.txt"}) {
+			t.Errorf("FindBySHA256: got %q, %v; want %q", got, err, []string{"d.txt"})
+		}
+	}
+	if n := len(z.idx.digests); n != 3 {
+		t.Errorf("FindBySHA256 cached %d digests, want 3", n)
+	}
+	sub, err := z.Sub("b")
+	if err != nil {
+		t.Fatalf("Sub: %v", err)
+	}
+	sum = sha256.Sum256([]byte("same"))
+	if got, err := sub.FindBySHA256(ctx, hex.EncodeToString(sum[:])); err != nil || !equalStrings(got, []string{"c.txt"}) {
+		t.Errorf("FindBySHA256 in Sub: got %q, %v; want %q", got, err, []string{"c.txt"})
+	}
+	if _, err := z.FindBySHA256(ctx, "not hex"); !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("FindBySHA256 of invalid digest: got error %v, want %v", err, os.ErrInvalid)
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	data := newArchive(t, "a/b.txt", "c.txt")
+	prefixed := append([]byte("#!/bin/sh\nexit 0\n"), data...)
+	for _, test := range []struct {
+		desc string
+		data []byte
+	}{
+		{"archive", data},
+		{"self-extracting archive", prefixed},
+	} {
+		z, err := Open(bytes.NewReader(test.data))
+		if err != nil {
+			t.Fatalf("Open %s: %v", test.desc, err)
+		}
+		if sub, err := z.Sub("a"); err != nil {
+			t.Fatalf("Sub: %v", err)
+		} else {
+			z = sub
+		}
+		var buf bytes.Buffer
+		if n, err := z.WriteTo(&buf); err != nil || n != int64(len(test.data)) || !bytes.Equal(buf.Bytes(), test.data) {
+			t.Errorf("WriteTo of %s: got %d bytes, %v; want the %d bytes read", test.desc, n, err, len(test.data))
+		}
+	}
+
+	split := splitArchive(t, data, 2)
+	z, err := OpenVolumes([]io.ReaderAt{bytes.NewReader(split[0]), bytes.NewReader(split[1])},
+		[]int64{int64(len(split[0])), int64(len(split[1]))})
+	if err != nil {
+		t.Fatalf("OpenVolumes: %v", err)
+	}
+	if _, err := z.WriteTo(ioutil.Discard); err != ErrNoSource {
+		t.Errorf("WriteTo of rewritten volumes: got error %v, want %v", err, ErrNoSource)
+	}
+	rc, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	if _, err := (FS{Archive: rc}).WriteTo(ioutil.Discard); err != ErrNoSource {
+		t.Errorf("WriteTo of literal FS: got error %v, want %v", err, ErrNoSource)
+	}
+}
+
+func TestGlobBraces(t *testing.T) {
+	z := openArchive(t, "cmd/a/main.go", "internal/b/b.go", "internal/b/b.txt", "other/c.go")
+	got, err := z.GlobBraces(context.Background(), "{cmd,internal}/**/*.go")
+	if want := []string{"cmd/a/main.go", "internal/b/b.go"}; err != nil || !equalStrings(got, want) {
+		t.Errorf("GlobBraces: got %q, %v; want %q", got, err, want)
+	}
+}
+
+func TestCaseInsensitive(t *testing.T) {
+	ctx := context.Background()
+	var log testLogger
+	z, err := OpenBytes(newArchiveEntries(t,
+		entry{"Docs/README.md", "readme"},
+		entry{"Docs/Guide/Intro.txt", "intro"},
+		entry{"src/Main.go", "first"},
+		entry{"src/main.go", "second"},
+	), CaseInsensitive(), LogTo(&log))
+	if err != nil {
+		t.Fatalf("OpenBytes: %v", err)
+	}
+	for _, path := range []string{"docs/readme.md", "DOCS/ReadMe.MD", "Docs/README.md"} {
+		if got, err := z.ReadFile(ctx, path); err != nil || string(got) != "readme" {
+			t.Errorf("ReadFile %q: got %q, %v; want %q", path, got, err, "readme")
+		}
+	}
+	if fi, err := z.Stat(ctx, "docs/guide"); err != nil || !fi.IsDir() {
+		t.Errorf("Stat %q: got %v, %v; want a directory", "docs/guide", fi, err)
+	}
+	if got, err := z.ReadFile(ctx, "SRC/MAIN.GO"); err != nil || string(got) != "second" {
+		t.Errorf("ReadFile %q: got %q, %v; want %q", "SRC/MAIN.GO", got, err, "second")
+	}
+	if len(log) == 0 || !strings.Contains(log[0], "differ only in case") {
+		t.Errorf("LogTo: got %q, want a case collision", log)
+	}
+
+	got, err := z.Glob(ctx, "docs/*/*.TXT")
+	if want := []string{"Docs/Guide/Intro.txt"}; err != nil || !equalStrings(got, want) {
+		t.Errorf("Glob: got %q, %v; want %q", got, err, want)
+	}
+
+	sub, err := z.Sub("DOCS")
+	if err != nil {
+		t.Fatalf("Sub: %v", err)
+	}
+	fis, err := sub.ReadDir(ctx, "GUIDE")
+	if err != nil || len(fis) != 1 || fis[0].Name() != "Intro.txt" {
+		t.Errorf("ReadDir: got %v, %v; want [Intro.txt]", fis, err)
+	}
+
+	errs := z.Validate()
+	if len(errs) != 1 || !errors.Is(errs[0], ErrDuplicateEntry) || !strings.Contains(errs[0].Error(), "only in case") {
+		t.Errorf("Validate: got %v, want a case collision", errs)
+	}
+
+	// Without the option, case matters.
+	z, err = OpenBytes(newArchiveEntries(t, entry{"README.md", "readme"}))
+	if err != nil {
+		t.Fatalf("OpenBytes: %v", err)
+	}
+	if _, err := z.Stat(ctx, "readme.md"); !os.IsNotExist(err) {
+		t.Errorf("Stat %q: got error %v, want not exist", "readme.md", err)
+	}
+}
+
+func TestRoots(t *testing.T) {
+	z := openArchive(t, "root/units/a", "root/files/b", "other/", "README", "root/")
+	if got, want := z.Roots(), []string{"README", "other", "root"}; !equalStrings(got, want) {
+		t.Errorf("Roots: got %q, want %q", got, want)
+	}
+	sub, err := z.Sub("root")
+	if err != nil {
+		t.Fatalf("Sub: %v", err)
+	}
+	if got, want := sub.Roots(), []string{"files", "units"}; !equalStrings(got, want) {
+		t.Errorf("Roots of %q: got %q, want %q", "root", got, want)
+	}
+	if got := openArchive(t).Roots(); len(got) != 0 {
+		t.Errorf("Roots of empty archive: got %q, want none", got)
+	}
+}
+
+func TestLazyDirectory(t *testing.T) {
+	ctx := context.Background()
+	data := newArchiveEntries(t,
+		entry{"a/one.txt", "one"},
+		entry{"a/two.txt", "first"},
+		entry{"b/", ""},
+		entry{"a/two.txt", "second"},
+	)
+	z, err := OpenBytes(data, LazyDirectory())
+	if err != nil {
+		t.Fatalf("OpenBytes: %v", err)
+	}
+	if z.Archive != nil {
+		t.Error("Archive: got non-nil, want nil")
+	}
+	loaded := func() bool { return z.lazy.loaded != 0 }
+
+	for _, test := range []struct{ path, want string }{
+		{"a/one.txt", "one"},
+		{"a/two.txt", "second"},
+		{"./a/one.txt", "one"},
+	} {
+		if got, err := z.ReadFile(ctx, test.path); err != nil || string(got) != test.want {
+			t.Errorf("ReadFile %q: got %q, %v; want %q", test.path, got, err, test.want)
+		}
+	}
+	if _, err := z.Stat(ctx, "a/missing"); !os.IsNotExist(err) {
+		t.Errorf("Stat %q: got error %v, want not exist", "a/missing", err)
+	}
+	if fi, err := z.Stat(ctx, "b"); err != nil || !fi.IsDir() {
+		t.Errorf("Stat %q: got %v, %v; want a directory", "b", fi, err)
+	}
+	if loaded() {
+		t.Error("Directory read before any method needed all of it")
+	}
+
+	if fi, err := z.Stat(ctx, "a"); err != nil || !fi.IsDir() {
+		t.Errorf("Stat %q: got %v, %v; want a directory", "a", fi, err)
+	}
+	if !loaded() {
+		t.Error("Directory not read for an implicit directory")
+	}
+	got, err := z.Glob(ctx, "a/*")
+	if want := []string{"a/one.txt", "a/two.txt"}; err != nil || !equalStrings(got, want) {
+		t.Errorf("Glob: got %q, %v; want %q", got, err, want)
+	}
+	if got, err := z.ReadFile(ctx, "a/two.txt"); err != nil || string(got) != "second" {
+		t.Errorf("ReadFile after loading: got %q, %v; want %q", got, err, "second")
+	}
+
+	// Archives following other data are read in full.
+	z, err = OpenBytes(append([]byte("prefix"), data...), LazyDirectory())
+	if err != nil {
+		t.Fatalf("OpenBytes with prefix: %v", err)
+	}
+	if z.Archive == nil || z.lazy != nil {
+		t.Error("Archive with prefix: got a lazy directory, want it read in full")
+	}
+
+	if _, err := OpenBytes(data, LazyDirectory(), MaxEntries(3)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("OpenBytes with MaxEntries: got error %v, want %v", err, ErrTooLarge)
+	}
+}
+
+func TestExclude(t *testing.T) {
+	ctx := context.Background()
+	z := openArchive(t, "src/a.go", "src/a_gen.go", "gen/", "gen/out.txt", "README")
+	generated := func(name string) bool {
+		return strings.HasSuffix(name, "_gen.go") || name == "gen" || strings.HasPrefix(name, "gen/")
+	}
+	v := z.Exclude(generated)
+	for _, path := range []string{"src/a_gen.go", "gen", "gen/out.txt"} {
+		if _, err := v.Stat(ctx, path); !os.IsNotExist(err) {
+			t.Errorf("Stat %q: got error %v, want not exist", path, err)
+		}
+		if _, err := v.Open(ctx, path); !os.IsNotExist(err) {
+			t.Errorf("Open %q: got error %v, want not exist", path, err)
+		}
+	}
+	if _, err := v.ReadFile(ctx, "src/a.go"); err != nil {
+		t.Errorf("ReadFile %q: %v", "src/a.go", err)
+	}
+	got, err := v.Glob(ctx, "*/*")
+	if want := []string{"src/a.go"}; err != nil || !equalStrings(got, want) {
+		t.Errorf("Glob: got %q, %v; want %q", got, err, want)
+	}
+	var names []string
+	for _, e := range v.Entries() {
+		names = append(names, e.Name)
+	}
+	if want := []string{"src/a.go", "README"}; !equalStrings(names, want) {
+		t.Errorf("Entries: got %q, want %q", names, want)
+	}
+
+	// The original is unchanged, and names are relative to the root.
+	if _, err := z.Stat(ctx, "gen/out.txt"); err != nil {
+		t.Errorf("Stat of original: %v", err)
+	}
+	sub, err := z.Sub("src")
+	if err != nil {
+		t.Fatalf("Sub: %v", err)
+	}
+	sv := sub.Exclude(func(name string) bool { return name == "a.go" })
+	if got, err := sv.Glob(ctx, "*"); err != nil || !equalStrings(got, []string{"a_gen.go"}) {
+		t.Errorf("Glob of Sub: got %q, %v; want %q", got, err, []string{"a_gen.go"})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	open := func(entries ...entry) FS {
+		z, err := OpenBytes(newArchiveEntries(t, entries...))
+		if err != nil {
+			t.Fatalf("OpenBytes: %v", err)
+		}
+		return z
+	}
+	a := open(entry{"same.txt", "same"}, entry{"gone.txt", "gone"}, entry{"edit.txt", "abcd"}, entry{"grow.txt", "x"}, entry{"dir/", ""})
+	b := open(entry{"dir/", ""}, entry{"grow.txt", "xyz"}, entry{"edit.txt", "abce"}, entry{"new.txt", "new"}, entry{"same.txt", "same"})
+	diffs, err := Diff(a, b)
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	var got []string
+	for _, d := range diffs {
+		got = append(got, d.Change.String()+" "+d.Name)
+	}
+	want := []string{"Changed edit.txt", "Removed gone.txt", "Changed grow.txt", "Added new.txt"}
+	if !equalStrings(got, want) {
+		t.Errorf("Diff: got %q, want %q", got, want)
+	}
+	if len(diffs) == 4 && (diffs[2].A.Size != 1 || diffs[2].B.Size != 3 || diffs[3].A.Name != "") {
+		t.Errorf("Diff: got entries %+v", diffs)
+	}
+	if diffs, err := Diff(a, a); err != nil || len(diffs) != 0 {
+		t.Errorf("Diff with itself: got %v, %v; want none", diffs, err)
+	}
+
+	// WinZip AE-2 entries record no CRC-32 to compare; the first of them by
+	// name is reported.
+	enc, err := OpenBytes(newEncryptedArchive(t, map[string]string{"aes192.txt": "secret", "aes256.txt": "secret"}))
+	if err != nil {
+		t.Fatalf("OpenBytes: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		_, err := Diff(enc, enc)
+		var ee *EntryError
+		if !errors.Is(err, ErrNoCRC) || !errors.As(err, &ee) || ee.Name != "aes192.txt" {
+			t.Fatalf("Diff of AE-2 entries: got error %v, want %v for %q", err, ErrNoCRC, "aes192.txt")
+		}
+	}
+
+	// Entries are checked as stored, not looked up by their relative names.
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	fh, raw := aesEntry(t, "dir//aes256.txt", 2, 3, zip.Deflate, []byte("secret"))
+	fw, err := w.CreateRaw(fh)
+	if err != nil {
+		t.Fatalf("CreateRaw: %v", err)
+	}
+	fw.Write(raw)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	enc, err = OpenBytes(buf.Bytes())
+	if err != nil {
+		t.Fatalf("OpenBytes: %v", err)
+	}
+	sub, err := enc.Sub("dir")
+	if err != nil {
+		t.Fatalf("Sub: %v", err)
+	}
+	if _, err := Diff(sub, sub); !errors.Is(err, ErrNoCRC) {
+		t.Errorf("Diff of AE-2 entries with non-canonical names: got error %v, want %v", err, ErrNoCRC)
+	}
+}
+
+func TestOpenSingleGzip(t *testing.T) {
+	ctx := context.Background()
+	mtime := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	gw.ModTime = mtime
+	gw.Write([]byte("package main\n"))
+	if err := gw.Close(); err != nil {
+		t.Fatalf("gzip Close: %v", err)
+	}
+	data := buf.Bytes()
+
+	z, err := OpenSingleGzip(bytes.NewReader(data), "src/main.go")
+	if err != nil {
+		t.Fatalf("OpenSingleGzip: %v", err)
+	}
+	if got, err := z.ReadFile(ctx, "src/main.go"); err != nil || string(got) != "package main\n" {
+		t.Errorf("ReadFile: got %q, %v; want %q", got, err, "package main\n")
+	}
+	if fi, err := z.Stat(ctx, "src/main.go"); err != nil || fi.Size() != 13 || !fi.ModTime().Equal(mtime) {
+		t.Errorf("Stat: got %v, %v; want size 13 modified %v", fi, err, mtime)
+	}
+	got, err := z.Glob(ctx, "*/*.go")
+	if want := []string{"src/main.go"}; err != nil || !equalStrings(got, want) {
+		t.Errorf("Glob: got %q, %v; want %q", got, err, want)
+	}
+
+	for _, name := range []string{"", "../main.go", "/main.go", "dir/"} {
+		if _, err := OpenSingleGzip(bytes.NewReader(data), name); !errors.Is(err, ErrUnsafePath) {
+			t.Errorf("OpenSingleGzip %q: got error %v, want %v", name, err, ErrUnsafePath)
+		}
+	}
+	if _, err := OpenSingleGzip(bytes.NewReader(data[:len(data)-4]), "main.go"); err == nil {
+		t.Error("OpenSingleGzip of truncated stream: got no error")
+	}
+	if _, err := OpenSingleGzip(bytes.NewReader(data), "main.go", MaxUncompressedBytes(5)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("OpenSingleGzip with MaxUncompressedBytes: got error %v, want %v", err, ErrTooLarge)
+	}
+	if _, err := OpenSingleGzip(bytes.NewReader(data), "main.go", MaxUncompressedBytes(13)); err != nil {
+		t.Errorf("OpenSingleGzip with MaxUncompressedBytes at the size: unexpected error: %v", err)
+	}
+}
+
+func TestBufferSize(t *testing.T) {
+	ctx := context.Background()
+	data := newArchiveEntries(t, entry{"a.txt", strings.Repeat("abc", 100)})
+	for _, n := range []int{0, -1} {
+		if _, err := OpenBytes(data, BufferSize(n)); err == nil {
+			t.Errorf("BufferSize(%d): got no error", n)
+		}
+	}
+	z, err := OpenBytes(data, BufferSize(7))
+	if err != nil {
+		t.Fatalf("OpenBytes: %v", err)
+	}
+	var buf bytes.Buffer
+	if n, err := z.CopyFile(ctx, &buf, "a.txt"); err != nil || n != 300 || buf.String() != strings.Repeat("abc", 100) {
+		t.Errorf("CopyFile: got %d, %v; want 300 bytes", n, err)
+	}
+}
+
+func TestZstd(t *testing.T) {
+	ctx := context.Background()
+	const contents = "compressed, as it were"
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	fw, err := w.CreateRaw(&zip.FileHeader{
+		Name:               "a.zst.txt",
+		Method:             methodZstd,
+		CRC32:              crc32.ChecksumIEEE([]byte(contents)),
+		CompressedSize64:   uint64(len(contents)),
+		UncompressedSize64: uint64(len(contents)),
+	})
+	if err != nil {
+		t.Fatalf("CreateRaw: %v", err)
+	}
+	fw.Write([]byte(contents))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	data := buf.Bytes()
+
+	if newZstdReader == nil {
+		if _, err := OpenBytes(data, Zstd()); !errors.Is(err, zip.ErrAlgorithm) {
+			t.Errorf("Zstd without the build tag: got error %v, want %v", err, zip.ErrAlgorithm)
+		}
+	}
+	// Check the wiring with a decompressor that passes the data through.
+	defer func(old zip.Decompressor) { newZstdReader = old }(newZstdReader)
+	newZstdReader = ioutil.NopCloser
+
+	z, err := OpenBytes(data)
+	if err != nil {
+		t.Fatalf("OpenBytes: %v", err)
+	}
+	if _, err := z.ReadFile(ctx, "a.zst.txt"); !errors.Is(err, zip.ErrAlgorithm) {
+		t.Errorf("ReadFile without Zstd: got error %v, want %v", err, zip.ErrAlgorithm)
+	}
+	for _, opts := range [][]Option{{Zstd()}, {Zstd(), LazyDirectory()}} {
+		z, err := OpenBytes(data, opts...)
+		if err != nil {
+			t.Fatalf("OpenBytes: %v", err)
+		}
+		if got, err := z.ReadFile(ctx, "a.zst.txt"); err != nil || string(got) != contents {
+			t.Errorf("ReadFile: got %q, %v; want %q", got, err, contents)
+		}
+	}
+}
+
+func TestBzip2(t *testing.T) {
+	const contents = "bzip2 is in the standard library\n"
+	compressed := []byte("\x42\x5a\x68\x39\x31\x41\x59\x26\x53\x59\xee\xf1\x20\x5a\x00\x00\x05\xd9\x80\x00\x10\x40\x00\x10" +
+		"\x00\x36\x65\x5c\x30\x20\x00\x22\x26\x4d\x34\xd3\x46\x69\x0a\x1a\x69\x80\x01\x4b\xb7\xf4\x85\x15" +
+		"\x08\xd4\xc2\x6c\xc9\xc1\xda\x12\x64\x1c\x6b\xe2\xee\x48\xa7\x0a\x12\x1d\xde\x24\x0b\x40")
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	fw, err := w.CreateRaw(&zip.FileHeader{
+		Name:               "a.txt",
+		Method:             methodBzip2,
+		CRC32:              crc32.ChecksumIEEE([]byte(contents)),
+		CompressedSize64:   uint64(len(compressed)),
+		UncompressedSize64: uint64(len(contents)),
+	})
+	if err != nil {
+		t.Fatalf("CreateRaw: %v", err)
+	}
+	fw.Write(compressed)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	for _, opts := range [][]Option{nil, {VerifyChecksums()}, {LazyDirectory()}} {
+		z, err := OpenBytes(buf.Bytes(), opts...)
+		if err != nil {
+			t.Fatalf("OpenBytes: %v", err)
+		}
+		if got, err := z.ReadFile(context.Background(), "a.txt"); err != nil || string(got) != contents {
+			t.Errorf("ReadFile: got %q, %v; want %q", got, err, contents)
+		}
+	}
+}
+
+func TestRequireSupportedMethods(t *testing.T) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, fh := range []*zip.FileHeader{
+		{Name: "stored.txt", Method: zip.Store},
+		{Name: "b.lzma", Method: 14},
+		{Name: "a.zst", Method: methodZstd},
+		{Name: "deflated.txt", Method: zip.Deflate},
+		{Name: "bzip2.txt", Method: methodBzip2},
+	} {
+		if _, err := w.CreateRaw(fh); err != nil {
+			t.Fatalf("CreateRaw %q: %v", fh.Name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	data := buf.Bytes()
+
+	if _, err := OpenBytes(data); err != nil {
+		t.Errorf("OpenBytes without the option: unexpected error: %v", err)
+	}
+	_, err := OpenBytes(data, RequireSupportedMethods())
+	var verr ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("OpenBytes: got error %v, want a ValidationError", err)
+	}
+	var names []string
+	for _, e := range verr {
+		if !errors.Is(e, zip.ErrAlgorithm) {
+			t.Errorf("Entry %q: got error %v, want %v", e.Name, e.Err, zip.ErrAlgorithm)
+		}
+		names = append(names, e.Name)
+	}
+	if want := []string{"a.zst", "b.lzma"}; !equalStrings(names, want) {
+		t.Errorf("OpenBytes: got unsupported entries %q, want %q", names, want)
+	}
+
+	// AES entries are checked by the method of their plaintext.
+	if _, err := OpenBytes(newEncryptedArchive(t, nil), RequireSupportedMethods()); err != nil {
+		t.Errorf("OpenBytes of encrypted archive: unexpected error: %v", err)
+	}
+}
+
+func TestOpenSpooled(t *testing.T) {
+	ctx := context.Background()
+	data := newArchive(t, "a/b.txt", "c.txt")
+	// Hide the methods of the reader that would allow seeking.
+	z, cleanup, err := OpenSpooled(struct{ io.Reader }{bytes.NewReader(data)})
+	if err != nil {
+		t.Fatalf("OpenSpooled: %v", err)
+	}
+	spool := z.src.(*os.File).Name()
+	if got, err := z.ReadFile(ctx, "a/b.txt"); err != nil || string(got) != "contents of a/b.txt" {
+		t.Errorf("ReadFile: got %q, %v; want %q", got, err, "contents of a/b.txt")
+	}
+	if _, err := os.Stat(spool); err != nil {
+		t.Errorf("Stat of spool file while open: %v", err)
+	}
+	if err := cleanup(); err != nil {
+		t.Errorf("cleanup: %v", err)
+	}
+	if _, err := os.Stat(spool); !os.IsNotExist(err) {
+		t.Errorf("Stat of spool file after cleanup: got error %v, want not exist", err)
+	}
+	if err := z.Close(); err != nil {
+		t.Errorf("Close after cleanup: %v", err)
+	}
+
+	if _, _, err := OpenSpooled(strings.NewReader("not a zip archive")); err == nil {
+		t.Error("OpenSpooled of invalid archive: got no error")
+	}
+}
+
+func TestHasEntry(t *testing.T) {
+	z, err := OpenBytes(newArchiveEntries(t,
+		entry{"file.txt", "f"},
+		entry{"dir/", ""},
+		entry{"dir/a.txt", "a"},
+		entry{"implied/b.txt", "b"},
+		entry{"both", "a file"},
+		entry{"both/", ""},
+	))
+	if err != nil {
+		t.Fatalf("OpenBytes: %v", err)
+	}
+	for _, test := range []struct {
+		path      string
+		file, dir bool
+	}{
+		{"file.txt", true, false},
+		{"dir", false, true},
+		{"dir/", false, true},
+		{"dir/a.txt", true, false},
+		{"implied", false, false},
+		{"both", true, true},
+		{".", false, false},
+		{"missing", false, false},
+		{"../escape", false, false},
+	} {
+		if got := z.HasFileEntry(test.path); got != test.file {
+			t.Errorf("HasFileEntry %q: got %v, want %v", test.path, got, test.file)
+		}
+		if got := z.HasDirEntry(test.path); got != test.dir {
+			t.Errorf("HasDirEntry %q: got %v, want %v", test.path, got, test.dir)
+		}
+	}
+}
+
+func TestOpenIndex(t *testing.T) {
+	ctx := context.Background()
+	z, err := OpenBytes(newArchiveEntries(t,
+		entry{"a.txt", "first"},
+		entry{"dir/b.txt", "b"},
+		entry{"a.txt", "second"},
+	))
+	if err != nil {
+		t.Fatalf("OpenBytes: %v", err)
+	}
+	sub, err := z.Sub("dir")
+	if err != nil {
+		t.Fatalf("Sub: %v", err)
+	}
+	for i, want := range []entry{{"a.txt", "first"}, {"dir/b.txt", "b"}, {"a.txt", "second"}} {
+		if got, err := sub.NameAt(i); err != nil || got != want.name {
+			t.Errorf("NameAt(%d): got %q, %v; want %q", i, got, err, want.name)
+		}
+		rc, err := sub.OpenIndex(ctx, i)
+		if err != nil {
+			t.Errorf("OpenIndex(%d): %v", i, err)
+			continue
+		}
+		got, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil || string(got) != want.data {
+			t.Errorf("OpenIndex(%d): got %q, %v; want %q", i, got, err, want.data)
+		}
+	}
+	for _, i := range []int{-1, 3} {
+		if _, err := z.NameAt(i); !errors.Is(err, os.ErrInvalid) {
+			t.Errorf("NameAt(%d): got error %v, want %v", i, err, os.ErrInvalid)
+		}
+		if _, err := z.OpenIndex(ctx, i); !errors.Is(err, os.ErrInvalid) {
+			t.Errorf("OpenIndex(%d): got error %v, want %v", i, err, os.ErrInvalid)
+		}
+	}
+}
+
+func TestForEach(t *testing.T) {
+	z := openArchive(t, "a.txt", "dir/b.txt", "dir/c.txt", "d.txt")
+	sub, err := z.Sub("dir")
+	if err != nil {
+		t.Fatalf("Sub: %v", err)
+	}
+	var names []string
+	if err := sub.ForEach(context.Background(), func(e EntryInfo) error {
+		names = append(names, e.Name)
+		return nil
+	}); err != nil || !equalStrings(names, []string{"b.txt", "c.txt"}) {
+		t.Errorf("ForEach: got %q, %v; want %q", names, err, []string{"b.txt", "c.txt"})
+	}
+
+	names = nil
+	if err := z.ForEach(context.Background(), func(e EntryInfo) error {
+		names = append(names, e.Name)
+		if e.Name == "dir/b.txt" {
+			return ErrStop
+		}
+		return nil
+	}); err != nil || !equalStrings(names, []string{"a.txt", "dir/b.txt"}) {
+		t.Errorf("ForEach with ErrStop: got %q, %v; want %q", names, err, []string{"a.txt", "dir/b.txt"})
+	}
+
+	errFail := errors.New("fail")
+	if err := z.ForEach(context.Background(), func(EntryInfo) error { return errFail }); err != errFail {
+		t.Errorf("ForEach with error: got %v, want %v", err, errFail)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	if err := z.ForEach(ctx, func(EntryInfo) error {
+		calls++
+		cancel()
+		return nil
+	}); err != context.Canceled || calls != 1 {
+		t.Errorf("ForEach with cancellation: got %v after %d calls, want %v after 1", err, calls, context.Canceled)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	fingerprint := func(entries ...entry) string {
+		z, err := OpenBytes(newArchiveEntries(t, entries...))
+		if err != nil {
+			t.Fatalf("OpenBytes: %v", err)
+		}
+		fp, err := z.Fingerprint()
+		if err != nil {
+			t.Fatalf("Fingerprint: %v", err)
+		}
+		return fp
+	}
+	base := fingerprint(entry{"a.txt", "a"}, entry{"dir/b.txt", "b"})
+	if len(base) != 2*sha256.Size {
+		t.Errorf("Fingerprint: got %q, want %d hex digits", base, 2*sha256.Size)
+	}
+	for _, test := range []struct {
+		desc    string
+		entries []entry
+		same    bool
+	}{
+		{"reordered", []entry{{"dir/b.txt", "b"}, {"a.txt", "a"}}, true},
+		{"with directory entries", []entry{{"dir/", ""}, {"a.txt", "a"}, {"dir/b.txt", "b"}}, true},
+		{"unclean names", []entry{{"./a.txt", "a"}, {"dir//b.txt", "b"}}, true},
+		{"changed contents", []entry{{"a.txt", "A"}, {"dir/b.txt", "b"}}, false},
+		{"renamed", []entry{{"a.txt", "a"}, {"dir/c.txt", "b"}}, false},
+		{"added", []entry{{"a.txt", "a"}, {"dir/b.txt", "b"}, {"c.txt", ""}}, false},
+		{"removed", []entry{{"a.txt", "a"}}, false},
+	} {
+		if got := fingerprint(test.entries...); (got == base) != test.same {
+			t.Errorf("Fingerprint %s: got %q, base %q; want same %v", test.desc, got, base, test.same)
+		}
+	}
+
+	enc, err := OpenBytes(newEncryptedArchive(t, map[string]string{"aes256.txt": "secret"}))
+	if err != nil {
+		t.Fatalf("OpenBytes: %v", err)
+	}
+	if _, err := enc.Fingerprint(); !errors.Is(err, ErrNoCRC) {
+		t.Errorf("Fingerprint of AE-2 entries: got error %v, want %v", err, ErrNoCRC)
+	}
+}
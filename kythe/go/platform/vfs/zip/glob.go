@@ -0,0 +1,97 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// minShard is the smallest number of entries that Glob gives to one goroutine.
+// Below about this size, matching is quicker than starting the goroutine.
+const minShard = 4096
+
+// GlobParallelism returns an Option that lets Glob, and the other methods that
+// select entries by name, such as Match, scan the entries of large archives
+// using up to n goroutines, each scanning a contiguous range of the entries.
+// If n is 0, the limit is runtime.GOMAXPROCS(0).  The results are the same as
+// for a serial scan.  By default, Glob uses a single goroutine.
+func GlobParallelism(n int) Option {
+	return func(z *FS) error {
+		if n < 0 {
+			return fmt.Errorf("invalid parallelism %d", n)
+		} else if n == 0 {
+			n = runtime.GOMAXPROCS(0)
+		}
+		z.globWorkers = n
+		return nil
+	}
+}
+
+// scanParallel is as scan, but divides the work among the goroutines allowed
+// for z.  The first error encountered stops the remaining goroutines.
+func (z FS) scanParallel(ctx context.Context, entries []*zip.File, rel func(*zip.File) (string, bool), m matcher, p *progress) ([]string, error) {
+	n := z.globWorkers
+	if max := len(entries) / minShard; n > max {
+		n = max
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	shards := make([][]string, n)
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		first error // the error that stopped the scan, if any
+	)
+	for i := 0; i < n; i++ {
+		lo, hi := i*len(entries)/n, (i+1)*len(entries)/n
+		wg.Add(1)
+		go func(i int, entries []*zip.File) {
+			defer wg.Done()
+			names, err := scan(ctx, entries, rel, m, p)
+			if err != nil {
+				mu.Lock()
+				if first == nil {
+					first = err
+				}
+				mu.Unlock()
+				cancel()
+				return
+			}
+			shards[i] = names
+		}(i, entries[lo:hi])
+	}
+	wg.Wait()
+	if first != nil {
+		return nil, first
+	}
+
+	var total int
+	for _, shard := range shards {
+		total += len(shard)
+	}
+	names := make([]string, 0, total)
+	for _, shard := range shards {
+		names = append(names, shard...)
+	}
+	return names, nil
+}
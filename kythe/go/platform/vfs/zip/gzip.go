@@ -0,0 +1,76 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+)
+
+// OpenSingleGzip returns a read-only virtual file system (vfs.Reader) holding
+// a single file with the given name, whose contents are the decompressed gzip
+// stream read from r, so that a lone file such as "file.go.gz" can be read
+// through the same interface as an archive.  The file has the modification
+// time recorded in the gzip header, if any.  The whole stream is decompressed
+// into memory before OpenSingleGzip returns, and any corruption of it is
+// reported then; with the MaxUncompressedBytes option, a stream decompressing
+// to more than the limit fails with an error wrapping ErrTooLarge.  The name must be a relative path within the root, such as
+// "file.go" or "src/file.go"; otherwise the error wraps ErrUnsafePath.
+func OpenSingleGzip(r io.Reader, name string, opts ...Option) (FS, error) {
+	if !safeName(name) || isRoot(cleanName(name)) || strings.HasSuffix(name, "/") {
+		return FS{}, &os.PathError{Op: "open", Path: name, Err: ErrUnsafePath}
+	}
+	// The options are checked, and the limit they set found, before the
+	// stream is decompressed.
+	var cfg FS
+	for _, opt := range opts {
+		if err := opt(&cfg); err != nil {
+			return FS{}, err
+		}
+	}
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return FS{}, err
+	}
+	defer gz.Close()
+	var src io.Reader = gz
+	if cfg.maxBytes > 0 {
+		src = &limitedReader{rc: gz, name: name, max: cfg.maxBytes, left: cfg.maxBytes}
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	fh := &zip.FileHeader{Name: name, Method: zip.Store}
+	if !gz.ModTime.IsZero() {
+		fh.Modified = gz.ModTime
+	}
+	w, err := zw.CreateHeader(fh)
+	if err != nil {
+		return FS{}, err
+	}
+	if _, err := io.Copy(w, src); err != nil {
+		return FS{}, err
+	}
+	if err := zw.Close(); err != nil {
+		return FS{}, err
+	}
+	return OpenBytes(buf.Bytes(), opts...)
+}
@@ -0,0 +1,163 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// StdFS returns a view of z that satisfies the standard io/fs.FS interface, so
+// that the archive can be used with fs.WalkDir, fs.ReadFile, http.FS, and so
+// on.  Names passed to the resulting FS must satisfy fs.ValidPath.
+func (z FS) StdFS() fs.FS { return stdFS{z} }
+
+type stdFS struct{ z FS }
+
+var (
+	_ fs.ReadDirFS = stdFS{}
+	_ fs.SubFS     = stdFS{}
+)
+
+// Open implements the fs.FS interface.
+func (s stdFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	fi, err := s.z.Stat(context.Background(), name)
+	if os.IsNotExist(err) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	} else if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return &dirFile{info: fi, fs: s, path: name}, nil
+	}
+	// Open as FS.Open does, resolving nested archives and symbolic links.
+	rc, err := s.z.Open(context.Background(), name)
+	if os.IsNotExist(err) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	} else if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &file{ReadCloser: rc, info: fi}, nil
+}
+
+// ReadDir implements the fs.ReadDirFS interface.
+func (s stdFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	infos, err := s.z.ReadDir(context.Background(), name)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]fs.DirEntry, len(infos))
+	for i, fi := range infos {
+		entries[i] = fs.FileInfoToDirEntry(fi)
+	}
+	return entries, nil
+}
+
+// Sub implements the fs.SubFS interface.
+func (s stdFS) Sub(dir string) (fs.FS, error) {
+	if !fs.ValidPath(dir) {
+		return nil, &fs.PathError{Op: "sub", Path: dir, Err: fs.ErrInvalid}
+	}
+	z, err := s.z.Sub(dir)
+	if err != nil {
+		return nil, err
+	}
+	return stdFS{z}, nil
+}
+
+// file implements fs.File for a regular archive entry.
+type file struct {
+	io.ReadCloser
+	info os.FileInfo
+}
+
+// Stat implements part of the fs.File interface.
+func (f *file) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+// errIsDir is returned when reading from a directory.
+var errIsDir = errors.New("is a directory")
+
+// dirFile implements fs.ReadDirFile for a directory.
+type dirFile struct {
+	info    os.FileInfo
+	fs      stdFS
+	path    string
+	entries []fs.DirEntry // remaining entries, once loaded
+	loaded  bool
+}
+
+// Stat implements part of the fs.File interface.
+func (d *dirFile) Stat() (fs.FileInfo, error) { return d.info, nil }
+
+// Read implements part of the fs.File interface.  It always fails.
+func (d *dirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: errIsDir}
+}
+
+// Close implements part of the fs.File interface.
+func (d *dirFile) Close() error { return nil }
+
+// ReadDir implements part of the fs.ReadDirFile interface.
+func (d *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.loaded {
+		entries, err := d.fs.ReadDir(d.path)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.loaded = entries, true
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+// dirInfo is a synthetic os.FileInfo for a directory that has no entry of its
+// own in the archive.
+type dirInfo struct {
+	name    string
+	modTime time.Time
+}
+
+// These methods implement the os.FileInfo interface.
+func (d dirInfo) Name() string       { return d.name }
+func (d dirInfo) Size() int64        { return 0 }
+func (d dirInfo) Mode() os.FileMode  { return os.ModeDir | 0555 }
+func (d dirInfo) ModTime() time.Time { return d.modTime }
+func (d dirInfo) IsDir() bool        { return true }
+func (d dirInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,118 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// ErrNotKzip is reported by AsKzip for archives without the layout of a kzip.
+var ErrNotKzip = errors.New("not a kzip archive")
+
+// A Kzip gives access to the compilation units and required files stored in a
+// kzip, a zip archive whose entries all lie beneath a single root directory,
+// with the units in root/units/ and the files in root/files/, each named by
+// the digest of its contents.
+type Kzip struct {
+	fs    FS       // rooted at the root directory of the kzip
+	root  string   // the name of the root directory
+	units []string // the digests of the units, sorted
+}
+
+// AsKzip checks that z has the layout of a kzip and returns a Kzip for it.  If
+// it does not, the error wraps ErrNotKzip.  The contents of the entries are
+// not read; the check and the list of units use the index of z.
+func (z FS) AsKzip() (*Kzip, error) {
+	var root string
+	for _, f := range z.index().entries {
+		name, ok := z.rel(f)
+		if !ok {
+			continue
+		}
+		top := name
+		if i := strings.Index(name, "/"); i >= 0 {
+			top = name[:i]
+		}
+		if top == name {
+			return nil, fmt.Errorf("entry %q is outside the root directory: %w", name, ErrNotKzip)
+		} else if root == "" {
+			root = top
+		} else if top != root {
+			return nil, fmt.Errorf("several root directories, %q and %q: %w", root, top, ErrNotKzip)
+		}
+	}
+	if root == "" {
+		return nil, fmt.Errorf("no root directory: %w", ErrNotKzip)
+	}
+	fs, err := z.Sub(root)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	if isDir, err := fs.IsDir(ctx, "units"); err != nil || !isDir {
+		return nil, fmt.Errorf("no units directory: %w", ErrNotKzip)
+	} else if isDir, err := fs.IsDir(ctx, "files"); err == nil && !isDir {
+		return nil, fmt.Errorf("%q is not a directory: %w", root+"/files", ErrNotKzip)
+	}
+
+	k := &Kzip{fs: fs, root: root}
+	for _, f := range fs.index().entries {
+		name, ok := fs.rel(f)
+		if !ok {
+			continue
+		}
+		if dir, digest := path.Split(name); dir == "units/" && digest != "" {
+			k.units = append(k.units, digest)
+		}
+	}
+	sort.Strings(k.units)
+	return k, nil
+}
+
+// Root returns the name of the root directory of the kzip.
+func (k *Kzip) Root() string { return k.root }
+
+// Units returns the digests of the compilation units stored in the kzip, in
+// sorted order.
+func (k *Kzip) Units() []string { return append([]string(nil), k.units...) }
+
+// Unit returns a reader for the serialized compilation unit with the given
+// digest.  If there is no such unit, the error satisfies os.IsNotExist.
+func (k *Kzip) Unit(ctx context.Context, digest string) (io.ReadCloser, error) {
+	return k.open(ctx, "units", digest)
+}
+
+// File returns a reader for the contents of the required file with the given
+// digest.  If there is no such file, the error satisfies os.IsNotExist.
+func (k *Kzip) File(ctx context.Context, digest string) (io.ReadCloser, error) {
+	return k.open(ctx, "files", digest)
+}
+
+func (k *Kzip) open(ctx context.Context, dir, digest string) (io.ReadCloser, error) {
+	if digest == "" || strings.ContainsAny(digest, "/!") {
+		return nil, &os.PathError{Op: "open", Path: dir + "/" + digest, Err: os.ErrNotExist}
+	}
+	return k.fs.Open(ctx, dir+"/"+digest)
+}
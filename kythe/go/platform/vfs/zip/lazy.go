@@ -0,0 +1,235 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// LazyDirectory returns an Option that defers reading the central directory
+// of the archive until a method needs all of it, so that opening an archive
+// with millions of entries to read only a few of them is quick and uses little
+// memory.  The Archive field of the FS is then nil.  Until the directory is
+// read, Open, Stat, ReadFile, and the other methods that look up a single file
+// each stream through the central directory to find its entry, remembering
+// what they find.  Stat of a directory, and the methods that work through every entry,
+// such as Glob, ReadDir, Walk, and Validate, read the whole directory, as if
+// the option were not given; a failure to read it then is logged, and the
+// archive treated as empty.
+//
+// Each lookup costs a read of the central directory, so the option is a poor
+// choice for archives from which many files are read.  It has no effect on
+// archives that need zip64 records, that follow other data, or whose names
+// must all be checked when the archive is opened, as with RejectUnsafeNames,
+// RequireSupportedMethods, or the RejectDuplicates policy.
+func LazyDirectory() Option {
+	return func(z *FS) error {
+		z.lazyDir = true
+		return nil
+	}
+}
+
+// A lazyDirectory locates the entries of an archive whose central directory
+// has not been read in full, and reads it when needed.
+type lazyDirectory struct {
+	r               io.ReaderAt
+	size            int64
+	cdStart, cdSize int64
+	loaded          int32 // set to 1 once archive is read
+	once            sync.Once
+	archive         *zip.Reader
+
+	mu    sync.Mutex
+	found map[string]lazyEntry // by key
+}
+
+// A lazyEntry records the result of looking up a name: the entry with that
+// name, if any, and whether other entries lie beneath it.
+type lazyEntry struct {
+	f   *zip.File
+	dir bool
+}
+
+// newLazyDirectory returns a lazyDirectory for the archive of the given size
+// read with r, and the number of entries it records, or reports false if the
+// archive cannot be read lazily.
+func newLazyDirectory(r io.ReaderAt, size int64) (*lazyDirectory, int, bool) {
+	pos, err := findDirectoryEnd(r, size)
+	if err != nil {
+		return nil, 0, false
+	}
+	var end [eocdLen]byte
+	if _, err := r.ReadAt(end[:], pos); err != nil && err != io.EOF {
+		return nil, 0, false
+	}
+	disks := binary.LittleEndian.Uint32(end[4:])
+	count := binary.LittleEndian.Uint16(end[10:])
+	cdSize := binary.LittleEndian.Uint32(end[12:])
+	cdOffset := binary.LittleEndian.Uint32(end[16:])
+	if disks != 0 || count == 0xFFFF || cdSize == 0xFFFFFFFF || cdOffset == 0xFFFFFFFF {
+		return nil, 0, false // split, or with zip64 records
+	}
+	if int64(cdOffset)+int64(cdSize) != pos {
+		return nil, 0, false // preceded by other data
+	}
+	l := &lazyDirectory{
+		r:       r,
+		size:    size,
+		cdStart: int64(cdOffset),
+		cdSize:  int64(cdSize),
+		found:   make(map[string]lazyEntry),
+	}
+	return l, int(count), true
+}
+
+// archive returns the reader for the whole archive of z, reading its central
+// directory first if z was opened with LazyDirectory.
+func (z FS) archive() *zip.Reader {
+	l := z.lazy
+	if l == nil {
+		return z.Archive
+	}
+	l.once.Do(func() {
+		rc, err := zip.NewReader(l.r, l.size)
+		if err != nil {
+			z.logf("reading central directory: %v", err)
+			rc = new(zip.Reader)
+		}
+		z.register(rc)
+		if !z.rawNames {
+			for f := range decodeNames(rc) {
+				z.decoded[f] = true
+			}
+		}
+		l.archive = rc
+		atomic.StoreInt32(&l.loaded, 1)
+	})
+	return l.archive
+}
+
+// lazyFind returns the result of looking up the given key without reading the
+// whole central directory.  It reports false if the directory of z has already
+// been read, or could not be.
+func (z FS) lazyFind(key string) (lazyEntry, bool) {
+	l := z.lazy
+	if l == nil || atomic.LoadInt32(&l.loaded) != 0 {
+		return lazyEntry{}, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e, ok := l.found[key]; ok {
+		return e, true
+	}
+	e, err := z.scanDirectory(key)
+	if err != nil {
+		z.logf("looking up %q: %v", key, err)
+		return lazyEntry{}, false
+	}
+	l.found[key] = e
+	return e, true
+}
+
+// scanDirectory reads through the central directory of z for the entry that
+// the index would have for the given key.
+func (z FS) scanDirectory(key string) (lazyEntry, error) {
+	l := z.lazy
+	br := bufio.NewReaderSize(io.NewSectionReader(l.r, l.cdStart, l.cdSize), scanChunk)
+	var (
+		e     lazyEntry
+		match []byte // the record of the entry found
+		rec   []byte
+	)
+	for {
+		var hdr [cdLen]byte
+		if _, err := io.ReadFull(br, hdr[:]); err == io.EOF {
+			break
+		} else if err != nil {
+			return lazyEntry{}, err
+		} else if string(hdr[:4]) != cdSignature {
+			return lazyEntry{}, zip.ErrFormat
+		}
+		nameLen := int(binary.LittleEndian.Uint16(hdr[28:]))
+		n := cdLen + nameLen + int(binary.LittleEndian.Uint16(hdr[30:])) + int(binary.LittleEndian.Uint16(hdr[32:]))
+		if cap(rec) < n {
+			rec = make([]byte, n)
+		}
+		rec = rec[:n]
+		copy(rec, hdr[:])
+		if _, err := io.ReadFull(br, rec[cdLen:]); err != nil {
+			return lazyEntry{}, zip.ErrFormat
+		}
+		name := string(rec[cdLen : cdLen+nameLen])
+		if !z.rawNames && binary.LittleEndian.Uint16(hdr[8:])&flagUTF8 == 0 && !utf8.ValidString(name) {
+			name = decodeCP437(name)
+		}
+		if !safeName(name) {
+			continue
+		}
+		k := z.key(strings.TrimSuffix(cleanName(name), "/"))
+		if strings.HasPrefix(k, key+"/") {
+			e.dir = true
+		} else if k == key && (match == nil || z.duplicates != FirstEntryWins) {
+			match = append(match[:0], rec...)
+		}
+	}
+	if match != nil {
+		f, err := z.entryFor(match)
+		if err != nil {
+			return lazyEntry{}, err
+		}
+		e.f = f
+	}
+	return e, nil
+}
+
+// entryFor returns an entry for the central directory record rec, read from
+// an archive consisting of the data of z and a directory holding only rec.
+func (z FS) entryFor(rec []byte) (*zip.File, error) {
+	l := z.lazy
+	tail := make([]byte, len(rec)+eocdLen)
+	copy(tail, rec)
+	end := tail[len(rec):]
+	copy(end, eocdSignature)
+	binary.LittleEndian.PutUint16(end[8:], 1)
+	binary.LittleEndian.PutUint16(end[10:], 1)
+	binary.LittleEndian.PutUint32(end[12:], uint32(len(rec)))
+	binary.LittleEndian.PutUint32(end[16:], uint32(l.cdStart))
+
+	v := new(volumes)
+	v.add(io.NewSectionReader(l.r, 0, l.cdStart), l.cdStart)
+	v.add(bytes.NewReader(tail), int64(len(tail)))
+	rc, err := zip.NewReader(v, v.size)
+	if err != nil {
+		return nil, err
+	}
+	if len(rc.File) != 1 {
+		return nil, zip.ErrFormat
+	}
+	z.register(rc)
+	if !z.rawNames {
+		decodeNames(rc)
+	}
+	return rc.File[0], nil
+}
@@ -0,0 +1,48 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"regexp"
+
+	"golang.org/x/net/context"
+)
+
+// Match returns the names of the entries of z that re matches, in sorted
+// order.  As for Glob, names are relative to the root of z and are matched
+// exactly as stored, with directory entries ending in "/".  The regexp is not
+// anchored, so re must begin with "^" and end with "$" to match whole names.
+func (z FS) Match(ctx context.Context, re *regexp.Regexp) ([]string, error) {
+	return z.scan(ctx, z.rel, func(_ *zip.File, name string) (bool, error) {
+		return re.MatchString(name), nil
+	})
+}
+
+// Select returns the names of the entries of z for which pred returns true, in
+// sorted order.  The predicate is given the metadata of each entry, as listed
+// by Entries, so that entries can be chosen by size, time, or method in a
+// single pass; for example, the large stored entries are those for which
+//
+//	e.Method == zip.Store && e.Size > 1<<20
+//
+// If the GlobParallelism option is in effect, pred may be called concurrently.
+func (z FS) Select(ctx context.Context, pred func(EntryInfo) bool) ([]string, error) {
+	return z.scan(ctx, z.rel, func(f *zip.File, name string) (bool, error) {
+		return pred(newEntryInfo(f, name)), nil
+	})
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+)
+
+// Mmap maps the named file into memory, so that an archive stored in it can be
+// read with OpenAt without a system call, or a copy through the kernel, for
+// each read.  It returns a reader for the contents of the file, its size, and
+// a function that releases the mapping; the reader must not be used once the
+// function has been called, and further calls have no effect.  Where the file
+// cannot be mapped, as on some file systems and platforms, the reader is the
+// file itself and the function closes it.
+//
+// A typical use is
+//
+//	r, size, release, err := zip.Mmap(path)
+//	...
+//	defer release()
+//	z, err := zip.OpenAt(r, size)
+func Mmap(path string) (io.ReaderAt, int64, func() error, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, nil, err
+	}
+	size := fi.Size()
+	data, err := mmap(f, size)
+	if err != nil {
+		return f, size, onceRelease(f.Close), nil
+	}
+	f.Close() // the mapping remains valid without the descriptor
+	return bytes.NewReader(data), size, onceRelease(func() error { return munmap(data) }), nil
+}
+
+// onceRelease returns a function that calls release the first time it is
+// called, and returns the same error from every call.
+func onceRelease(release func() error) func() error {
+	var once sync.Once
+	var err error
+	return func() error {
+		once.Do(func() { err = release() })
+		return err
+	}
+}
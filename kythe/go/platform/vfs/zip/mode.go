@@ -0,0 +1,127 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Values of the upper byte of FileHeader.CreatorVersion for systems that store
+// Unix modes in the external attributes of entries.
+const (
+	creatorUnix   = 3
+	creatorMacOSX = 19
+)
+
+// hasUnixMode reports whether the archive records the Unix mode of f.  Some
+// tools record the mode but claim another creator system; their entries are
+// recognized by having an extra field that only Unix systems write.
+func hasUnixMode(f *zip.File) bool {
+	if f.ExternalAttrs>>16 == 0 {
+		return false
+	}
+	switch f.CreatorVersion >> 8 {
+	case creatorUnix, creatorMacOSX:
+		return true
+	}
+	return hasUnixField(f)
+}
+
+// entryMode returns the mode of f: the Unix mode recorded in the archive if
+// there is one, or else 0555 for directories and 0444 for files, since the
+// archive is read-only.
+func entryMode(f *zip.File) os.FileMode {
+	if hasUnixMode(f) {
+		mode := unixMode(f.ExternalAttrs >> 16)
+		if strings.HasSuffix(f.Name, "/") {
+			mode |= os.ModeDir
+		}
+		return mode
+	} else if strings.HasSuffix(f.Name, "/") {
+		return os.ModeDir | 0555
+	}
+	return 0444
+}
+
+// fileInfo returns file information for f, whose mode is given by entryMode.
+func fileInfo(f *zip.File) os.FileInfo {
+	return entryInfo{f.FileInfo(), entryMode(f), entryTime(f), f.FileHeader}
+}
+
+// entryInfo is an os.FileInfo for an archive entry.
+type entryInfo struct {
+	os.FileInfo
+	mode    os.FileMode
+	modTime time.Time
+	hdr     zip.FileHeader
+}
+
+// Mode implements part of the os.FileInfo interface.
+func (e entryInfo) Mode() os.FileMode { return e.mode }
+
+// ModTime implements part of the os.FileInfo interface.  The time is in UTC,
+// as for entryTime.
+func (e entryInfo) ModTime() time.Time { return e.modTime }
+
+// Sys implements part of the os.FileInfo interface.  As in archive/zip, it
+// returns the *zip.FileHeader of the entry; the header is a copy, so changes
+// made to it do not affect the archive.
+func (e entryInfo) Sys() interface{} {
+	hdr := e.hdr
+	return &hdr
+}
+
+// Mode returns the mode of the file or directory at path, as reported by Stat.
+// The permission bits are those recorded by the system that created the
+// archive, if it is a Unix system that records them, and otherwise 0555 for
+// directories and 0444 for files.
+func (z FS) Mode(path string) (os.FileMode, error) {
+	fi, err := z.Stat(context.Background(), path)
+	if err != nil {
+		return 0, err
+	}
+	return fi.Mode(), nil
+}
+
+// entryTime returns the modification time of f in UTC.  The archive/zip
+// package takes the time from the extended timestamp or NTFS extra field if
+// the entry has one, and otherwise from the MS-DOS date and time, which have
+// no time zone and are taken to be in UTC, and only to the nearest two
+// seconds.  Lacking the first two fields, entryTime prefers the time in one of
+// the older Unix extra fields, if the entry has one.
+func entryTime(f *zip.File) time.Time {
+	if t, ok := unixTime(f); ok {
+		return t
+	}
+	return f.Modified.UTC()
+}
+
+// ModTime returns the modification time of the file or directory at path, in
+// UTC, as reported by Stat.  The time of a directory that has no entry of its
+// own is the latest time of its contents.
+func (z FS) ModTime(path string) (time.Time, error) {
+	fi, err := z.Stat(context.Background(), path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
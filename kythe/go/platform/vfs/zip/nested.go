@@ -0,0 +1,122 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	// nestSep separates the name of an entry that is itself a zip archive
+	// from a path within that archive.
+	nestSep = "!/"
+
+	// maxNesting is the deepest that archives may be nested.
+	maxNesting = 8
+)
+
+// ErrNestingDepth is reported for paths that refer to archives nested more
+// deeply than is allowed.
+var ErrNestingDepth = fmt.Errorf("archives nested more than %d deep", maxNesting)
+
+// resolve returns the archive to which path refers, and the remainder of the
+// path within that archive.  A path that does not cross into a nested archive
+// is returned unchanged along with z itself.  The "!/" separator is only
+// recognized following the name of a file entry, so other paths containing it
+// are resolved as usual.
+func (z FS) resolve(path string) (FS, string, error) {
+	i := strings.Index(path, nestSep)
+	if i < 0 {
+		return z, path, nil
+	}
+	name, err := cleanPath(path[:i])
+	if err != nil || isRoot(name) {
+		return z, path, nil
+	}
+	f := z.find(name)
+	if f == nil || f.FileInfo().IsDir() {
+		return z, path, nil
+	}
+	inner, err := z.nested(f)
+	if err != nil {
+		return FS{}, "", err
+	}
+	return inner.resolve(path[i+len(nestSep):])
+}
+
+// nested returns an FS for the zip archive stored in entry f of z.  The FS has
+// the same settings as z, and is cached so that the archive is only read once.
+// The index is locked only to look up the cache; callers wanting an archive
+// that is being read wait for it, as openShared does.
+func (z FS) nested(f *zip.File) (FS, error) {
+	if z.depth >= maxNesting {
+		return FS{}, ErrNestingDepth
+	}
+	if z.idx == nil {
+		return z.loadNested(f)
+	}
+
+	z.idx.mu.Lock()
+	n, ok := z.idx.nested[f]
+	if !ok {
+		if z.idx.nested == nil {
+			z.idx.nested = make(map[*zip.File]*nestedArchive)
+		}
+		n = &nestedArchive{ready: make(chan struct{})}
+		z.idx.nested[f] = n
+	}
+	z.idx.mu.Unlock()
+	if ok {
+		<-n.ready
+		return n.fs, n.err
+	}
+	n.fs, n.err = z.loadNested(f)
+	close(n.ready)
+	return n.fs, n.err
+}
+
+// A nestedArchive is an entry of the cache of nested archives.
+type nestedArchive struct {
+	ready chan struct{} // closed once fs and err are set
+	fs    FS
+	err   error
+}
+
+// loadNested reads the zip archive stored in entry f of z into memory.  The
+// entry is read as by Open, so MaxUncompressedBytes limits its size.
+func (z FS) loadNested(f *zip.File) (FS, error) {
+	rc, err := z.openEntry(f)
+	if err != nil {
+		return FS{}, err
+	}
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return FS{}, err
+	}
+	inner := z
+	inner.prefix, inner.idx, inner.closer = "", new(index), nil
+	inner.depth++
+	if err := inner.load(bytes.NewReader(data), int64(len(data))); err != nil {
+		return FS{}, fmt.Errorf("nested archive %q: %w", f.Name, err)
+	}
+	return inner, nil
+}
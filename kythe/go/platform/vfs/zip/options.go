@@ -0,0 +1,123 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"errors"
+	"fmt"
+)
+
+// An Option is a configurable setting for an FS, applied when it is opened.
+type Option func(*FS) error
+
+// A Logger receives diagnostic messages about an archive, such as entries that
+// were ignored.  A *log.Logger satisfies this interface.
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// LogTo returns an Option that sends diagnostic messages to l.  By default,
+// diagnostics are discarded.
+func LogTo(l Logger) Option {
+	return func(z *FS) error {
+		if l == nil {
+			return errors.New("invalid Logger")
+		}
+		z.logger = l
+		return nil
+	}
+}
+
+// logf sends a diagnostic message to the logger for z, if there is one.
+func (z FS) logf(format string, args ...interface{}) {
+	if z.logger != nil {
+		z.logger.Printf("zip: "+format, args...)
+	}
+}
+
+// MaxUncompressedBytes returns an Option that limits the number of bytes that
+// may be read from any one entry of the archive to n.  The limit protects
+// against "zip bombs", small archives that expand to enormous sizes.  Open
+// fails with ErrTooLarge for entries whose recorded size exceeds the limit, and
+// since the recorded size may be false, reads fail with ErrTooLarge once more
+// than n bytes have been decompressed.
+func MaxUncompressedBytes(n int64) Option {
+	return func(z *FS) error {
+		if n < 0 {
+			return fmt.Errorf("invalid limit %d", n)
+		}
+		z.maxBytes = n
+		return nil
+	}
+}
+
+// MaxEntries returns an Option that makes opening the archive fail with
+// ErrTooLarge if it has more than n entries.
+func MaxEntries(n int) Option {
+	return func(z *FS) error {
+		if n < 0 {
+			return fmt.Errorf("invalid limit %d", n)
+		}
+		z.maxEntries = n
+		return nil
+	}
+}
+
+// RejectUnsafeNames returns an Option that makes opening the archive fail with
+// an error wrapping ErrUnsafePath if any of its entries has a name referring
+// outside the root of the archive.  By default, such entries are ignored.
+func RejectUnsafeNames() Option {
+	return func(z *FS) error {
+		z.strict = true
+		return nil
+	}
+}
+
+// A DuplicatePolicy determines which entry is used when an archive has several
+// entries with the same name.  The other entries are ignored entirely.
+type DuplicatePolicy int
+
+const (
+	// LastEntryWins uses the last entry with each name, as most tools that
+	// extract archives do.  It is the default.
+	LastEntryWins DuplicatePolicy = iota
+
+	// FirstEntryWins uses the first entry with each name.
+	FirstEntryWins
+
+	// RejectDuplicates makes opening the archive fail with an error wrapping
+	// ErrDuplicateEntry.
+	RejectDuplicates
+)
+
+// ErrDuplicateEntry is reported for archives having several entries with the
+// same name, when the RejectDuplicates policy is in effect.  With the
+// CaseInsensitive option, names differing only in case are the same.
+var ErrDuplicateEntry = errors.New("duplicate entry name")
+
+// OnDuplicate returns an Option that sets the policy for archives having
+// several entries with the same name.  A directory entry "a/" has the same
+// name as a file entry "a".
+func OnDuplicate(p DuplicatePolicy) Option {
+	return func(z *FS) error {
+		if p < LastEntryWins || p > RejectDuplicates {
+			return fmt.Errorf("invalid duplicate policy %d", p)
+		}
+		z.duplicates = p
+		return nil
+	}
+}
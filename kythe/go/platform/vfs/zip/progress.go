@@ -0,0 +1,71 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"errors"
+	"sync"
+)
+
+// Progress returns an Option that reports the progress of the methods that
+// work through every entry of the archive, such as Glob, Match, Select, and
+// ValidateAll, by calling fn with the number of entries done so far and the
+// total number to do.  Glob and the other scans call fn after every 1024
+// entries, and ValidateAll after every entry.  If a method works through all
+// the entries, its last call has done equal to total.  Calls
+// are never concurrent, even when the work is spread over several goroutines,
+// and fn must not block for long since the work waits for it.  For CopyTo, see
+// CopyProgress.
+func Progress(fn func(done, total int)) Option {
+	return func(z *FS) error {
+		if fn == nil {
+			return errors.New("invalid progress function")
+		}
+		z.progress = fn
+		return nil
+	}
+}
+
+// A progress counts the work done by one call, and reports it to fn.  A nil
+// *progress reports nothing.
+type progress struct {
+	fn    func(done, total int)
+	total int
+
+	mu   sync.Mutex
+	done int
+}
+
+// newProgress returns a progress reporting on total items of work to fn, or
+// nil if fn is nil.
+func newProgress(fn func(done, total int), total int) *progress {
+	if fn == nil {
+		return nil
+	}
+	return &progress{fn: fn, total: total}
+}
+
+// add records that n more items of work are done, and reports the total done.
+func (p *progress) add(n int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done += n
+	p.fn(p.done, p.total)
+}
@@ -0,0 +1,168 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Header returns a copy of the header of the archive entry at path, including
+// the fields that os.FileInfo does not expose, such as the flags, the extra
+// fields, and the compression method.  The same header is available from the
+// result of Stat, whose Sys method returns a *zip.FileHeader for every path
+// that has an entry of its own.  Paths may name entries of nested archives.
+// Directories that have no entry in the archive have no header, and the error
+// for them satisfies os.IsNotExist.
+func (z FS) Header(path string) (*zip.FileHeader, error) {
+	_, f, err := z.lookupNested("header", path)
+	if err != nil {
+		return nil, err
+	}
+	fh := f.FileHeader
+	return &fh, nil
+}
+
+// lookupNested is like lookup, but also accepts paths naming entries of nested
+// archives, and returns the FS of the archive holding the entry.
+func (z FS) lookupNested(op, path string) (FS, *zip.File, error) {
+	z, inner, err := z.resolve(path)
+	if err != nil {
+		return FS{}, nil, &os.PathError{Op: op, Path: path, Err: err}
+	}
+	name, err := cleanPath(inner)
+	if err != nil {
+		return FS{}, nil, &os.PathError{Op: op, Path: path, Err: err}
+	}
+	f := z.find(name)
+	if f == nil {
+		return FS{}, nil, &os.PathError{Op: op, Path: path, Err: os.ErrNotExist}
+	}
+	return z, f, nil
+}
+
+// OpenRaw returns a reader for the raw contents of the archive entry at path,
+// together with a copy of its header.  The reader yields the entry's data as
+// stored in the archive, that is, still compressed according to the header's
+// Method, without any decompression or checksum verification.  The data and
+// header may be passed to zip.Writer.CreateRaw to copy the entry into another
+// archive without recompressing it.  Paths may name entries of nested
+// archives.
+func (z FS) OpenRaw(path string) (io.Reader, *zip.FileHeader, error) {
+	_, f, err := z.lookupNested("open", path)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := f.OpenRaw()
+	if err != nil {
+		return nil, nil, err
+	}
+	fh := f.FileHeader
+	return r, &fh, nil
+}
+
+// ErrNested is reported by DataOffset for entries of nested archives.
+var ErrNested = errors.New("entry is in a nested archive")
+
+// ErrNoSource is returned by WriteTo for an FS whose original bytes are not
+// available.
+var ErrNoSource = errors.New("original archive bytes are not available")
+
+var _ io.WriterTo = FS{}
+
+// WriteTo writes the bytes of the archive to w exactly as they were read, and
+// returns the number of bytes written, so that an archive can be relayed
+// without being encoded again and its digest is unchanged.  The whole source
+// is written, including any data before or after the archive proper, even for
+// an FS returned by Sub.  For an entry of a nested archive, the nested archive
+// alone is written.  The source is read again, so it must not have been
+// closed.  WriteTo fails with ErrNoSource if z was not
+// constructed by a function of this package, or if the archive was opened by
+// OpenVolumes from volumes whose central directory had to be rewritten.
+func (z FS) WriteTo(w io.Writer) (int64, error) {
+	if z.src == nil {
+		return 0, ErrNoSource
+	} else if v, ok := z.src.(*volumes); ok && v.rewritten {
+		return 0, ErrNoSource
+	}
+	return z.copyBuffer(w, io.NewSectionReader(z.src, 0, z.size))
+}
+
+// DataOffset returns the offset at which the stored data of the archive entry
+// at path begin, counted from the start of the source of the archive, so that
+// tools may read or map the data directly.  The data are stored as described
+// by the entry's Method and flags: only the data of an entry stored without
+// compression or encryption are its contents, and the stored size is given by
+// the CompressedSize64 of its Header.  Paths naming entries of nested archives
+// fail with an error wrapping ErrNested, since their data have no offset in
+// the source.
+func (z FS) DataOffset(path string) (int64, error) {
+	inner, f, err := z.lookupNested("offset", path)
+	if err != nil {
+		return 0, err
+	} else if inner.depth > z.depth {
+		return 0, &os.PathError{Op: "offset", Path: path, Err: ErrNested}
+	}
+	off, err := f.DataOffset()
+	if err != nil {
+		return 0, &os.PathError{Op: "offset", Path: path, Err: err}
+	}
+	return off, nil
+}
+
+// OpenReaderAt returns a random-access reader for the contents of the archive
+// entry at path, together with its size.  The reader also implements
+// io.ReadSeeker.  For an entry stored without compression, the reader reads
+// directly from the source of the archive, with no buffering and no checksum
+// verification; otherwise, the entry is decompressed into memory first.  The
+// location and size of the data are taken from the central directory, so they
+// are correct for entries written in streaming mode, whose local headers omit
+// the sizes and whose data are followed by a data descriptor.
+func (z FS) OpenReaderAt(path string) (io.ReaderAt, int64, error) {
+	f, err := z.lookup("open", path)
+	if err != nil {
+		return nil, 0, err
+	}
+	// The stored data span CompressedSize64 bytes; the entry is decompressed
+	// instead if that disagrees with its size, so that the error is reported.
+	if f.Method == zip.Store && !isEncrypted(f) && f.CompressedSize64 == f.UncompressedSize64 && z.src != nil {
+		size := int64(f.CompressedSize64)
+		if z.maxBytes > 0 && size > z.maxBytes {
+			return nil, 0, tooLargeError(f.Name, z.maxBytes)
+		}
+		off, err := f.DataOffset()
+		if err != nil {
+			return nil, 0, err
+		}
+		return io.NewSectionReader(z.src, off, size), size, nil
+	}
+
+	rc, err := z.openEntry(f)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, 0, err
+	}
+	return bytes.NewReader(data), int64(len(data)), nil
+}
@@ -0,0 +1,142 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// ShareDecompression returns an Option that makes concurrent calls to Open for
+// the same entry decompress it only once, if its recorded size is at most max
+// bytes.  The first caller decompresses the whole entry into memory, and the
+// others wait for it, after which each reads its own copy of the contents from
+// the shared buffer.  The buffer is released when the last of the readers that
+// share it is closed, so unlike vfs.Cache this does not keep entries in memory
+// between uses; the two may be combined.  Since the entry is read in full
+// before Open returns, errors in its data, such as a checksum mismatch, are
+// reported by Open.  Entries whose data turn out to be larger than max are
+// read by each caller separately, as usual.
+func ShareDecompression(max int64) Option {
+	return func(z *FS) error {
+		if max <= 0 {
+			return fmt.Errorf("invalid limit %d", max)
+		}
+		z.shareMax = max
+		return nil
+	}
+}
+
+// errNotShared is recorded for entries too large to share once decompressed.
+var errNotShared = errors.New("entry too large to share")
+
+// A share holds the decompressed contents of an entry for the readers that
+// share it.
+type share struct {
+	ready chan struct{} // closed once data and err are set
+	data  []byte
+	err   error
+	refs  int // the number of callers using the share, guarded by index.mu
+}
+
+// shareable reports whether Open should share the contents of f.
+func (z FS) shareable(f *zip.File) bool {
+	return z.shareMax > 0 && z.idx != nil && f.UncompressedSize64 <= uint64(z.shareMax)
+}
+
+// openShared returns a reader for the contents of f from a buffer shared with
+// the other callers opening f concurrently, decompressing f into the buffer if
+// no other caller is doing so.
+func (z FS) openShared(ctx context.Context, f *zip.File) (io.ReadCloser, error) {
+	idx := z.index()
+	idx.mu.Lock()
+	s, ok := idx.shares[f]
+	if !ok {
+		if idx.shares == nil {
+			idx.shares = make(map[*zip.File]*share)
+		}
+		s = &share{ready: make(chan struct{})}
+		idx.shares[f] = s
+	}
+	s.refs++
+	idx.mu.Unlock()
+	release := func() {
+		idx.mu.Lock()
+		defer idx.mu.Unlock()
+		if s.refs--; s.refs == 0 && idx.shares[f] == s {
+			delete(idx.shares, f)
+		}
+	}
+
+	if !ok {
+		s.data, s.err = z.decompress(f)
+		close(s.ready)
+	} else {
+		select {
+		case <-s.ready:
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+	if s.err == errNotShared {
+		release()
+		return z.openEntry(f)
+	} else if s.err != nil {
+		release()
+		return nil, s.err
+	}
+	return &sharedReader{Reader: bytes.NewReader(s.data), release: release}, nil
+}
+
+// decompress returns the contents of f, or errNotShared if they are larger
+// than the limit for sharing.
+func (z FS) decompress(f *zip.File) ([]byte, error) {
+	rc, err := z.openEntry(f)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(rc, z.shareMax+1))
+	if err != nil {
+		return nil, err
+	} else if int64(len(data)) > z.shareMax {
+		return nil, errNotShared
+	}
+	return data, nil
+}
+
+// sharedReader reads the shared contents of an entry, and releases its share
+// when closed.
+type sharedReader struct {
+	*bytes.Reader
+	once    sync.Once
+	release func()
+}
+
+// Close implements the io.Closer interface.
+func (r *sharedReader) Close() error {
+	r.once.Do(r.release)
+	return nil
+}
@@ -0,0 +1,62 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// OpenSpooled returns a read-only virtual file system (vfs.Reader) for the zip
+// archive read from r, which need not support seeking, as a pipe or the body
+// of an HTTP response does not.  The archive is first copied to a temporary
+// file, so that it need not fit in memory, and the FS then reads the file as
+// OpenFile does.  The returned function, like closing the FS, closes and
+// removes the temporary file; it is safe to call it more than once.  If the
+// archive cannot be copied or opened, the file is removed before OpenSpooled
+// returns.
+func OpenSpooled(r io.Reader, opts ...Option) (FS, func() error, error) {
+	f, err := ioutil.TempFile("", "zipspool")
+	if err != nil {
+		return FS{}, nil, err
+	}
+	s := spoolFile{f}
+	size, err := io.Copy(f, r)
+	if err != nil {
+		s.Close()
+		return FS{}, nil, err
+	}
+	z, err := newFS(f, size, s, opts)
+	if err != nil {
+		s.Close()
+		return FS{}, nil, err
+	}
+	return z, z.Close, nil
+}
+
+// A spoolFile is a temporary file that is removed when it is closed.
+type spoolFile struct{ *os.File }
+
+// Close implements the io.Closer interface.
+func (s spoolFile) Close() error {
+	err := s.File.Close()
+	if rerr := os.Remove(s.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"strings"
+)
+
+// ArchiveStats summarizes the entries of an archive, as recorded in its
+// central directory.
+type ArchiveStats struct {
+	NumFiles          int   // the number of file entries
+	NumDirs           int   // the number of directories, with or without entries
+	TotalUncompressed int64 // the total uncompressed size of the files
+	TotalCompressed   int64 // the total size of the files as stored
+
+	// CompressionRatio is TotalUncompressed divided by TotalCompressed, or 0
+	// if TotalCompressed is 0.
+	CompressionRatio float64
+
+	// These break down the file entries by compression method.
+	Stored, Deflated, Other MethodStats
+}
+
+// MethodStats summarizes the file entries compressed by one method.
+type MethodStats struct {
+	NumFiles     int
+	Uncompressed int64
+	Compressed   int64
+}
+
+func (m *MethodStats) add(f *zip.File) {
+	m.NumFiles++
+	m.Uncompressed += int64(f.UncompressedSize64)
+	m.Compressed += int64(f.CompressedSize64)
+}
+
+// Stats returns statistics about the files and directories beneath the root of
+// z.  It reads only the central directory, so it is cheap even for very large
+// archives.
+func (z FS) Stats() ArchiveStats {
+	var s ArchiveStats
+	idx := z.index()
+	for _, f := range idx.entries {
+		name, ok := z.rel(f)
+		if !ok || strings.HasSuffix(name, "/") {
+			continue
+		}
+		s.NumFiles++
+		s.TotalUncompressed += int64(f.UncompressedSize64)
+		s.TotalCompressed += int64(f.CompressedSize64)
+		switch f.Method {
+		case zip.Store:
+			s.Stored.add(f)
+		case zip.Deflate:
+			s.Deflated.add(f)
+		default:
+			s.Other.add(f)
+		}
+	}
+	for dir := range idx.dirs {
+		if rest, ok := z.cutPrefix(dir+"/", z.prefix); ok && rest != "" {
+			s.NumDirs++
+		}
+	}
+	if s.TotalCompressed > 0 {
+		s.CompressionRatio = float64(s.TotalUncompressed) / float64(s.TotalCompressed)
+	}
+	return s
+}
+
+// Method returns the compression method of the entry at path, such as
+// zip.Store or zip.Deflate.  Directories without entries have no method, and
+// the error for them satisfies os.IsNotExist.
+func (z FS) Method(path string) (uint16, error) {
+	fh, err := z.Header(path)
+	if err != nil {
+		return 0, err
+	}
+	return fh.Method, nil
+}
+
+// Ratio returns the size of the stored data of the entry at path divided by
+// its uncompressed size, so that entries near or above 1 gain little or
+// nothing from compression.  This is the inverse of the CompressionRatio of
+// ArchiveStats.  The ratio of an empty entry is 1.
+func (z FS) Ratio(path string) (float64, error) {
+	fh, err := z.Header(path)
+	if err != nil {
+		return 0, err
+	}
+	if fh.UncompressedSize64 == 0 {
+		return 1, nil
+	}
+	return float64(fh.CompressedSize64) / float64(fh.UncompressedSize64), nil
+}
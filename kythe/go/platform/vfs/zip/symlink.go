@@ -0,0 +1,106 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"golang.org/x/net/context"
+)
+
+// maxSymlinks is the most symbolic links that Open follows in resolving a
+// path, as a guard against cycles.
+const maxSymlinks = 40
+
+// errSymlinkLoop is reported when resolving a path requires following more
+// than maxSymlinks symbolic links.
+var errSymlinkLoop = errors.New("too many levels of symbolic links")
+
+// FollowSymlinks returns an Option that makes Open follow symbolic links
+// stored in the archive, so that opening a link opens its target.  Only links
+// whose targets lie within the archive are followed; others are reported as
+// errors.  Stat and ReadDir still describe the links themselves.
+func FollowSymlinks() Option {
+	return func(z *FS) error {
+		z.follow = true
+		return nil
+	}
+}
+
+// isSymlink reports whether f is a symbolic link, which the archive records in
+// the Unix mode of the entry.
+func isSymlink(f *zip.File) bool { return f.Mode()&os.ModeSymlink != 0 }
+
+// Readlink returns the target of the symbolic link at path, which an archive
+// stores as the contents of the link's entry.  If the entry is not a symbolic
+// link, the error wraps os.ErrInvalid.
+func (z FS) Readlink(ctx context.Context, path string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	f, err := z.lookup("readlink", path)
+	if err != nil {
+		return "", err
+	}
+	if !isSymlink(f) {
+		return "", &os.PathError{Op: "readlink", Path: path, Err: os.ErrInvalid}
+	}
+	target, err := z.readlink(f)
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: path, Err: err}
+	}
+	return target, nil
+}
+
+// readlink returns the target of the symbolic link f.
+func (z FS) readlink(f *zip.File) (string, error) {
+	rc, err := z.openEntry(f)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	target, err := ioutil.ReadAll(rc)
+	return string(target), err
+}
+
+// followSymlinks returns the entry to which the entry f, with the cleaned
+// name, ultimately refers, following any symbolic links.
+func (z FS) followSymlinks(name string, f *zip.File) (*zip.File, error) {
+	for n := 0; isSymlink(f); n++ {
+		if n == maxSymlinks {
+			return nil, errSymlinkLoop
+		}
+		target, err := z.readlink(f)
+		if err != nil {
+			return nil, err
+		}
+		if path.IsAbs(target) {
+			return nil, errEscapesRoot
+		}
+		if name, err = cleanPath(path.Join(path.Dir(name), target)); err != nil {
+			return nil, err
+		}
+		if f = z.find(name); f == nil {
+			return nil, os.ErrNotExist
+		}
+	}
+	return f, nil
+}
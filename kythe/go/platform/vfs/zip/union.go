@@ -0,0 +1,137 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"io"
+	"os"
+	"sort"
+
+	"kythe.io/kythe/go/platform/vfs"
+
+	"golang.org/x/net/context"
+)
+
+// Union returns a read-only vfs.Reader that presents the contents of all the
+// given archives as a single file system.  Stat and Open consult each archive
+// in order and use the first one that contains the path, so when two archives
+// contain the same path, the one occurring earlier in the argument list wins.
+// Glob returns the union of the matches from all the archives, sorted and
+// without duplicates.
+func Union(fs ...FS) vfs.Reader { return union(fs) }
+
+type union []FS
+
+// Stat implements part of vfs.Reader.
+func (u union) Stat(ctx context.Context, path string) (os.FileInfo, error) {
+	err := error(&os.PathError{Op: "stat", Path: path, Err: os.ErrNotExist})
+	for _, z := range u {
+		var fi os.FileInfo
+		if fi, err = z.Stat(ctx, path); err == nil {
+			return fi, nil
+		}
+	}
+	return nil, err
+}
+
+// Open implements part of vfs.Reader.  The resulting reader is owned by the
+// first archive containing path.
+func (u union) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	err := os.ErrNotExist
+	for _, z := range u {
+		var rc io.ReadCloser
+		if rc, err = z.Open(ctx, path); !os.IsNotExist(err) {
+			return rc, err
+		}
+	}
+	return nil, err
+}
+
+// Glob implements part of vfs.Reader.
+func (u union) Glob(ctx context.Context, glob string) ([]string, error) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, z := range u {
+		matches, err := z.Glob(ctx, glob)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range matches {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Overlay returns a read-only vfs.Reader that presents the files of upper on
+// top of the contents of the archive lower.  Stat and Open consult upper first,
+// falling back to lower only if upper reports that the path does not exist, so
+// files in upper take precedence over archive entries with the same path.
+// Glob returns the union of the matches from both, sorted and without
+// duplicates.  Since the result is read-only, there is no way to hide an entry
+// of lower.
+func Overlay(upper vfs.Reader, lower FS) vfs.Reader { return overlay{upper, lower} }
+
+type overlay struct {
+	upper vfs.Reader
+	lower FS
+}
+
+// Stat implements part of vfs.Reader.
+func (o overlay) Stat(ctx context.Context, path string) (os.FileInfo, error) {
+	fi, err := o.upper.Stat(ctx, path)
+	if os.IsNotExist(err) {
+		return o.lower.Stat(ctx, path)
+	}
+	return fi, err
+}
+
+// Open implements part of vfs.Reader.
+func (o overlay) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	rc, err := o.upper.Open(ctx, path)
+	if os.IsNotExist(err) {
+		return o.lower.Open(ctx, path)
+	}
+	return rc, err
+}
+
+// Glob implements part of vfs.Reader.
+func (o overlay) Glob(ctx context.Context, glob string) ([]string, error) {
+	names, err := o.upper.Glob(ctx, glob)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := o.lower.Glob(ctx, glob)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, name := range names {
+		seen[name] = true
+	}
+	for _, name := range matches {
+		if !seen[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,246 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+)
+
+// VerifyChecksums returns an Option that makes Open verify the CRC-32 of each
+// entry it reads against the checksum recorded in the archive.  When the
+// reader reaches the end of the entry, a mismatch is reported by an error that
+// wraps zip.ErrChecksum and names the entry.
+//
+// The archive/zip package performs a similar check itself, but skips it when
+// the recorded checksum is zero; with this option even those entries are
+// checked, so an entry whose checksum was never recorded cannot go unnoticed.
+func VerifyChecksums() Option {
+	return func(z *FS) error {
+		z.verify = true
+		return nil
+	}
+}
+
+// verifyingReader checks the CRC-32 of the data read from an entry when the
+// end of the entry is reached.
+type verifyingReader struct {
+	rc   io.ReadCloser
+	f    *zip.File
+	hash hash.Hash32
+}
+
+func newVerifyingReader(rc io.ReadCloser, f *zip.File) *verifyingReader {
+	return &verifyingReader{rc: rc, f: f, hash: crc32.NewIEEE()}
+}
+
+// Read implements the io.Reader interface.
+func (v *verifyingReader) Read(buf []byte) (int, error) {
+	n, err := v.rc.Read(buf)
+	v.hash.Write(buf[:n])
+	if err == io.EOF {
+		if sum := v.hash.Sum32(); sum != v.f.CRC32 {
+			return n, checksumError(v.f.Name, sum, v.f.CRC32)
+		}
+	} else if err == zip.ErrChecksum {
+		return n, checksumError(v.f.Name, v.hash.Sum32(), v.f.CRC32)
+	}
+	return n, err
+}
+
+// Close implements the io.Closer interface.
+func (v *verifyingReader) Close() error { return v.rc.Close() }
+
+func checksumError(name string, got, want uint32) error {
+	return fmt.Errorf("entry %q has CRC-32 %08x, want %08x: %w", name, got, want, zip.ErrChecksum)
+}
+
+// ErrNameEncoding is reported by Validate for entries whose names are not
+// encoded as their UTF-8 flag says.
+var ErrNameEncoding = errors.New("name does not match its encoding flag")
+
+// Validate checks the central directory of the whole archive, regardless of
+// the root of z, for faults that make names unreliable, without reading the
+// contents of any entry.  It reports, in archive order, an *EntryError for
+// each entry
+//
+//   - that is marked as having a UTF-8 name that is not valid UTF-8, or has a
+//     name that is valid UTF-8, and not ASCII, without being so marked, so that
+//     tools that trust the flag decode it as code page 437 (ErrNameEncoding);
+//   - that has the same name as an earlier entry, or, if z is
+//     CaseInsensitive, a name differing from it only in case
+//     (ErrDuplicateEntry);
+//   - whose name is absolute or refers outside the root (ErrUnsafePath).
+//
+// It returns nil if there are no faults.  Names are reported as stored.
+func (z FS) Validate() []error {
+	var errs []error
+	rc := z.archive() // first, since it may fill in z.decoded
+
+	seen := make(map[string]string, len(rc.File)) // by key
+	for _, f := range rc.File {
+		fail := func(err error) {
+			errs = append(errs, &EntryError{Name: f.Name, Err: err})
+		}
+		if f.Flags&flagUTF8 != 0 {
+			if !utf8.ValidString(f.Name) {
+				fail(fmt.Errorf("marked as UTF-8 but not valid UTF-8: %w", ErrNameEncoding))
+			}
+		} else if !z.decoded[f] && !isASCII(f.Name) && utf8.ValidString(f.Name) {
+			fail(fmt.Errorf("UTF-8 but not marked as such: %w", ErrNameEncoding))
+		}
+		name := cleanName(strings.TrimSuffix(f.Name, "/"))
+		if prev, ok := seen[z.key(name)]; !ok {
+			seen[z.key(name)] = name
+		} else if prev != name {
+			fail(fmt.Errorf("differs only in case from %q: %w", prev, ErrDuplicateEntry))
+		} else {
+			fail(ErrDuplicateEntry)
+		}
+		if !safeName(f.Name) {
+			fail(ErrUnsafePath)
+		}
+	}
+	return errs
+}
+
+// An EntryError records a failure to read a particular archive entry.
+type EntryError struct {
+	Name string // the name of the entry in the archive
+	Err  error
+}
+
+// Error implements the error interface.
+func (e *EntryError) Error() string { return fmt.Sprintf("%s: %v", e.Name, e.Err) }
+
+// Unwrap returns the underlying error.
+func (e *EntryError) Unwrap() error { return e.Err }
+
+// A ValidationError is returned by ValidateAll to report every entry that
+// could not be read intact, and by opening an archive with the
+// RequireSupportedMethods option to report every entry that cannot be
+// decompressed, in order by name.
+type ValidationError []*EntryError
+
+// Error implements the error interface.
+func (v ValidationError) Error() string {
+	msgs := make([]string, len(v))
+	for i, e := range v {
+		msgs[i] = e.Error()
+	}
+	return fmt.Sprintf("%d invalid entries: %s", len(v), strings.Join(msgs, "; "))
+}
+
+func (v ValidationError) Len() int           { return len(v) }
+func (v ValidationError) Less(i, j int) bool { return v[i].Name < v[j].Name }
+func (v ValidationError) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+
+// ValidateAll reads every entry of the archive, using up to parallelism
+// concurrent workers (or runtime.GOMAXPROCS(0) if parallelism <= 0), and
+// verifies each against its recorded size and CRC-32.  If any entries fail,
+// the error is a ValidationError listing all of them.  If ctx ends before
+// validation is complete, its error is returned instead.  The Progress option
+// reports each entry as it is validated.
+func (z FS) ValidateAll(ctx context.Context, parallelism int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if parallelism <= 0 {
+		parallelism = runtime.GOMAXPROCS(0)
+	}
+
+	var entries []*zip.File
+	for _, f := range z.index().entries {
+		if _, ok := z.rel(f); ok {
+			entries = append(entries, f)
+		}
+	}
+	p := newProgress(z.progress, len(entries))
+
+	var (
+		mu       sync.Mutex
+		failures ValidationError
+		wg       sync.WaitGroup
+		work     = make(chan *zip.File)
+	)
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range work {
+				if ctx.Err() != nil {
+					continue // drain the work already queued
+				}
+				if err := z.validate(ctx, f); err != nil && ctx.Err() == nil {
+					mu.Lock()
+					failures = append(failures, &EntryError{Name: f.Name, Err: err})
+					mu.Unlock()
+				}
+				p.add(1)
+			}
+		}()
+	}
+
+	var err error
+feed:
+	for _, f := range entries {
+		select {
+		case work <- f:
+		case <-ctx.Done():
+			err = ctx.Err()
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+	if err == nil {
+		err = ctx.Err() // some entries may not have been read in full
+	}
+
+	if err != nil {
+		return err
+	} else if len(failures) > 0 {
+		sort.Sort(failures)
+		return failures
+	}
+	return nil
+}
+
+// validate reads the contents of f, checking their size and CRC-32, and
+// stopping early if ctx ends.
+func (z FS) validate(ctx context.Context, f *zip.File) error {
+	z.verify = true
+	rc, err := z.openEntry(f)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	_, err = io.Copy(ioutil.Discard, ctxReader{ctx, rc}) // Discard pools its own buffers
+	return err
+}
@@ -0,0 +1,263 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+const (
+	localSignature = "PK\x03\x04" // begins the local header of each entry
+	cdSignature    = "PK\x01\x02" // begins each central directory record
+	cdLen          = 46           // the length of the record, without its name, extra, and comment
+)
+
+// OpenVolumes returns a read-only virtual file system (vfs.Reader), using the
+// contents of a zip archive split across several volumes, as written by
+// "zip -s" and some Windows tools, where parts[i] holds the sizes[i] bytes of
+// volume i (for example, the .z01, .z02, ..., .zip files in that order).  The
+// last volume must hold the end of the central directory.
+//
+// In such an archive each entry records the volume it begins on and its offset
+// within that volume, so OpenVolumes presents the volumes to the zip package
+// as a single archive whose central directory has been rewritten to give
+// offsets into their concatenation.  The offsets are checked against the sizes
+// of the volumes, so that volumes given out of order are reported rather than
+// misread.  Volumes made by simply cutting an archive into pieces, whose
+// offsets are already relative to the start of the first volume, are accepted
+// too.  Split archives that need zip64 records are not supported.
+//
+// As for OpenAt, the parts must be safe for concurrent use.  Closing the FS
+// closes each part that implements io.Closer.
+func OpenVolumes(parts []io.ReaderAt, sizes []int64, opts ...Option) (FS, error) {
+	if len(parts) == 0 || len(parts) != len(sizes) {
+		return FS{}, fmt.Errorf("invalid volumes: got %d parts and %d sizes: %w", len(parts), len(sizes), os.ErrInvalid)
+	}
+	v, err := joinVolumes(parts, sizes)
+	if err != nil {
+		return FS{}, err
+	}
+	return newFS(v, v.size, v, opts)
+}
+
+// volumeError returns an error describing a set of volumes that do not form a
+// zip archive.
+func volumeError(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid volumes: %s: %w", fmt.Sprintf(format, args...), zip.ErrFormat)
+}
+
+// joinVolumes returns the concatenation of the given volumes, with the central
+// directory rewritten if the offsets it records are relative to the volumes.
+func joinVolumes(parts []io.ReaderAt, sizes []int64) (*volumes, error) {
+	last := len(parts) - 1
+	starts := make([]int64, len(parts))
+	var total int64
+	for i, size := range sizes {
+		if size <= 0 {
+			return nil, volumeError("volume %d has size %d", i, size)
+		}
+		starts[i] = total
+		total += size
+	}
+	flat := &volumes{closers: parts}
+	for i, r := range parts {
+		flat.add(r, sizes[i])
+	}
+
+	pos, err := findDirectoryEnd(parts[last], sizes[last])
+	if err != nil {
+		return nil, err
+	}
+	var end [eocdLen]byte
+	if _, err := parts[last].ReadAt(end[:], pos); err != nil && err != io.EOF {
+		return nil, err
+	}
+	pos += starts[last]
+	disk := int(binary.LittleEndian.Uint16(end[4:]))
+	cdDisk := int(binary.LittleEndian.Uint16(end[6:]))
+	cdSize := int64(binary.LittleEndian.Uint32(end[12:]))
+	cdOffset := int64(binary.LittleEndian.Uint32(end[16:]))
+	relative := disk != 0 || cdDisk != 0 // offsets are relative to each volume
+	cdStart := cdOffset
+	if relative {
+		if disk != last {
+			return nil, volumeError("last volume is number %d of the archive, want %d", disk+1, last+1)
+		}
+		if cdDisk > last || cdOffset >= sizes[cdDisk] {
+			return nil, volumeError("central directory at volume %d offset %d is out of range", cdDisk, cdOffset)
+		}
+		cdStart += starts[cdDisk]
+	}
+	if cdStart+cdSize != pos {
+		return nil, volumeError("central directory at %d does not end at %d", cdStart, pos)
+	}
+
+	// Check that each entry begins where the central directory says, and
+	// rewrite the directory to refer to offsets in the concatenation of the
+	// volumes, as if they were a single volume.
+	dir := make([]byte, cdSize+eocdLen+int64(binary.LittleEndian.Uint16(end[20:])))
+	if _, err := flat.ReadAt(dir, cdStart); err != nil && err != io.EOF {
+		return nil, err
+	}
+	var sig [len(localSignature)]byte
+	for rec := dir[:cdSize]; len(rec) > 0; {
+		if len(rec) < cdLen || string(rec[:4]) != cdSignature {
+			return nil, volumeError("malformed central directory")
+		}
+		d := int(binary.LittleEndian.Uint16(rec[34:]))
+		abs := int64(binary.LittleEndian.Uint32(rec[42:]))
+		if d == 0xFFFF || abs == 0xFFFFFFFF {
+			return nil, volumeError("zip64 records are not supported")
+		}
+		if relative {
+			if d > last || abs >= sizes[d] {
+				return nil, volumeError("entry at volume %d offset %d is out of range", d, abs)
+			}
+			abs += starts[d]
+			if abs > 0xFFFFFFFF {
+				return nil, volumeError("zip64 records are not supported")
+			}
+			binary.LittleEndian.PutUint16(rec[34:], 0)
+			binary.LittleEndian.PutUint32(rec[42:], uint32(abs))
+		}
+		if _, err := flat.ReadAt(sig[:], abs); err != nil && err != io.EOF {
+			return nil, err
+		} else if string(sig[:]) != localSignature {
+			return nil, volumeError("no entry at offset %d", abs)
+		}
+
+		n := cdLen + int(binary.LittleEndian.Uint16(rec[28:])) +
+			int(binary.LittleEndian.Uint16(rec[30:])) + int(binary.LittleEndian.Uint16(rec[32:]))
+		if n > len(rec) {
+			return nil, volumeError("malformed central directory")
+		}
+		rec = rec[n:]
+	}
+	if !relative {
+		return flat, nil
+	}
+	tail := dir[cdSize:]
+	binary.LittleEndian.PutUint16(tail[4:], 0)
+	binary.LittleEndian.PutUint16(tail[6:], 0)
+	binary.LittleEndian.PutUint16(tail[8:], binary.LittleEndian.Uint16(tail[10:]))
+	binary.LittleEndian.PutUint32(tail[16:], uint32(cdStart))
+
+	// The data of the entries are read from the volumes, and the rewritten
+	// directory from memory.
+	v := &volumes{closers: parts, rewritten: true}
+	for i, r := range parts {
+		if starts[i] >= cdStart {
+			break
+		}
+		n := sizes[i]
+		if starts[i]+n > cdStart {
+			n = cdStart - starts[i]
+		}
+		v.add(r, n)
+	}
+	v.add(bytes.NewReader(dir), int64(len(dir)))
+	return v, nil
+}
+
+// findDirectoryEnd returns the offset in r of the end of central directory
+// record that ends r, or an error if there is none.
+func findDirectoryEnd(r io.ReaderAt, size int64) (int64, error) {
+	const maxComment = 1<<16 - 1
+	start := size - eocdLen - maxComment
+	if start < 0 {
+		start = 0
+	}
+	buf := make([]byte, size-start)
+	if _, err := r.ReadAt(buf, start); err != nil && err != io.EOF {
+		return 0, err
+	}
+	for i := bytes.LastIndex(buf, []byte(eocdSignature)); i >= 0; i = bytes.LastIndex(buf[:i], []byte(eocdSignature)) {
+		if i+eocdLen <= len(buf) && i+eocdLen+int(binary.LittleEndian.Uint16(buf[i+20:])) == len(buf) {
+			return start + int64(i), nil
+		}
+	}
+	return 0, volumeError("last volume does not end the archive")
+}
+
+// volumes is an io.ReaderAt that reads the concatenation of its parts.
+type volumes struct {
+	parts   []io.ReaderAt
+	starts  []int64 // the offset of each part in the concatenation
+	size    int64
+	closers []io.ReaderAt // the volumes, closed by Close if they are io.Closers
+
+	rewritten bool // whether the central directory differs from that of the volumes
+}
+
+func (v *volumes) add(r io.ReaderAt, size int64) {
+	v.parts = append(v.parts, r)
+	v.starts = append(v.starts, v.size)
+	v.size += size
+}
+
+// ReadAt implements the io.ReaderAt interface.
+func (v *volumes) ReadAt(buf []byte, pos int64) (int, error) {
+	if pos < 0 {
+		return 0, errors.New("negative offset")
+	}
+	var n int
+	for n < len(buf) && pos < v.size {
+		i := sort.Search(len(v.starts), func(i int) bool { return v.starts[i] > pos }) - 1
+		end := v.size
+		if i+1 < len(v.starts) {
+			end = v.starts[i+1]
+		}
+		want := buf[n:]
+		if int64(len(want)) > end-pos {
+			want = want[:end-pos]
+		}
+		m, err := v.parts[i].ReadAt(want, pos-v.starts[i])
+		n += m
+		pos += int64(m)
+		if m < len(want) {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		}
+	}
+	if n < len(buf) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Close closes each of the volumes that implements io.Closer, and returns the
+// first error reported.
+func (v *volumes) Close() error {
+	var first error
+	for _, r := range v.closers {
+		if c, ok := r.(io.Closer); ok {
+			if err := c.Close(); err != nil && first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
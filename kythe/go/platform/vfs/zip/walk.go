@@ -0,0 +1,71 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"io/fs"
+	"os"
+	"path"
+
+	"golang.org/x/net/context"
+)
+
+// Walk walks the file tree of the archive rooted at root, calling fn for each
+// file or directory in the tree, including root, as filepath.Walk does.  The
+// files are visited in lexical order, and directories that have no entries of
+// their own in the archive are visited with synthesized information.  If fn
+// returns fs.SkipDir for a directory, Walk skips the contents of that
+// directory; if it returns fs.SkipDir for a file, Walk skips the remaining
+// files in the same directory.  Walk stops with the error of ctx once ctx is
+// done.
+func (z FS) Walk(ctx context.Context, root string, fn func(path string, info os.FileInfo, err error) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	info, err := z.Stat(ctx, root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = z.walk(ctx, root, info, fn)
+	}
+	if err == fs.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// walk recursively descends dir, calling fn for it and its contents.
+func (z FS) walk(ctx context.Context, dir string, info os.FileInfo, fn func(string, os.FileInfo, error) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fn(dir, info, nil)
+	}
+	infos, err := z.ReadDir(ctx, dir)
+	if err := fn(dir, info, err); err != nil || infos == nil {
+		return err
+	}
+	for _, child := range infos {
+		if err := z.walk(ctx, path.Join(dir, child.Name()), child, fn); err != nil {
+			if err != fs.SkipDir || !child.IsDir() {
+				return err
+			}
+		}
+	}
+	return nil
+}
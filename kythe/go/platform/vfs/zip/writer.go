@@ -0,0 +1,262 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"kythe.io/kythe/go/platform/vfs"
+
+	"golang.org/x/net/context"
+)
+
+var _ vfs.Writer = (*Writer)(nil)
+
+// Writer implements the vfs.Writer interface by writing a zip archive.  Since
+// entries are written sequentially, only one file may be open for writing at a
+// time; the archive is complete once the Writer is closed.  Rename and Remove
+// are not supported.
+type Writer struct {
+	// If Deterministic is true, the archive is reproducible: its bytes depend
+	// only on the names and contents of its entries, and not on the order or
+	// time at which they were written.  Entries are written in order by name,
+	// each with the modification time ModTime, or 1980-01-01 if ModTime is
+	// zero, since earlier times cannot be stored in the DOS date of an entry.  Since the entries cannot be written until all of them are known,
+	// their contents are buffered in memory until the Writer is closed, and
+	// more than one file may be open at a time.
+	//
+	// These fields must be set before any entries are created.
+	Deterministic bool
+	ModTime       time.Time
+
+	mu      sync.Mutex
+	zw      *zip.Writer
+	dirs    map[string]bool // directories already written
+	cur     *entryWriter    // the entry open for writing, if any
+	open    int             // the number of entries open, if Deterministic
+	pending []pendingEntry  // entries to write on Close, if Deterministic
+	done    bool            // the writer has been closed
+}
+
+// A pendingEntry is an entry buffered by a deterministic Writer.
+type pendingEntry struct {
+	fh   *zip.FileHeader
+	data []byte
+}
+
+type byEntryName []pendingEntry
+
+func (b byEntryName) Len() int           { return len(b) }
+func (b byEntryName) Less(i, j int) bool { return b[i].fh.Name < b[j].fh.Name }
+func (b byEntryName) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// NewWriter returns a Writer that writes a zip archive to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{zw: zip.NewWriter(w), dirs: make(map[string]bool)}
+}
+
+// errWriterClosed is returned for operations on a closed Writer.
+var errWriterClosed = errors.New("zip: writer is closed")
+
+// ready reports whether a new entry may be added to the archive.  The caller
+// must hold w.mu.
+func (w *Writer) ready() error {
+	if w.done {
+		return errWriterClosed
+	} else if w.cur != nil && !w.Deterministic {
+		return fmt.Errorf("zip: entry %q is still open", w.cur.name)
+	}
+	return nil
+}
+
+// MkdirAll implements part of vfs.Writer by writing a directory entry for path
+// and each of its parents that does not already have one.
+func (w *Writer) MkdirAll(_ context.Context, path string, mode os.FileMode) error {
+	name, err := cleanPath(path)
+	if err != nil {
+		return &os.PathError{Op: "mkdir", Path: path, Err: err}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.ready(); err != nil {
+		return err
+	}
+	return w.mkdirAll(name, mode)
+}
+
+// mkdirAll writes directory entries for name and its parents.  The caller
+// must hold w.mu.
+func (w *Writer) mkdirAll(name string, mode os.FileMode) error {
+	if isRoot(name) || w.dirs[name] {
+		return nil
+	}
+	if i := strings.LastIndex(name, "/"); i > 0 {
+		if err := w.mkdirAll(name[:i], mode); err != nil {
+			return err
+		}
+	}
+	fh := &zip.FileHeader{Name: name + "/", Method: zip.Store, Modified: w.modTime()}
+	fh.SetMode(mode.Perm() | os.ModeDir)
+	if w.Deterministic {
+		w.pending = append(w.pending, pendingEntry{fh: fh})
+	} else if _, err := w.zw.CreateHeader(fh); err != nil {
+		return err
+	}
+	w.dirs[name] = true
+	return nil
+}
+
+// dosEpoch is the earliest time that the DOS date of an entry can record, and
+// the default modification time of the entries of a deterministic Writer.
+var dosEpoch = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// modTime returns the modification time for a new entry.
+func (w *Writer) modTime() time.Time {
+	if !w.Deterministic {
+		return time.Now()
+	} else if w.ModTime.IsZero() {
+		return dosEpoch
+	}
+	return w.ModTime
+}
+
+// Create implements part of vfs.Writer.  It returns a writer for the contents
+// of a new deflated entry, which must be closed before another entry is
+// created.
+func (w *Writer) Create(_ context.Context, path string) (io.WriteCloser, error) {
+	return w.create(path, time.Time{})
+}
+
+// CreateModified is as Create, but records modTime as the modification time of
+// the entry, rather than the current time or ModTime.  CopyTo uses it to
+// preserve the times of the entries it copies.
+func (w *Writer) CreateModified(_ context.Context, path string, modTime time.Time) (io.WriteCloser, error) {
+	if modTime.IsZero() {
+		return nil, &os.PathError{Op: "create", Path: path, Err: os.ErrInvalid}
+	}
+	return w.create(path, modTime)
+}
+
+// create implements Create and CreateModified.  If modTime is zero, the time
+// is given by w.modTime.
+func (w *Writer) create(path string, modTime time.Time) (io.WriteCloser, error) {
+	name, err := cleanPath(path)
+	if err != nil {
+		return nil, &os.PathError{Op: "create", Path: path, Err: err}
+	} else if isRoot(name) {
+		return nil, &os.PathError{Op: "create", Path: path, Err: os.ErrInvalid}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.ready(); err != nil {
+		return nil, err
+	}
+	if modTime.IsZero() {
+		modTime = w.modTime()
+	}
+	fh := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modTime,
+	}
+	if w.Deterministic {
+		w.open++
+		return &entryWriter{w: w, name: name, fw: new(bytes.Buffer), fh: fh}, nil
+	}
+	fw, err := w.zw.CreateHeader(fh)
+	if err != nil {
+		return nil, err
+	}
+	w.cur = &entryWriter{w: w, name: name, fw: fw}
+	return w.cur, nil
+}
+
+// Rename implements part of vfs.Writer.  It is not supported.
+func (*Writer) Rename(_ context.Context, _, _ string) error { return vfs.ErrNotSupported }
+
+// Remove implements part of vfs.Writer.  It is not supported.
+func (*Writer) Remove(_ context.Context, _ string) error { return vfs.ErrNotSupported }
+
+// Close finishes the archive by writing its central directory.  It does not
+// close the underlying io.Writer.  It is an error if an entry is still open.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.ready(); err != nil {
+		return err
+	} else if w.open > 0 {
+		return fmt.Errorf("zip: %d entries are still open", w.open)
+	}
+	w.done = true
+	sort.Stable(byEntryName(w.pending))
+	for _, e := range w.pending {
+		fw, err := w.zw.CreateHeader(e.fh)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(e.data); err != nil {
+			return err
+		}
+	}
+	w.pending = nil
+	return w.zw.Close()
+}
+
+// entryWriter writes the contents of a single archive entry.
+type entryWriter struct {
+	w    *Writer
+	name string
+	fw   io.Writer       // nil once closed
+	fh   *zip.FileHeader // for a deterministic Writer, fw is a *bytes.Buffer
+}
+
+// Write implements the io.Writer interface.
+func (e *entryWriter) Write(data []byte) (int, error) {
+	if e.fw == nil {
+		return 0, fmt.Errorf("zip: entry %q is closed", e.name)
+	}
+	return e.fw.Write(data)
+}
+
+// Close implements the io.Closer interface.  The contents of the entry are
+// complete once it is closed.
+func (e *entryWriter) Close() error {
+	e.w.mu.Lock()
+	defer e.w.mu.Unlock()
+	if e.fw == nil {
+		return fmt.Errorf("zip: entry %q is already closed", e.name)
+	} else if e.w.done {
+		return errWriterClosed
+	}
+	if e.fh != nil {
+		e.w.pending = append(e.w.pending, pendingEntry{fh: e.fh, data: e.fw.(*bytes.Buffer).Bytes()})
+		e.w.open--
+	} else {
+		e.w.cur = nil
+	}
+	e.fw = nil
+	return nil
+}
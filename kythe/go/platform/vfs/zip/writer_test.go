@@ -0,0 +1,180 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"kythe.io/kythe/go/platform/vfs"
+
+	"golang.org/x/net/context"
+)
+
+// writeFiles writes the given files to w, creating their parent directories.
+func writeFiles(t *testing.T, w vfs.Writer, files ...entry) {
+	ctx := context.Background()
+	for _, f := range files {
+		if i := strings.LastIndex(f.name, "/"); i > 0 {
+			if err := w.MkdirAll(ctx, f.name[:i], 0755); err != nil {
+				t.Fatalf("MkdirAll %q: %v", f.name[:i], err)
+			}
+		}
+		wc, err := w.Create(ctx, f.name)
+		if err != nil {
+			t.Fatalf("Create %q: %v", f.name, err)
+		}
+		if _, err := io.WriteString(wc, f.data); err != nil {
+			t.Fatalf("Write %q: %v", f.name, err)
+		}
+		if err := wc.Close(); err != nil {
+			t.Fatalf("Close %q: %v", f.name, err)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	writeFiles(t, w, entry{"root/units/u1", "unit"}, entry{"root/files/f1", "file"})
+
+	// Only one entry may be open at a time.
+	wc, err := w.Create(ctx, "root/other")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := w.Create(ctx, "root/another"); err == nil {
+		t.Error("Create with an entry open: got nil error, want failure")
+	}
+	if err := w.Close(); err == nil {
+		t.Error("Close with an entry open: got nil error, want failure")
+	}
+	if err := wc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := w.Rename(ctx, "root/other", "root/renamed"); err != vfs.ErrNotSupported {
+		t.Errorf("Rename: got error %v, want %v", err, vfs.ErrNotSupported)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := w.Create(ctx, "root/late"); err == nil {
+		t.Error("Create after Close: got nil error, want failure")
+	}
+
+	z, err := Open(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	var names []string
+	for _, f := range z.Archive.File {
+		names = append(names, f.Name)
+	}
+	want := []string{"root/", "root/units/", "root/units/u1", "root/files/", "root/files/f1", "root/other"}
+	if !equalStrings(names, want) {
+		t.Errorf("Entries: got %q, want %q", names, want)
+	}
+	if f := z.find("root/files/f1"); f.Method != zip.Deflate {
+		t.Errorf("Method of %q: got %d, want %d", f.Name, f.Method, zip.Deflate)
+	}
+	rc, err := z.Open(ctx, "root/units/u1")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer rc.Close()
+	if data, err := ioutil.ReadAll(rc); err != nil || string(data) != "unit" {
+		t.Errorf("Read %q: got (%q, %v), want %q", "root/units/u1", data, err, "unit")
+	}
+}
+
+func TestDeterministicWriter(t *testing.T) {
+	files := []entry{
+		{"root/units/u1", "unit one"},
+		{"root/files/f1", "file one"},
+		{"root/files/f2", "file two"},
+	}
+	write := func(files []entry) []byte {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		w.Deterministic = true
+		writeFiles(t, w, files...)
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	first := write(files)
+	time.Sleep(10 * time.Millisecond)
+	second := write([]entry{files[2], files[0], files[1]})
+	if sha256.Sum256(first) != sha256.Sum256(second) {
+		t.Error("Deterministic archives differ")
+	}
+
+	z, err := Open(bytes.NewReader(first))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	var names []string
+	for _, f := range z.Archive.File {
+		names = append(names, f.Name)
+		if !f.Modified.Equal(dosEpoch) || f.ModifiedDate != 1<<5|1 {
+			t.Errorf("Modified time of %q: got %v (DOS date %#x), want %v", f.Name, f.Modified, f.ModifiedDate, dosEpoch)
+		}
+	}
+	want := []string{"root/", "root/files/", "root/files/f1", "root/files/f2", "root/units/", "root/units/u1"}
+	if !equalStrings(names, want) {
+		t.Errorf("Entries: got %q, want %q", names, want)
+	}
+
+	// The Writer cannot be closed while an entry is open, so that neither the
+	// entry nor its data written after the Writer is closed are left out.
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Deterministic = true
+	fw, err := w.Create(context.Background(), "open.txt")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := w.Close(); err == nil {
+		t.Error("Close with an entry open: got no error")
+	}
+	io.WriteString(fw, "late")
+	if err := fw.Close(); err != nil {
+		t.Errorf("Close of entry: unexpected error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Errorf("Close: unexpected error: %v", err)
+	}
+	if err := fw.Close(); err == nil {
+		t.Error("Close of entry after the Writer: got no error")
+	}
+	z, err = Open(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if len(z.Archive.File) != 1 || z.Archive.File[0].Name != "open.txt" || z.Archive.File[0].UncompressedSize64 != 4 {
+		t.Errorf("Entries: got %v, want %q with 4 bytes", z.Archive.File, "open.txt")
+	}
+}
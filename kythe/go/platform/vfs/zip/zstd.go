@@ -0,0 +1,47 @@
+/*
+ * Copyright 2015 Google Inc. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"fmt"
+)
+
+// methodZstd is the compression method of entries compressed with Zstandard
+// (APPNOTE.TXT section 4.4.5).
+const methodZstd = 93
+
+// newZstdReader returns a reader for the Zstandard-compressed data of r.  It
+// is nil unless the package is built with the "zstd" build tag.
+var newZstdReader zip.Decompressor
+
+// Zstd returns an Option that lets Open and the other methods reading entries
+// decompress those compressed with Zstandard (method 93), as 7-Zip and other
+// recent tools can write them.  The decoder is linked in only when the package
+// is built with the "zstd" build tag, so that programs not needing it avoid
+// the dependency; otherwise, the option fails with an error wrapping
+// zip.ErrAlgorithm.  Without the option, opening such entries fails with
+// zip.ErrAlgorithm.
+func Zstd() Option {
+	return func(z *FS) error {
+		if newZstdReader == nil {
+			return fmt.Errorf("zstd support requires the zstd build tag: %w", zip.ErrAlgorithm)
+		}
+		z.zstd = true
+		return nil
+	}
+}